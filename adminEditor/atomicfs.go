@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// atomicTempMarker is part of the name of every temporary file written by AtomicFileSystem
+	atomicTempMarker = ".tmp-"
+	// backupSuffix is appended to the name of the copy of the prior version of a file
+	backupSuffix = ".bak"
+)
+
+// AtomicFileSystem decorates a FileSystem so that writes never leave a truncated file behind.
+// Data is written to a temporary file next to the target, synced to disk and renamed over the target,
+// so readers see either the old or the new content. Content written in parts goes through CreateTemp.
+type AtomicFileSystem struct {
+	FileSystem
+	logger      *Logger
+	keepBackups bool
+}
+
+// LeftoverTempFile is a temporary file left behind by an interrupted write
+type LeftoverTempFile struct {
+	Path         string    `json:"path"`
+	Target       string    `json:"target"`
+	TargetExists bool      `json:"targetExists"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"modTime"`
+}
+
+// NewAtomicFileSystem wraps a FileSystem with atomic writes.
+// With keepBackups the prior version of a file is kept as "<name>.bak".
+func NewAtomicFileSystem(fs FileSystem, keepBackups bool, logger *Logger) *AtomicFileSystem {
+	return &AtomicFileSystem{
+		FileSystem:  fs,
+		logger:      logger,
+		keepBackups: keepBackups,
+	}
+}
+
+// WriteFile writes data to a temporary file, syncs it and renames it over the target
+func (fs *AtomicFileSystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return fs.writeAtomic(filename, data, perm, fs.keepBackups)
+}
+
+// CreateTemp creates a temporary file next to the target for content that is written in parts, such as an upload.
+// Commit syncs it and renames it over the target, closing it without Commit removes it.
+func (fs *AtomicFileSystem) CreateTemp(name string, perm os.FileMode) (*PendingFile, error) {
+	return fs.createPending(name, perm, fs.keepBackups)
+}
+
+// backup keeps the current content of a file as "<name>.bak" before it is overwritten
+func (fs *AtomicFileSystem) backup(filename string, perm os.FileMode) error {
+	previous, err := fs.FileSystem.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fs.writeAtomic(filename+backupSuffix, previous, perm, false)
+}
+
+// writeAtomic writes a complete file through a pending file
+func (fs *AtomicFileSystem) writeAtomic(filename string, data []byte, perm os.FileMode, keepBackup bool) error {
+	file, err := fs.createPending(filename, perm, keepBackup)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		fs.logger.Error("AtomicFileSystem.WriteFile: Error writing temporary file", zap.String("filename", filename), zap.Error(err))
+		return NewFileSystemError("WriteFile", filename, "Error writing temporary file", err)
+	}
+	return file.Commit()
+}
+
+// createPending creates the temporary file of a write, its commit does the fsync and rename sequence
+func (fs *AtomicFileSystem) createPending(filename string, perm os.FileMode, keepBackup bool) (*PendingFile, error) {
+	dir := filepath.Dir(filename)
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+atomicTempMarker+"*")
+	if err != nil {
+		fs.logger.Error("AtomicFileSystem.WriteFile: Error creating temporary file", zap.String("filename", filename), zap.Error(err))
+		return nil, NewFileSystemError("WriteFile", filename, "Error creating temporary file", err)
+	}
+	tempName := temp.Name()
+
+	// Any failure before the rename leaves the target untouched, the temporary file is removed
+	discard := func() {
+		temp.Close()
+		os.Remove(tempName)
+	}
+	fail := func(message string, cause error) error {
+		discard()
+		fs.logger.Error("AtomicFileSystem.WriteFile: "+message, zap.String("filename", filename), zap.Error(cause))
+		return NewFileSystemError("WriteFile", filename, message, cause)
+	}
+
+	commit := func() error {
+		if err := temp.Chmod(perm); err != nil {
+			return fail("Error setting file permissions", err)
+		}
+		if err := temp.Sync(); err != nil {
+			return fail("Error syncing temporary file", err)
+		}
+		if err := temp.Close(); err != nil {
+			return fail("Error closing temporary file", err)
+		}
+		if keepBackup {
+			if err := fs.backup(filename, perm); err != nil {
+				os.Remove(tempName)
+				return err
+			}
+		}
+		if err := fs.FileSystem.Rename(tempName, filename); err != nil {
+			os.Remove(tempName)
+			return err
+		}
+
+		// Persist the rename itself, not supported on every platform
+		if dirHandle, err := os.Open(dir); err == nil {
+			dirHandle.Sync()
+			dirHandle.Close()
+		}
+		return nil
+	}
+	return &PendingFile{File: temp, commit: commit, discard: discard}, nil
+}
+
+// PendingFile is a new version of a file that is being written, returned by FileSystem.CreateTemp.
+// Commit puts it in place of the target, Close without Commit discards it.
+type PendingFile struct {
+	*os.File
+	commit  func() error
+	discard func()
+	done    bool
+}
+
+// Commit finishes the file and replaces the target with it
+func (f *PendingFile) Commit() error {
+	if f.done {
+		return NewFileSystemError("Commit", f.Name(), "File was already committed or closed", nil)
+	}
+	f.done = true
+	return f.commit()
+}
+
+// Close discards the file unless it was committed
+func (f *PendingFile) Close() error {
+	if !f.done {
+		f.done = true
+		f.discard()
+	}
+	return nil
+}
+
+// Recover looks for temporary files left behind by interrupted writes and reports them.
+// A root is a folder that is searched with its subfolders, or a single file whose temporary files are next to it.
+// The files are not removed, since a leftover may hold the only copy of content that never reached its target.
+func (fs *AtomicFileSystem) Recover(roots []string) ([]LeftoverTempFile, error) {
+	leftovers := []LeftoverTempFile{}
+	report := func(path string, info os.FileInfo) {
+		target, ok := atomicTempTarget(path)
+		if info.IsDir() || !ok {
+			return
+		}
+		_, statErr := os.Stat(target)
+		leftover := LeftoverTempFile{
+			Path:         path,
+			Target:       target,
+			TargetExists: statErr == nil,
+			Size:         info.Size(),
+			ModTime:      info.ModTime(),
+		}
+		leftovers = append(leftovers, leftover)
+		fs.logger.Warn("AtomicFileSystem.Recover: Found temporary file of an interrupted write",
+			zap.String("path", leftover.Path),
+			zap.String("target", leftover.Target),
+			zap.Bool("targetExists", leftover.TargetExists),
+			zap.Time("modTime", leftover.ModTime),
+		)
+	}
+
+	for _, root := range outermostRoots(roots) {
+		info, err := os.Stat(root)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			matches, err := filepath.Glob(filepath.Join(filepath.Dir(root), "."+filepath.Base(root)+atomicTempMarker+"*"))
+			if err != nil {
+				return nil, err
+			}
+			for _, match := range matches {
+				if matchInfo, err := os.Stat(match); err == nil {
+					report(match, matchInfo)
+				}
+			}
+			continue
+		}
+
+		err = fs.FileSystem.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			report(path, info)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fs.logger.Info("AtomicFileSystem.Recover: Recovery pass finished", zap.Int("leftovers", len(leftovers)))
+	return leftovers, nil
+}
+
+// outermostRoots drops the roots that are inside another root, so no folder is searched twice
+func outermostRoots(roots []string) []string {
+	var outermost []string
+	for i, root := range roots {
+		root = filepath.Clean(root)
+		nested := false
+		for j, other := range roots {
+			other = filepath.Clean(other)
+			if (root == other && j < i) || (root != other && strings.HasPrefix(root, other+string(filepath.Separator))) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			outermost = append(outermost, root)
+		}
+	}
+	return outermost
+}
+
+// atomicTempTarget returns the file a temporary file was meant to replace
+func atomicTempTarget(path string) (string, bool) {
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	index := strings.LastIndex(name, atomicTempMarker)
+	if index <= 1 {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(path), name[1:index]), true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newTestAtomicFileSystem(keepBackups bool) *AtomicFileSystem {
+	logger := &Logger{Logger: zap.NewNop()}
+	return NewAtomicFileSystem(NewOSFileSystem(logger), keepBackups, logger)
+}
+
+func TestAtomicFileSystemCreateTemp(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "upload.png")
+	mustWrite(t, target, "old")
+	fs := newTestAtomicFileSystem(true)
+
+	// Until it is committed, the new content is not visible
+	file, err := fs.CreateTemp(target, 0644)
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	if _, err := file.Write([]byte("new")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "old" {
+		t.Fatalf("target before Commit() = %q, want %q", data, "old")
+	}
+	if err := file.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	file.Close()
+	if data, _ := os.ReadFile(target); string(data) != "new" {
+		t.Errorf("target after Commit() = %q, want %q", data, "new")
+	}
+	if data, _ := os.ReadFile(target + backupSuffix); string(data) != "old" {
+		t.Errorf("backup = %q, want %q", data, "old")
+	}
+	if err := file.Commit(); err == nil {
+		t.Error("second Commit() error = nil, want an error")
+	}
+
+	// Closing without Commit discards the content
+	file, err = fs.CreateTemp(target, 0644)
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	file.Write([]byte("partial"))
+	file.Close()
+	if data, _ := os.ReadFile(target); string(data) != "new" {
+		t.Errorf("target after Close() = %q, want %q", data, "new")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"upload.png", "upload.png.bak"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("files = %v, want %v without temporary files", names, want)
+	}
+}
+
+func TestAtomicFileSystemRecover(t *testing.T) {
+	dir := t.TempDir()
+	media := filepath.Join(dir, "media")
+	meta := filepath.Join(media, ".meta")
+	if err := os.MkdirAll(meta, 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "config.yaml")
+	mustWrite(t, config, "server: {}")
+	mustWrite(t, filepath.Join(media, "photo.jpg"), "photo")
+	mustWrite(t, filepath.Join(media, ".photo.jpg"+atomicTempMarker+"1"), "partial")
+	mustWrite(t, filepath.Join(meta, ".photo.jpg.json"+atomicTempMarker+"2"), "{")
+	mustWrite(t, filepath.Join(dir, ".config.yaml"+atomicTempMarker+"3"), "server:")
+	mustWrite(t, filepath.Join(dir, ".other.yaml"+atomicTempMarker+"4"), "not reported")
+
+	fs := newTestAtomicFileSystem(false)
+	leftovers, err := fs.Recover([]string{meta, media, filepath.Join(dir, "missing"), config})
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, leftover := range leftovers {
+		if got[leftover.Target] {
+			t.Errorf("Recover() reported %s twice", leftover.Target)
+		}
+		got[leftover.Target] = leftover.TargetExists
+	}
+	want := map[string]bool{
+		filepath.Join(media, "photo.jpg"):     true,
+		filepath.Join(meta, "photo.jpg.json"): false,
+		config:                                true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Recover() targets = %v, want %v", got, want)
+	}
+}
+
+func TestOutermostRoots(t *testing.T) {
+	roots := []string{"media/.meta", "media", "assets", "media/", "content/en", "content/english", "content"}
+	want := []string{"media", "assets", "content"}
+	if got := outermostRoots(roots); !reflect.DeepEqual(got, want) {
+		t.Errorf("outermostRoots() = %v, want %v", got, want)
+	}
+}
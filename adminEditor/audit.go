@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditPostSave           = "post.save"
+	AuditPostCreate         = "post.create"
+	AuditPostMeta           = "post.meta"
+	AuditPostRestore        = "post.restore"
+	AuditMediaUpload        = "media.upload"
+	AuditMediaProcess       = "media.process"
+	AuditMediaDelete        = "media.delete"
+	AuditMediaMeta          = "media.meta"
+	AuditTaxonomyUpdate     = "taxonomy.update"
+	AuditTaxonomyRegenerate = "taxonomy.regenerate"
+	AuditConfigChange       = "config.change"
+	AuditTokenCreate        = "token.create"
+	AuditTokenRevoke        = "token.revoke"
+)
+
+const (
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 1000
+)
+
+// AuditEntry is a single line of the audit log
+type AuditEntry struct {
+	Time       time.Time         `json:"time"`
+	Action     string            `json:"action"`
+	Actor      string            `json:"actor,omitempty"`
+	RequestID  string            `json:"requestId,omitempty"`
+	Path       string            `json:"path,omitempty"`
+	HashBefore string            `json:"hashBefore,omitempty"`
+	HashAfter  string            `json:"hashAfter,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+// AuditQuery filters the entries returned by AuditLog.Query. Empty fields match everything.
+type AuditQuery struct {
+	Actor     string
+	Action    string
+	Path      string
+	RequestID string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// AuditLog appends entries as JSON lines to a file and rotates it when it grows too large.
+// Rotated files are named "<file>.1" (newest) to "<file>.<maxBackups>" (oldest).
+type AuditLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	logger     *Logger
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewAuditLog opens the audit log for appending, creating its folder if needed
+func NewAuditLog(config AuditConfig, logger *Logger) (*AuditLog, error) {
+	audit := &AuditLog{
+		path:       config.File,
+		maxSize:    int64(config.MaxSizeMB) << 20,
+		maxBackups: config.MaxBackups,
+		logger:     logger,
+	}
+	if err := os.MkdirAll(filepath.Dir(config.File), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create audit log folder: %w", err)
+	}
+	if err := audit.open(); err != nil {
+		return nil, err
+	}
+	return audit, nil
+}
+
+func (a *AuditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", a.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log %s: %w", a.path, err)
+	}
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// Record appends an entry, rotating the file first when the entry would exceed the maximum size
+func (a *AuditLog) Record(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return fmt.Errorf("audit log %s is closed", a.path)
+	}
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotateLocked(); err != nil {
+			if a.file == nil {
+				return err
+			}
+			// The entry is still written to the current file, rotation is tried again with the next one
+			a.logger.Error("AuditLog.Record: Could not rotate audit log", zap.String("path", a.path), zap.Error(err))
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log %s: %w", a.path, err)
+	}
+	return a.file.Sync()
+}
+
+// rotateLocked shifts the rotated files by one, dropping the oldest, and starts a new file.
+// When rotating fails, the current file is opened again, so entries are not lost until a restart.
+func (a *AuditLog) rotateLocked() error {
+	err := a.file.Close()
+	a.file = nil
+	if err != nil {
+		err = fmt.Errorf("failed to close audit log %s: %w", a.path, err)
+	} else {
+		err = a.shiftBackups()
+	}
+	if err != nil {
+		if openErr := a.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+
+	a.logger.Info("AuditLog.rotate: Rotated audit log", zap.String("path", a.path))
+	return a.open()
+}
+
+// shiftBackups renames the current file to the first backup, or removes it when no backups are kept
+func (a *AuditLog) shiftBackups() error {
+	if a.maxBackups > 0 {
+		os.Remove(a.backupPath(a.maxBackups))
+		for i := a.maxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(a.backupPath(i), a.backupPath(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to rotate audit log: %w", err)
+			}
+		}
+		if err := os.Rename(a.path, a.backupPath(1)); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	} else if err := os.Remove(a.path); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return nil
+}
+
+func (a *AuditLog) backupPath(n int) string {
+	return a.path + "." + strconv.Itoa(n)
+}
+
+// Query returns the newest entries matching a query, newest first, searching the current and rotated files
+func (a *AuditLog) Query(query AuditQuery) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entries := []AuditEntry{}
+	files := []string{a.path}
+	for i := 1; i <= a.maxBackups; i++ {
+		files = append(files, a.backupPath(i))
+	}
+	for _, path := range files {
+		fileEntries, err := readAuditFile(path, query)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Lines are in write order, the newest entry of a file is its last line
+		for i := len(fileEntries) - 1; i >= 0; i-- {
+			entries = append(entries, fileEntries[i])
+			if len(entries) == query.Limit {
+				return entries, nil
+			}
+		}
+	}
+	return entries, nil
+}
+
+func readAuditFile(path string, query AuditQuery) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A line cut short by a crash must not hide the rest of the log
+			continue
+		}
+		if query.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+func (q AuditQuery) matches(entry AuditEntry) bool {
+	switch {
+	case q.Actor != "" && entry.Actor != q.Actor && !strings.HasPrefix(entry.Actor, q.Actor+"/"):
+		return false
+	case q.Action != "" && entry.Action != q.Action && !strings.HasPrefix(entry.Action, q.Action+"."):
+		return false
+	case q.Path != "" && !strings.Contains(filepath.ToSlash(entry.Path), q.Path):
+		return false
+	case q.RequestID != "" && entry.RequestID != q.RequestID:
+		return false
+	case !q.From.IsZero() && entry.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && entry.Time.After(q.To):
+		return false
+	}
+	return true
+}
+
+// Close closes the audit log file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// contentHash returns the SHA-256 of a file's content, or "" for a file that did not exist
+func contentHash(content []byte) string {
+	if content == nil {
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileHash returns the content hash of a file, or "" if it cannot be read
+func (app *Application) fileHash(path string) string {
+	content, err := app.fileSystem.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if content == nil {
+		content = []byte{}
+	}
+	return contentHash(content)
+}
+
+// recordAudit appends a mutation to the audit log, with the actor and request ID of the request.
+// A failure to write the audit log is logged, the change itself has already been made.
+func (app *Application) recordAudit(r *http.Request, action, path, hashBefore, hashAfter string, details map[string]string) {
+	if app.audit == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		Path:       path,
+		HashBefore: hashBefore,
+		HashAfter:  hashAfter,
+		Details:    details,
+	}
+	if user, ok := UserFromContext(r.Context()); ok {
+		entry.Actor = user.Actor()
+	}
+	if requestID, ok := r.Context().Value(RequestIDKey{}).(string); ok {
+		entry.RequestID = requestID
+	}
+	if err := app.audit.Record(entry); err != nil {
+		GetLoggerFromContext(r.Context()).Error("recordAudit: Failed to write audit log",
+			zap.String("action", action),
+			zap.String("path", path),
+			zap.Error(err),
+		)
+	}
+}
+
+// handleAudit returns audit log entries, newest first.
+// Supported query parameters: actor, action, path, requestId, from, to and limit.
+func (app *Application) handleAudit(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		logger.Warn("handleAudit: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+	if app.audit == nil {
+		return NewAPIError("audit", "/api/audit", "Audit log is disabled", http.StatusServiceUnavailable, nil)
+	}
+
+	params := r.URL.Query()
+	query := AuditQuery{
+		Actor:     params.Get("actor"),
+		Action:    params.Get("action"),
+		Path:      params.Get("path"),
+		RequestID: params.Get("requestId"),
+		Limit:     defaultAuditQueryLimit,
+	}
+	for name, target := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if parsed, err = time.Parse("2006-01-02", value); err != nil {
+				return NewValidationError(name, "Expected an RFC 3339 timestamp or a date (YYYY-MM-DD)", err)
+			}
+			if name == "to" {
+				// A date covers the whole day
+				parsed = parsed.Add(24*time.Hour - time.Nanosecond)
+			}
+		}
+		*target = parsed
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditQueryLimit {
+			return NewValidationError("limit", fmt.Sprintf("Limit must be between 1 and %d", maxAuditQueryLimit), err)
+		}
+		query.Limit = limit
+	}
+
+	entries, err := app.audit.Query(query)
+	if err != nil {
+		logger.Error("handleAudit: Failed to read audit log", zap.Error(err))
+		return err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+
+	logger.Info("handleAudit: Responding with audit entries", zap.Int("count", len(entries)))
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestAuditLog(t *testing.T, maxBackups int) *AuditLog {
+	t.Helper()
+	audit, err := NewAuditLog(AuditConfig{
+		File:       filepath.Join(t.TempDir(), "audit.jsonl"),
+		MaxSizeMB:  1,
+		MaxBackups: maxBackups,
+	}, &Logger{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("NewAuditLog() error = %v", err)
+	}
+	t.Cleanup(func() { audit.file.Close() })
+	// A few entries fill the file
+	audit.maxSize = 300
+	return audit
+}
+
+func recordTestEntries(t *testing.T, audit *AuditLog, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		entry := AuditEntry{Time: time.Now(), Action: AuditPostSave, Path: "content/en/blog/post-" + strconv.Itoa(i) + ".md"}
+		if err := audit.Record(entry); err != nil {
+			t.Fatalf("Record(%d) error = %v", i, err)
+		}
+	}
+}
+
+func TestAuditLogRotates(t *testing.T) {
+	audit := newTestAuditLog(t, 2)
+	recordTestEntries(t, audit, 0, 20)
+
+	for _, path := range []string{audit.path, audit.backupPath(1), audit.backupPath(2)} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", path, err)
+		}
+		if info.Size() > audit.maxSize {
+			t.Errorf("%s has %d bytes, more than the maximum of %d", path, info.Size(), audit.maxSize)
+		}
+	}
+	if _, err := os.Stat(audit.backupPath(3)); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s) error = %v, want the oldest file to be dropped", audit.backupPath(3), err)
+	}
+
+	entries, err := audit.Query(AuditQuery{Limit: 1})
+	if err != nil || len(entries) != 1 || entries[0].Path != "content/en/blog/post-19.md" {
+		t.Errorf("Query() = %v, %v, want the newest entry", entries, err)
+	}
+}
+
+func TestAuditLogKeepsRecordingWhenRotationFails(t *testing.T) {
+	audit := newTestAuditLog(t, 1)
+
+	// A folder with content in place of the backup cannot be removed or replaced by the rename
+	if err := os.MkdirAll(filepath.Join(audit.backupPath(1), "blocked"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	recordTestEntries(t, audit, 0, 20)
+	if err := os.RemoveAll(audit.backupPath(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := audit.Query(AuditQuery{Limit: 100})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(entries) != 20 {
+		t.Fatalf("Query() returned %d entries, want all 20 in the current file", len(entries))
+	}
+
+	// Once the backup can be written again, the next entry rotates the file
+	recordTestEntries(t, audit, 20, 21)
+	if info, err := os.Stat(audit.backupPath(1)); err != nil || info.IsDir() {
+		t.Errorf("Stat(%s) = %v, %v, want a rotated file", audit.backupPath(1), info, err)
+	}
+	if info, err := os.Stat(audit.path); err != nil || info.Size() > audit.maxSize {
+		t.Errorf("Stat(%s) = %v, %v, want a new file", audit.path, info, err)
+	}
+}
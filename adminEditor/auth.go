@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// dummyPasswordHash is compared against when a username is unknown,
+// so a failed login takes the same time whether or not the user exists
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("admin-editor-dummy-password"), bcrypt.DefaultCost)
+
+// UserKey is the key used to store the authenticated user in the request context
+type UserKey struct{}
+
+// User is a local account of the editor
+type User struct {
+	Username     string   `json:"username" yaml:"username"`
+	PasswordHash string   `json:"-" yaml:"passwordHash"`
+	Role         Role     `json:"role" yaml:"role"`
+	Languages    []string `json:"languages,omitempty" yaml:"languages"`
+
+	// Scopes and TokenID are set when the request was authenticated with an API token
+	Scopes  []Permission `json:"scopes,omitempty" yaml:"-"`
+	TokenID string       `json:"tokenId,omitempty" yaml:"-"`
+}
+
+// usersFile is the layout of the file referenced by auth.usersFile
+type usersFile struct {
+	Users []User `yaml:"users"`
+}
+
+// FileUserStore keeps the local accounts loaded from a YAML file
+type FileUserStore struct {
+	path   string
+	logger *Logger
+	mu     sync.RWMutex
+	users  map[string]User
+}
+
+// NewFileUserStore loads the accounts from a users file.
+// A missing file is logged and leaves the store empty, so every login is rejected until it is created.
+func NewFileUserStore(path string, logger *Logger) (*FileUserStore, error) {
+	store := &FileUserStore{
+		path:   path,
+		logger: logger,
+		users:  make(map[string]User),
+	}
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Load reads the users file again
+func (s *FileUserStore) Load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.logger.Error("FileUserStore.Load: Users file not found, all logins will be rejected. "+
+			"Create it from users.example.yaml, with hashes printed by 'go run . -hash-password'",
+			zap.String("path", s.path))
+		s.mu.Lock()
+		s.users = make(map[string]User)
+		s.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read users file %s: %w", s.path, err)
+	}
+
+	var file usersFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse users file %s: %w", s.path, err)
+	}
+
+	users := make(map[string]User, len(file.Users))
+	for _, user := range file.Users {
+		if user.Username == "" {
+			return fmt.Errorf("users file %s contains a user without username", s.path)
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return fmt.Errorf("users file %s: invalid bcrypt hash for user '%s': %w", s.path, user.Username, err)
+		}
+		if user.Role == "" {
+			s.logger.Warn("FileUserStore.Load: User has no role, using the author role", zap.String("username", user.Username))
+			user.Role = RoleAuthor
+		}
+		if !user.Role.Valid() {
+			return fmt.Errorf("users file %s: unknown role '%s' for user '%s'", s.path, user.Role, user.Username)
+		}
+		if _, exists := users[user.Username]; exists {
+			return fmt.Errorf("users file %s contains user '%s' twice", s.path, user.Username)
+		}
+		users[user.Username] = user
+	}
+
+	s.mu.Lock()
+	s.users = users
+	s.mu.Unlock()
+	s.logger.Info("FileUserStore.Load: Loaded users", zap.String("path", s.path), zap.Int("count", len(users)))
+	return nil
+}
+
+// Authenticate checks a username and password and returns the matching user
+func (s *FileUserStore) Authenticate(username, password string) (*User, error) {
+	s.mu.RLock()
+	user, ok := s.users[username]
+	s.mu.RUnlock()
+
+	hash := dummyPasswordHash
+	if ok {
+		hash = []byte(user.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return nil, NewAuthError("Invalid username or password", nil)
+	}
+	return &user, nil
+}
+
+// Lookup returns a user by name
+func (s *FileUserStore) Lookup(username string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[username]
+	if !ok {
+		return nil, false
+	}
+	return &user, true
+}
+
+// session is a logged in user, stored under the hash of its token
+type session struct {
+	username  string
+	expires   time.Time
+	csrfToken string
+}
+
+// SessionStore keeps the sessions of logged in users in memory.
+// Only a hash of each token is stored, the token itself only lives in the client's cookie.
+type SessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+	ttl      time.Duration
+}
+
+// NewSessionStore creates an empty session store
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{
+		sessions: make(map[string]session),
+		ttl:      ttl,
+	}
+}
+
+// Create starts a session for a user and returns its token
+func (s *SessionStore) Create(username string) (string, time.Time, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate session token: %w", err)
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+	expires := time.Now().Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpiredLocked()
+	s.sessions[hashSessionToken(token)] = session{username: username, expires: expires, csrfToken: csrfToken}
+	return token, expires, nil
+}
+
+// Get returns the user of a session that has not expired
+func (s *SessionStore) Get(token string) (string, bool) {
+	current, ok := s.get(token)
+	return current.username, ok
+}
+
+// CSRFToken returns the CSRF token bound to a session that has not expired
+func (s *SessionStore) CSRFToken(token string) (string, bool) {
+	current, ok := s.get(token)
+	return current.csrfToken, ok
+}
+
+func (s *SessionStore) get(token string) (session, bool) {
+	key := hashSessionToken(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.sessions[key]
+	if !ok {
+		return session{}, false
+	}
+	if time.Now().After(current.expires) {
+		delete(s.sessions, key)
+		return session{}, false
+	}
+	return current, true
+}
+
+// Delete ends a session
+func (s *SessionStore) Delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, hashSessionToken(token))
+}
+
+func (s *SessionStore) removeExpiredLocked() {
+	now := time.Now()
+	for key, current := range s.sessions {
+		if now.After(current.expires) {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+// randomToken returns 32 random bytes, URL safe encoded
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticator ties the user store and the session store to the request cycle
+type Authenticator struct {
+	configProvider ConfigProvider
+	users          UserStore
+	sessions       *SessionStore
+	tokens         *TokenStore
+	logger         *Logger
+}
+
+// NewAuthenticator creates an Authenticator from the auth section of the configuration
+func NewAuthenticator(cp ConfigProvider, logger *Logger) (*Authenticator, error) {
+	config := cp.GetConfig().Auth
+	auth := &Authenticator{
+		configProvider: cp,
+		sessions:       NewSessionStore(config.SessionTTL),
+		logger:         logger,
+	}
+	if !config.Enabled {
+		logger.Warn("NewAuthenticator: Authentication is disabled, the admin API is open to everyone who can reach it")
+		return auth, nil
+	}
+	users, err := NewFileUserStore(config.UsersFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	auth.users = users
+
+	// The tokens file lives outside of the content folders, so it is written without the sandbox
+	tokens, err := NewTokenStore(config.TokensFile, NewAtomicFileSystem(NewOSFileSystem(logger), false, logger), logger)
+	if err != nil {
+		return nil, err
+	}
+	auth.tokens = tokens
+	return auth, nil
+}
+
+// Enabled reports whether requests need to be authenticated
+func (a *Authenticator) Enabled() bool {
+	return a.configProvider.GetConfig().Auth.Enabled && a.users != nil
+}
+
+// userFromRequest returns the user of the session cookie of a request
+func (a *Authenticator) userFromRequest(r *http.Request) (*User, bool) {
+	cookie, err := r.Cookie(a.configProvider.GetConfig().Auth.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+	username, ok := a.sessions.Get(cookie.Value)
+	if !ok {
+		return nil, false
+	}
+	// Accounts removed from the users file lose their sessions
+	return a.users.Lookup(username)
+}
+
+// setSessionCookie sends the session cookie, an empty token clears it
+func (a *Authenticator) setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	config := a.configProvider.GetConfig().Auth
+	cookie := &http.Cookie{
+		Name:     config.CookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   config.SecureCookie || r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expires
+	}
+	http.SetCookie(w, cookie)
+}
+
+// isPublicPath reports whether a path can be requested without being logged in
+func isPublicPath(path string) bool {
+	switch path {
+	case "/api/auth/login", "/login.html", "/js/login.js", "/favicon.ico", "/site.webmanifest":
+		return true
+	}
+	// Stylesheets, fonts and the icons in the root folder are needed to render the login page
+	return strings.HasPrefix(path, "/css/") || strings.HasPrefix(path, "/webfonts/") ||
+		(strings.HasSuffix(path, ".png") && strings.Count(path, "/") == 1)
+}
+
+// isPagePath reports whether a path is a page a browser navigates to, which is redirected to the login page
+func isPagePath(path string) bool {
+	return path == "/" || strings.HasSuffix(path, ".html") || strings.HasPrefix(path, "/swagger/")
+}
+
+// AuthMiddleware rejects requests without a valid session before they reach the handlers.
+// API calls get a 401 response, pages are redirected to the login page.
+func AuthMiddleware(auth *Authenticator, logger *Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Scripts send an API token, which must be valid even on public paths
+			if header := r.Header.Get("Authorization"); header != "" {
+				user, err := auth.userFromBearer(header)
+				if err != nil {
+					GetLoggerFromContext(r.Context()).Warn("AuthMiddleware: Invalid bearer token", zap.Error(err))
+					sendErrorResponse(w, r, http.StatusUnauthorized, err.Error(), "")
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(withActor(r.Context(), user)))
+				return
+			}
+
+			user, ok := auth.userFromRequest(r)
+			if ok {
+				next.ServeHTTP(w, r.WithContext(withActor(r.Context(), user)))
+				return
+			}
+
+			if isPublicPath(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if isPagePath(r.URL.Path) {
+				logger.Debug("AuthMiddleware: Redirecting to login", zap.String("path", r.URL.Path))
+				http.Redirect(w, r, "/login.html?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+
+			GetLoggerFromContext(r.Context()).Warn("AuthMiddleware: Unauthenticated request rejected", zap.String("path", r.URL.Path))
+			sendErrorResponse(w, r, http.StatusUnauthorized, "Authentication required", "")
+		})
+	}
+}
+
+// withActor adds the authenticated user to the context and names it in the request logs
+func withActor(ctx context.Context, user *User) context.Context {
+	ctx = WithUser(ctx, user)
+	logger := GetLoggerFromContext(ctx).WithField("user", user.Username)
+	if user.TokenID != "" {
+		logger = logger.WithField("token_id", user.TokenID)
+	}
+	SetActor(ctx, user.Actor())
+	return WithLogger(ctx, logger)
+}
+
+// Actor names the acting identity of a request, the user and the token used, if any
+func (u *User) Actor() string {
+	if u.TokenID != "" {
+		return u.Username + "/token:" + u.TokenID
+	}
+	return u.Username
+}
+
+// WithUser adds the authenticated user to the context
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, UserKey{}, user)
+}
+
+// UserFromContext returns the authenticated user of a request
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(UserKey{}).(*User)
+	return user, ok
+}
+
+// handleLogin checks the credentials and starts a session
+func (app *Application) handleLogin(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		logger.Warn("handleLogin: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+	if !app.auth.Enabled() {
+		return NewValidationError("auth", "Authentication is disabled", nil)
+	}
+
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		logger.Error("handleLogin: Invalid request body", zap.Error(err))
+		return NewValidationError("request_body", "Invalid request body", err)
+	}
+	if credentials.Username == "" || credentials.Password == "" {
+		return NewValidationError("credentials", "Username and password are required", nil)
+	}
+
+	user, err := app.auth.users.Authenticate(credentials.Username, credentials.Password)
+	if err != nil {
+		logger.Warn("handleLogin: Login failed", zap.String("username", credentials.Username))
+		return err
+	}
+
+	// A login always starts a new session, an existing one is ended
+	if cookie, err := r.Cookie(app.auth.configProvider.GetConfig().Auth.CookieName); err == nil {
+		app.auth.sessions.Delete(cookie.Value)
+	}
+	token, expires, err := app.auth.sessions.Create(user.Username)
+	if err != nil {
+		logger.Error("handleLogin: Failed to create session", zap.Error(err))
+		return err
+	}
+	app.auth.setSessionCookie(w, r, token, expires)
+	csrfToken, _ := app.auth.sessions.CSRFToken(token)
+
+	logger.Info("handleLogin: User logged in", zap.String("username", user.Username))
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"username":  user.Username,
+		"expires":   expires,
+		"csrfToken": csrfToken,
+	})
+}
+
+// handleLogout ends the session of the current user
+func (app *Application) handleLogout(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		logger.Warn("handleLogout: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	if cookie, err := r.Cookie(app.auth.configProvider.GetConfig().Auth.CookieName); err == nil {
+		app.auth.sessions.Delete(cookie.Value)
+	}
+	app.auth.setSessionCookie(w, r, "", time.Time{})
+
+	logger.Info("handleLogout: User logged out")
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleMe returns the logged in user
+func (app *Application) handleMe(w http.ResponseWriter, r *http.Request) error {
+	response := map[string]interface{}{
+		"authEnabled": app.auth.Enabled(),
+	}
+	if user, ok := UserFromContext(r.Context()); ok {
+		response["username"] = user.Username
+		response["role"] = user.Role
+		response["languages"] = user.Languages
+		response["permissions"] = user.Permissions()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
+
+// hashPasswordFromStdin reads a password from standard input and prints its bcrypt hash for the users file
+func hashPasswordFromStdin() error {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	fmt.Println(string(hash))
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPasswordHash is the hash of "secret", with the lowest cost to keep the tests fast
+var testPasswordHash = func() string {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hash)
+}()
+
+// newTestRequest creates a request with a logger that discards its output
+func newTestRequest(method, target string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	return r.WithContext(WithLogger(r.Context(), &Logger{Logger: zap.NewNop()}))
+}
+
+func TestFileUserStoreLoad(t *testing.T) {
+	user := func(name, role string) string {
+		return "  - username: " + name + "\n    passwordHash: \"" + testPasswordHash + "\"\n    role: " + role + "\n"
+	}
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]Role
+		wantErr bool
+	}{
+		{
+			name:    "users with roles",
+			content: "users:\n" + user("alice", "author") + user("root", "admin"),
+			want:    map[string]Role{"alice": RoleAuthor, "root": RoleAdmin},
+		},
+		{
+			name:    "missing role defaults to author",
+			content: "users:\n  - username: bob\n    passwordHash: \"" + testPasswordHash + "\"\n",
+			want:    map[string]Role{"bob": RoleAuthor},
+		},
+		{name: "unknown role", content: "users:\n" + user("alice", "owner"), wantErr: true},
+		{name: "duplicate user", content: "users:\n" + user("alice", "author") + user("alice", "admin"), wantErr: true},
+		{name: "user without name", content: "users:\n" + user(`""`, "author"), wantErr: true},
+		{name: "invalid hash", content: "users:\n  - username: alice\n    passwordHash: secret\n", wantErr: true},
+		{name: "invalid yaml", content: "users: [\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.yaml")
+			mustWrite(t, path, tt.content)
+			store, err := NewFileUserStore(path, &Logger{Logger: zap.NewNop()})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFileUserStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(store.users) != len(tt.want) {
+				t.Errorf("loaded %d users, want %d", len(store.users), len(tt.want))
+			}
+			for name, role := range tt.want {
+				if user, ok := store.Lookup(name); !ok || user.Role != role {
+					t.Errorf("Lookup(%q) = %+v, %v, want role %q", name, user, ok, role)
+				}
+			}
+		})
+	}
+}
+
+func TestFileUserStoreAuthenticate(t *testing.T) {
+	// A missing users file is an empty store that rejects every login
+	store, err := NewFileUserStore(filepath.Join(t.TempDir(), "missing.yaml"), &Logger{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("NewFileUserStore() error = %v", err)
+	}
+	if _, err := store.Authenticate("alice", "secret"); err == nil {
+		t.Error("Authenticate() without users file error = nil, want an error")
+	}
+
+	store.users["alice"] = User{Username: "alice", PasswordHash: testPasswordHash, Role: RoleAuthor}
+	tests := []struct {
+		username string
+		password string
+		wantErr  bool
+	}{
+		{username: "alice", password: "secret"},
+		{username: "alice", password: "wrong", wantErr: true},
+		{username: "alice", password: "", wantErr: true},
+		{username: "bob", password: "secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		user, err := store.Authenticate(tt.username, tt.password)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Authenticate(%q, %q) error = %v, wantErr %v", tt.username, tt.password, err, tt.wantErr)
+		}
+		if err == nil && user.Username != tt.username {
+			t.Errorf("Authenticate(%q) user = %q", tt.username, user.Username)
+		}
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	sessions := NewSessionStore(time.Hour)
+	token, _, err := sessions.Create("alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if username, ok := sessions.Get(token); !ok || username != "alice" {
+		t.Errorf("Get() = %q, %v, want alice", username, ok)
+	}
+	if csrf, ok := sessions.CSRFToken(token); !ok || csrf == "" || csrf == token {
+		t.Errorf("CSRFToken() = %q, %v, want a token of its own", csrf, ok)
+	}
+	if _, ok := sessions.Get(token + "x"); ok {
+		t.Error("Get() of an unknown token = true")
+	}
+	sessions.Delete(token)
+	if _, ok := sessions.Get(token); ok {
+		t.Error("Get() after Delete() = true")
+	}
+
+	expired := NewSessionStore(-time.Second)
+	token, _, err = expired.Create("alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, ok := expired.Get(token); ok {
+		t.Error("Get() of an expired session = true")
+	}
+	if len(expired.sessions) != 0 {
+		t.Errorf("expired session is still stored")
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	app := newTestAuthApp(t)
+	app.auth.users.(*FileUserStore).users["alice"] = User{Username: "alice", Role: RoleAuthor}
+	session, _, err := app.auth.sessions.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, _, err := app.auth.sessions.Create("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.auth.tokens, err = NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"), NewOSFileSystem(app.logger), app.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := app.auth.tokens.Create("alice", "deploy", []Permission{PermPostsRead}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reached *User
+	handler := AuthMiddleware(app.auth, app.logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached, _ = UserFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name     string
+		path     string
+		cookie   string
+		header   string
+		want     int
+		wantUser string
+	}{
+		{name: "api without session", path: "/api/list", want: http.StatusUnauthorized},
+		{name: "page without session", path: "/index.html", want: http.StatusSeeOther},
+		{name: "login page", path: "/login.html", want: http.StatusNoContent},
+		{name: "login api", path: "/api/auth/login", want: http.StatusNoContent},
+		{name: "stylesheet", path: "/css/bundle.min.css", want: http.StatusNoContent},
+		{name: "api with session", path: "/api/list", cookie: session, want: http.StatusNoContent, wantUser: "alice"},
+		{name: "unknown session", path: "/api/list", cookie: "invalid", want: http.StatusUnauthorized},
+		{name: "session of a removed user", path: "/api/list", cookie: removed, want: http.StatusUnauthorized},
+		{name: "bearer token", path: "/api/list", header: "Bearer " + secret, want: http.StatusNoContent, wantUser: "alice"},
+		{name: "other authorization scheme", path: "/api/list", header: "Basic " + secret, want: http.StatusUnauthorized},
+		{name: "invalid bearer token on a public path", path: "/login.html", header: "Bearer aet_invalid", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = nil
+			r := newTestRequest(http.MethodGet, tt.path)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusSeeOther && !strings.HasPrefix(w.Header().Get("Location"), "/login.html?next=") {
+				t.Errorf("Location = %q, want the login page", w.Header().Get("Location"))
+			}
+			if tt.wantUser != "" && (reached == nil || reached.Username != tt.wantUser) {
+				t.Errorf("user of the request = %+v, want %q", reached, tt.wantUser)
+			}
+		})
+	}
+}
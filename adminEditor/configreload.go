@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// configReloadDelay collects the burst of events editors cause when saving a file into a single reload
+const configReloadDelay = 250 * time.Millisecond
+
+// restartSettings are only applied at startup. A reload keeps their running values and reports them as requiring a restart.
+// keepRunningValues must copy exactly these settings.
+var restartSettings = []string{
+	"auth",
+	"audit",
+	"history.enabled",
+	"server.certFile",
+	"server.keyFile",
+	"server.keepBackups",
+	"server.redirectHTTPToHTTPS",
+	"server.shutdownTimeout",
+}
+
+// ConfigChange reports the settings that differ after a reload, as keys like "server.imageResize.maxWidth".
+// Values are left out, so secrets never end up in a response or a log.
+type ConfigChange struct {
+	File            string   `json:"file,omitempty"`
+	Changed         []string `json:"changed"`
+	RequiresRestart []string `json:"requiresRestart"`
+}
+
+// keepRunningValues copies the settings that need a restart from the running configuration
+func keepRunningValues(current, next *Config) {
+	next.Auth = current.Auth
+	next.Audit = current.Audit
+	next.History.Enabled = current.History.Enabled
+	next.Server.CertFile = current.Server.CertFile
+	next.Server.KeyFile = current.Server.KeyFile
+	next.Server.KeepBackups = current.Server.KeepBackups
+	next.Server.RedirectHTTPToHTTPS = current.Server.RedirectHTTPToHTTPS
+	next.Server.ShutdownTimeout = current.Server.ShutdownTimeout
+}
+
+func isRestartSetting(key string) bool {
+	for _, setting := range restartSettings {
+		if key == setting || strings.HasPrefix(key, setting+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffConfig returns the keys of all settings that differ between two configurations.
+// Objects are compared key by key, lists such as shortcodes are compared as a whole.
+func diffConfig(previous, current Config) []string {
+	before, after := map[string]interface{}{}, map[string]interface{}{}
+	flattenConfig("", toJSONValue(previous), before)
+	flattenConfig("", toJSONValue(current), after)
+
+	keys := []string{}
+	for key, value := range before {
+		if other, ok := after[key]; !ok || !reflect.DeepEqual(value, other) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// toJSONValue converts a configuration to its generic JSON form, keyed by the same names as the configuration files
+func toJSONValue(config Config) interface{} {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+	return value
+}
+
+func flattenConfig(prefix string, value interface{}, out map[string]interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		out[prefix] = value
+		return
+	}
+	for key, child := range object {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		flattenConfig(key, child, out)
+	}
+}
+
+// Reload reads the configuration file again and applies it.
+// An invalid configuration is rejected as a whole and the running configuration is kept.
+func (p *AppConfig) Reload() (ConfigChange, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	change := ConfigChange{File: p.viper.ConfigFileUsed(), Changed: []string{}, RequiresRestart: []string{}}
+	if change.File != "" {
+		if err := p.viper.ReadInConfig(); err != nil {
+			return change, fmt.Errorf("error reading config file: %w", err)
+		}
+	}
+
+	current := p.GetConfig()
+	next, err := decodeConfig(p.viper, p.logger.Logger)
+	if err != nil {
+		return change, err
+	}
+	if err := ValidateConfig(*next).check(p.logger.Logger); err != nil {
+		return change, err
+	}
+	for _, key := range diffConfig(current, *next) {
+		if isRestartSetting(key) {
+			change.RequiresRestart = append(change.RequiresRestart, key)
+		} else {
+			change.Changed = append(change.Changed, key)
+		}
+	}
+	keepRunningValues(&current, next)
+
+	if len(change.RequiresRestart) > 0 {
+		p.logger.Warn("AppConfig.Reload: Some changed settings only take effect after a restart", zap.Strings("settings", change.RequiresRestart))
+	}
+	if len(change.Changed) == 0 {
+		p.logger.Info("AppConfig.Reload: Configuration unchanged", zap.String("file", change.File))
+		return change, nil
+	}
+
+	p.config.Store(next)
+	p.logger.Info("AppConfig.Reload: Applied configuration", zap.String("file", change.File), zap.Strings("changed", change.Changed))
+	for _, fn := range p.subscribers {
+		fn(current, *next)
+	}
+	return change, nil
+}
+
+// ConfigFile returns the path of the configuration file in use, or "" when the configuration comes from defaults only
+func (p *AppConfig) ConfigFile() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.viper.ConfigFileUsed()
+}
+
+// Subscribe registers a function that is called after every applied reload with the previous and the new configuration.
+// Subscribers are called synchronously and must not call Reload or Subscribe.
+func (p *AppConfig) Subscribe(fn func(previous, current Config)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subscribers = append(p.subscribers, fn)
+}
+
+// Watch reloads the configuration whenever its file changes, until Close is called
+func (p *AppConfig) Watch() error {
+	file := p.viper.ConfigFileUsed()
+	if file == "" {
+		p.logger.Info("AppConfig.Watch: No configuration file in use, nothing to watch")
+		return nil
+	}
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to create file watcher: %w", err)
+	}
+	// Editors often replace the file instead of writing to it, which only the folder sees
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("unable to watch %s: %w", filepath.Dir(file), err)
+	}
+
+	p.mu.Lock()
+	p.watcher = watcher
+	p.mu.Unlock()
+
+	go p.watchLoop(watcher, file)
+	p.logger.Info("AppConfig.Watch: Watching configuration file for changes", zap.String("file", file))
+	return nil
+}
+
+// watchLoop reloads the configuration once the events of a save have settled
+func (p *AppConfig) watchLoop(watcher *fsnotify.Watcher, file string) {
+	var reload <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == file && (event.Has(fsnotify.Write) || event.Has(fsnotify.Create)) {
+				reload = time.After(configReloadDelay)
+			}
+		case <-reload:
+			reload = nil
+			if _, err := p.Reload(); err != nil {
+				p.logger.Error("AppConfig.watchLoop: Changed configuration was rejected, keeping the running configuration", zap.Error(err))
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			p.logger.Error("AppConfig.watchLoop: File watcher error", zap.Error(err))
+		}
+	}
+}
+
+// Close stops watching the configuration file
+func (p *AppConfig) Close() error {
+	p.mu.Lock()
+	watcher := p.watcher
+	p.watcher = nil
+	p.mu.Unlock()
+
+	if watcher == nil {
+		return nil
+	}
+	return watcher.Close()
+}
+
+// subscribeToConfig keeps the parts of the application that copy settings at startup in line with the configuration.
+// Everything else reads the configuration on every request and follows a reload by itself.
+func (app *Application) subscribeToConfig(sandbox *SandboxFileSystem) {
+	app.configProvider.Subscribe(func(previous, current Config) {
+		if !reflect.DeepEqual(sandboxRootsFromConfig(previous), sandboxRootsFromConfig(current)) {
+			if err := bootstrapFolders(current, app.logger); err != nil {
+				app.logger.Error("subscribeToConfig: Could not create the new folders", zap.Error(err))
+			}
+			if err := sandbox.SetRoots(sandboxRootsFromConfig(current)); err != nil {
+				app.logger.Error("subscribeToConfig: Could not apply the new folders, file access keeps the previous folders", zap.Error(err))
+			} else {
+				app.logger.Info("subscribeToConfig: Applied new folders to the file system sandbox")
+			}
+		}
+
+		if !reflect.DeepEqual(previous.Languages, current.Languages) || !reflect.DeepEqual(previous.Sections, current.Sections) {
+			if err := app.postIndex.Reload(); err != nil {
+				app.logger.Error("subscribeToConfig: Could not rebuild the post index", zap.Error(err))
+			} else if err := app.searchIndex.Build(); err != nil {
+				app.logger.Error("subscribeToConfig: Could not rebuild the search index", zap.Error(err))
+			}
+		}
+	})
+}
+
+// handleConfigReload reads the configuration file again and reports the settings that changed
+func (app *Application) handleConfigReload(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		logger.Warn("handleConfigReload: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	change, err := app.configProvider.Reload()
+	if err != nil {
+		logger.Warn("handleConfigReload: Configuration rejected", zap.Error(err))
+		return NewValidationError("config", "Configuration is not valid, the running configuration was kept", err)
+	}
+	if len(change.Changed) > 0 || len(change.RequiresRestart) > 0 {
+		app.recordAudit(r, AuditConfigChange, change.File, "", "", map[string]string{
+			"changed":         strings.Join(change.Changed, ","),
+			"requiresRestart": strings.Join(change.RequiresRestart, ","),
+		})
+	}
+
+	logger.Info("handleConfigReload: Reloaded configuration",
+		zap.Int("changed", len(change.Changed)),
+		zap.Int("requiresRestart", len(change.RequiresRestart)),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(change)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func TestIsRestartSetting(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "auth", want: true},
+		{key: "auth.sessionTTL", want: true},
+		{key: "audit.maxSizeMB", want: true},
+		{key: "history.enabled", want: true},
+		{key: "history.authorName"},
+		{key: "server.certFile", want: true},
+		{key: "server.certFileBackup"},
+		{key: "server.shutdownTimeout", want: true},
+		{key: "server.port"},
+		{key: "server.imageResize.maxWidth"},
+		{key: "authors"},
+		{key: "shortcodes"},
+	}
+	for _, tt := range tests {
+		if got := isRestartSetting(tt.key); got != tt.want {
+			t.Errorf("isRestartSetting(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	var base Config
+	base.Server.Port = 8081
+	base.Server.ImageResize.MaxWidth = 2800
+	base.Auth.CookieName = "session"
+	base.Languages = map[string]LanguageConfig{"en": {ContentFolder: "content/en", Default: true}}
+	base.Shortcodes = []Shortcode{{ID: "bold", Code: "**text**"}}
+
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		want   []string
+	}{
+		{name: "unchanged", change: func(config *Config) {}, want: []string{}},
+		{
+			name:   "nested settings",
+			change: func(config *Config) { config.Server.ImageResize.MaxWidth = 1200; config.Auth.CookieName = "other" },
+			want:   []string{"auth.cookieName", "server.imageResize.maxWidth"},
+		},
+		{
+			name:   "added language",
+			change: func(config *Config) { config.Languages["de"] = LanguageConfig{ContentFolder: "content/de"} },
+			want:   []string{"languages.de.contentFolder", "languages.de.default", "languages.de.name"},
+		},
+		{
+			name:   "removed language",
+			change: func(config *Config) { delete(config.Languages, "en") },
+			want:   []string{"languages.en.contentFolder", "languages.en.default", "languages.en.name"},
+		},
+		{
+			name:   "list compared as a whole",
+			change: func(config *Config) { config.Shortcodes[0].Code = "__text__" },
+			want:   []string{"shortcodes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := base
+			current.Languages = map[string]LanguageConfig{}
+			for code, language := range base.Languages {
+				current.Languages[code] = language
+			}
+			current.Shortcodes = append([]Shortcode(nil), base.Shortcodes...)
+			tt.change(&current)
+			if got := diffConfig(base, current); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeTestConfig writes a valid configuration file with a max image width and a session cookie name
+func writeTestConfig(t *testing.T, path string, maxWidth int, cookieName string) {
+	t.Helper()
+	dir := filepath.Dir(path)
+	mustWrite(t, path, fmt.Sprintf(`server:
+  mediaFolder: %q
+  assetFolder: %q
+  certFile: ""
+  keyFile: ""
+  imageResize:
+    maxWidth: %d
+auth:
+  cookieName: %s
+`, filepath.Join(dir, "media"), filepath.Join(dir, "assets"), maxWidth, cookieName))
+}
+
+// newTestReloadableConfig loads a configuration file the way LoadConfig does, so it can be reloaded
+func newTestReloadableConfig(t *testing.T, path string) *AppConfig {
+	t.Helper()
+	logger := &Logger{Logger: zap.NewNop()}
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	config, err := decodeConfig(v, logger.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateConfig(*config).check(logger.Logger); err != nil {
+		t.Fatalf("test configuration is invalid: %v", err)
+	}
+	return NewAppConfig(config, v, logger)
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestConfig(t, path, 1000, "session")
+	provider := newTestReloadableConfig(t, path)
+	notified := 0
+	provider.Subscribe(func(previous, current Config) {
+		notified++
+		if previous.Server.ImageResize.MaxWidth != 1000 || current.Server.ImageResize.MaxWidth != 1200 {
+			t.Errorf("subscriber got max width %d -> %d, want 1000 -> 1200",
+				previous.Server.ImageResize.MaxWidth, current.Server.ImageResize.MaxWidth)
+		}
+	})
+
+	writeTestConfig(t, path, 1200, "other")
+	change, err := provider.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if !reflect.DeepEqual(change.Changed, []string{"server.imageResize.maxWidth"}) {
+		t.Errorf("Changed = %v, want the max width", change.Changed)
+	}
+	if !reflect.DeepEqual(change.RequiresRestart, []string{"auth.cookieName"}) {
+		t.Errorf("RequiresRestart = %v, want the cookie name", change.RequiresRestart)
+	}
+	config := provider.GetConfig()
+	if config.Server.ImageResize.MaxWidth != 1200 || config.Auth.CookieName != "session" {
+		t.Errorf("config after Reload() has max width %d and cookie %q, want 1200 and the running cookie",
+			config.Server.ImageResize.MaxWidth, config.Auth.CookieName)
+	}
+	if notified != 1 {
+		t.Errorf("subscriber called %d times, want once", notified)
+	}
+
+	// A change that needs a restart only is not applied and notifies nobody
+	writeTestConfig(t, path, 1200, "third")
+	if change, err = provider.Reload(); err != nil || len(change.Changed) != 0 {
+		t.Errorf("Reload() = %+v, %v, want only settings that need a restart", change, err)
+	}
+	if notified != 1 {
+		t.Errorf("subscriber called %d times, want once", notified)
+	}
+}
+
+func TestReloadKeepsRunningConfigOnInvalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "unparsable file", content: "server: [\n"},
+		{name: "invalid setting", content: "server:\n  imageResize:\n    maxWidth: 0\n  certFile: \"\"\n  keyFile: \"\"\n"},
+		{name: "invalid duration", content: "server:\n  shutdownTimeout: soon\n  certFile: \"\"\n  keyFile: \"\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			writeTestConfig(t, path, 1000, "session")
+			provider := newTestReloadableConfig(t, path)
+			provider.Subscribe(func(previous, current Config) {
+				t.Error("subscriber called for an invalid configuration")
+			})
+			before := provider.GetConfig()
+
+			mustWrite(t, path, tt.content)
+			if _, err := provider.Reload(); err == nil {
+				t.Fatal("Reload() error = nil, want an error")
+			}
+			if after := provider.GetConfig(); !reflect.DeepEqual(after, before) {
+				t.Errorf("config changed after a failed Reload(): %v", diffConfig(before, after))
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ConfigIssue is a problem found in the configuration. Field is the key as written in the configuration file.
+type ConfigIssue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ConfigValidation lists the problems of a configuration. Errors keep it from being used, warnings do not.
+type ConfigValidation struct {
+	Errors   []ConfigIssue `json:"errors"`
+	Warnings []ConfigIssue `json:"warnings"`
+}
+
+func (r *ConfigValidation) errorf(field, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, ConfigIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *ConfigValidation) warnf(field, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, ConfigIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Valid reports whether the configuration has no errors
+func (r ConfigValidation) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Err returns all errors as a single error, or nil for a valid configuration
+func (r ConfigValidation) Err() error {
+	if r.Valid() {
+		return nil
+	}
+	messages := make([]string, 0, len(r.Errors))
+	for _, issue := range r.Errors {
+		messages = append(messages, issue.Field+": "+issue.Message)
+	}
+	return fmt.Errorf("configuration validation failed: %s", strings.Join(messages, "; "))
+}
+
+// check logs the warnings and returns the errors of a validation
+func (r ConfigValidation) check(logger *zap.Logger) error {
+	for _, issue := range r.Warnings {
+		logger.Warn("Configuration warning", zap.String("field", issue.Field), zap.String("message", issue.Message))
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	logger.Info("Configuration validation passed", zap.Int("warnings", len(r.Warnings)))
+	return nil
+}
+
+// ValidateConfig checks a configuration without side effects: it binds no ports and creates no folders,
+// so it gives the same answer at startup, on a reload and for --check-config.
+// Whether a port is free is only known when the servers bind it.
+func ValidateConfig(config Config) ConfigValidation {
+	result := ConfigValidation{Errors: []ConfigIssue{}, Warnings: []ConfigIssue{}}
+
+	// Ports
+	server := config.Server
+	if server.Port <= 0 || server.Port > 65535 {
+		result.errorf("server.port", "invalid server port: %d. Must be between 1 and 65535", server.Port)
+	}
+	if server.HTTPSPort <= 0 || server.HTTPSPort > 65535 {
+		result.errorf("server.httpsPort", "invalid HTTPS server port: %d. Must be between 1 and 65535", server.HTTPSPort)
+	} else if server.Port == server.HTTPSPort {
+		result.errorf("server.httpsPort", "HTTP port and HTTPS port cannot be the same: %d", server.Port)
+	}
+
+	// Languages, sections and shortcodes
+	if err := validateLanguages(config.Languages); err != nil {
+		result.errorf("languages", "%v", err)
+	}
+	if err := validateSections(config.Sections); err != nil {
+		result.errorf("sections", "%v", err)
+	}
+	validateShortcodes(&result, config.Shortcodes)
+
+	// Folders, media and asset folders are created by bootstrapFolders
+	folders := []struct {
+		field   string
+		path    string
+		created bool
+	}{
+		{"server.mediaFolder", server.MediaFolder, true},
+		{"server.assetFolder", server.AssetFolder, true},
+	}
+	for _, code := range config.LanguageCodes() {
+		folders = append(folders, struct {
+			field   string
+			path    string
+			created bool
+		}{"languages." + code + ".contentFolder", config.Languages[code].ContentFolder, false})
+	}
+	for _, folder := range folders {
+		if folder.path == "" {
+			result.errorf(folder.field, "path cannot be empty")
+			continue
+		}
+		info, err := os.Stat(folder.path)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && folder.created:
+			result.warnf(folder.field, "folder %s does not exist, it is created when the configuration is applied", folder.path)
+		case errors.Is(err, os.ErrNotExist):
+			result.warnf(folder.field, "folder %s does not exist", folder.path)
+		case err != nil:
+			result.warnf(folder.field, "folder %s cannot be read: %v", folder.path, err)
+		case !info.IsDir():
+			result.errorf(folder.field, "%s is not a folder", folder.path)
+		}
+	}
+
+	// Image processing
+	for field, method := range map[string]string{
+		"server.imageResize.method":     server.ImageResize.Method,
+		"server.thumbnailResize.method": server.ThumbnailResize.Method,
+	} {
+		if method != "fit" && method != "fill" && method != "resize" {
+			result.errorf(field, "invalid resize method: %s. Must be 'fit', 'fill', or 'resize'", method)
+		}
+	}
+	if server.ImageResize.MaxWidth <= 0 {
+		result.errorf("server.imageResize.maxWidth", "invalid image resize max width: %d. Must be greater than 0", server.ImageResize.MaxWidth)
+	}
+	if server.ThumbnailResize.MaxWidth <= 0 {
+		result.errorf("server.thumbnailResize.maxWidth", "invalid thumbnail resize max width: %d. Must be greater than 0", server.ThumbnailResize.MaxWidth)
+	}
+	validateImageVariants(&result, server.ImageResize.Variants)
+
+	if server.ShutdownTimeout <= 0 {
+		result.errorf("server.shutdownTimeout", "invalid server shutdown timeout: %s. Must be a positive duration like '15s'", server.ShutdownTimeout)
+	}
+
+	// Certificates
+	switch {
+	case server.CertFile != "" && server.KeyFile != "":
+		if _, err := tls.LoadX509KeyPair(server.CertFile, server.KeyFile); err != nil {
+			result.errorf("server.certFile", "failed to load certificate/key pair: %v", err)
+		}
+	case server.CertFile != "" || server.KeyFile != "":
+		result.warnf("server.certFile", "certFile and keyFile must both be set, HTTPS will not be available")
+	case server.RedirectHTTPToHTTPS:
+		result.warnf("server.redirectHTTPToHTTPS", "HTTPS redirection is enabled, but certFile or keyFile is not configured. HTTPS will not be available.")
+	}
+
+	// Version history
+	if config.History.Enabled && (config.History.AuthorName == "" || config.History.AuthorEmail == "") {
+		result.errorf("history", "history is enabled but authorName or authorEmail is not configured")
+	}
+
+	// Authentication
+	if config.Auth.Enabled {
+		if config.Auth.UsersFile == "" {
+			result.errorf("auth.usersFile", "auth is enabled but no usersFile is configured")
+		}
+		if config.Auth.TokensFile == "" {
+			result.errorf("auth.tokensFile", "auth is enabled but no tokensFile is configured")
+		}
+		if config.Auth.SessionTTL <= 0 {
+			result.errorf("auth.sessionTTL", "invalid auth session TTL: %s. Must be a positive duration like '12h'", config.Auth.SessionTTL)
+		}
+	} else {
+		result.warnf("auth.enabled", "authentication is disabled, the admin API is open to everyone who can reach it")
+	}
+
+	// Audit log
+	if config.Audit.Enabled {
+		if config.Audit.File == "" {
+			result.errorf("audit.file", "audit log is enabled but no file is configured")
+		}
+		if config.Audit.MaxSizeMB < 1 {
+			result.errorf("audit.maxSizeMB", "invalid audit maxSizeMB: %d. Must be at least 1", config.Audit.MaxSizeMB)
+		}
+		if config.Audit.MaxBackups < 0 {
+			result.errorf("audit.maxBackups", "invalid audit maxBackups: %d. Must not be negative", config.Audit.MaxBackups)
+		}
+	}
+
+	// Secrets
+	if config.Secrets.ImagePigAPIKey == "" {
+		result.warnf("secrets.imagePigAPIKey", "IMAGEPIG_API_KEY not found in environment variables or configuration file. Image generation features may not work.")
+	}
+
+	return result
+}
+
+// bootstrapFolders creates the media and asset folders of a configuration.
+// It runs after validation, before the folders are used.
+func bootstrapFolders(config Config, logger *Logger) error {
+	mediaMeta := filepath.Join(config.Server.MediaFolder, mediaMetaFolder)
+	for _, folder := range []string{config.Server.MediaFolder, mediaMeta, config.Server.AssetFolder} {
+		if _, err := os.Stat(folder); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		logger.Info("bootstrapFolders: Creating folder", zap.String("folder", folder))
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			return fmt.Errorf("failed to create folder %s: %w", folder, err)
+		}
+	}
+	return nil
+}
+
+// checkConfigFile validates the configuration of the environment and prints every problem.
+// It returns an error when the configuration has errors.
+func checkConfigFile() error {
+	v, err := readConfig(zap.NewNop())
+	if err != nil {
+		return err
+	}
+	if file := v.ConfigFileUsed(); file != "" {
+		fmt.Printf("Configuration file: %s\n", file)
+	} else {
+		fmt.Println("No configuration file found, using defaults and environment variables")
+	}
+	config, err := decodeConfig(v, zap.NewNop())
+	if err != nil {
+		return err
+	}
+
+	result := ValidateConfig(*config)
+	for _, issue := range result.Errors {
+		fmt.Printf("error    %s: %s\n", issue.Field, issue.Message)
+	}
+	for _, issue := range result.Warnings {
+		fmt.Printf("warning  %s: %s\n", issue.Field, issue.Message)
+	}
+	if !result.Valid() {
+		return fmt.Errorf("configuration has %d errors", len(result.Errors))
+	}
+	fmt.Printf("Configuration is valid (%d warnings)\n", len(result.Warnings))
+	return nil
+}
+
+// handleConfigValidate validates the configuration file as it would be applied by a reload
+func (app *Application) handleConfigValidate(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		logger.Warn("handleConfigValidate: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	// A fresh viper instance, so validating never touches the running configuration
+	v, err := readConfig(zap.NewNop())
+	if err != nil {
+		return NewValidationError("config", "Configuration file cannot be read", err)
+	}
+	config, err := decodeConfig(v, zap.NewNop())
+	if err != nil {
+		return NewValidationError("config", "Configuration file cannot be decoded", err)
+	}
+	result := ValidateConfig(*config)
+
+	logger.Info("handleConfigValidate: Validated configuration",
+		zap.Int("errors", len(result.Errors)),
+		zap.Int("warnings", len(result.Warnings)),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(struct {
+		File  string `json:"file,omitempty"`
+		Valid bool   `json:"valid"`
+		ConfigValidation
+	}{v.ConfigFileUsed(), result.Valid(), result})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// validTestConfig returns a configuration without errors or warnings, with its folders in a temporary folder
+func validTestConfig(t *testing.T) Config {
+	t.Helper()
+	dir := t.TempDir()
+	var config Config
+	config.Server.Port = 8081
+	config.Server.HTTPSPort = 8443
+	config.Server.MediaFolder = filepath.Join(dir, "media")
+	config.Server.AssetFolder = filepath.Join(dir, "assets")
+	config.Server.ImageResize.Method = "fit"
+	config.Server.ImageResize.MaxWidth = 2800
+	config.Server.ThumbnailResize.Method = "fill"
+	config.Server.ThumbnailResize.MaxWidth = 400
+	config.Server.ShutdownTimeout = 15 * time.Second
+	config.Languages = map[string]LanguageConfig{"en": {ContentFolder: filepath.Join(dir, "content"), Default: true}}
+	config.Sections = []SectionConfig{{Name: "blog", Default: true}}
+	config.Auth = AuthConfig{Enabled: true, UsersFile: "users.yaml", TokensFile: "tokens.json", SessionTTL: time.Hour}
+	config.Secrets.ImagePigAPIKey = "key"
+	for _, folder := range []string{config.Server.MediaFolder, config.Server.AssetFolder, filepath.Join(dir, "content")} {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return config
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		change      func(config *Config)
+		wantError   string
+		wantWarning string
+	}{
+		{name: "valid", change: func(config *Config) {}},
+		{name: "port out of range", change: func(config *Config) { config.Server.Port = 70000 }, wantError: "server.port"},
+		{name: "same ports", change: func(config *Config) { config.Server.HTTPSPort = 8081 }, wantError: "server.httpsPort"},
+		{
+			name: "no default language",
+			change: func(config *Config) {
+				language := config.Languages["en"]
+				language.Default = false
+				config.Languages["en"] = language
+			},
+			wantError: "languages",
+		},
+		{name: "no sections", change: func(config *Config) { config.Sections = nil }, wantError: "sections"},
+		{name: "unknown resize method", change: func(config *Config) { config.Server.ImageResize.Method = "stretch" }, wantError: "server.imageResize.method"},
+		{name: "zero thumbnail width", change: func(config *Config) { config.Server.ThumbnailResize.MaxWidth = 0 }, wantError: "server.thumbnailResize.maxWidth"},
+		{name: "empty media folder", change: func(config *Config) { config.Server.MediaFolder = "" }, wantError: "server.mediaFolder"},
+		{
+			name: "asset folder is a file",
+			change: func(config *Config) {
+				config.Server.AssetFolder = filepath.Join(config.Server.AssetFolder, "file")
+				mustWrite(t, config.Server.AssetFolder, "")
+			},
+			wantError: "server.assetFolder",
+		},
+		{name: "zero shutdown timeout", change: func(config *Config) { config.Server.ShutdownTimeout = 0 }, wantError: "server.shutdownTimeout"},
+		{name: "missing certificate files", change: func(config *Config) { config.Server.CertFile, config.Server.KeyFile = "missing.pem", "missing.key" }, wantError: "server.certFile"},
+		{name: "history without author", change: func(config *Config) { config.History.Enabled = true }, wantError: "history"},
+		{name: "auth without users file", change: func(config *Config) { config.Auth.UsersFile = "" }, wantError: "auth.usersFile"},
+		{name: "auth without session TTL", change: func(config *Config) { config.Auth.SessionTTL = 0 }, wantError: "auth.sessionTTL"},
+		{name: "audit without file", change: func(config *Config) { config.Audit = AuditConfig{Enabled: true, MaxSizeMB: 10} }, wantError: "audit.file"},
+		{
+			name: "audit with negative backups",
+			change: func(config *Config) {
+				config.Audit = AuditConfig{Enabled: true, File: "audit.jsonl", MaxSizeMB: 10, MaxBackups: -1}
+			},
+			wantError: "audit.maxBackups",
+		},
+		{
+			name: "variant without width",
+			change: func(config *Config) {
+				config.Server.ImageResize.Variants = []ImageVariant{{Name: "small", Method: "fit"}}
+			},
+			wantError: "server.imageResize.variants[0].width",
+		},
+		{name: "media folder created later", change: func(config *Config) { config.Server.MediaFolder += "-new" }, wantWarning: "server.mediaFolder"},
+		{name: "missing content folder", change: func(config *Config) { config.Languages["en"] = LanguageConfig{ContentFolder: "missing", Default: true} }, wantWarning: "languages.en.contentFolder"},
+		{name: "certificate without key", change: func(config *Config) { config.Server.CertFile = "cert.pem" }, wantWarning: "server.certFile"},
+		{name: "redirect without certificate", change: func(config *Config) { config.Server.RedirectHTTPToHTTPS = true }, wantWarning: "server.redirectHTTPToHTTPS"},
+		{name: "auth disabled", change: func(config *Config) { config.Auth = AuthConfig{} }, wantWarning: "auth.enabled"},
+		{name: "no image API key", change: func(config *Config) { config.Secrets.ImagePigAPIKey = "" }, wantWarning: "secrets.imagePigAPIKey"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validTestConfig(t)
+			tt.change(&config)
+			result := ValidateConfig(config)
+
+			if got := issueFields(result.Errors); tt.wantError == "" && len(got) > 0 || tt.wantError != "" && !got[tt.wantError] {
+				t.Errorf("errors = %+v, want %q", result.Errors, tt.wantError)
+			}
+			if got := issueFields(result.Warnings); tt.wantWarning == "" && len(got) > 0 || tt.wantWarning != "" && !got[tt.wantWarning] {
+				t.Errorf("warnings = %+v, want %q", result.Warnings, tt.wantWarning)
+			}
+			// Warnings alone keep a configuration usable
+			if result.Valid() != (tt.wantError == "") || (result.Err() == nil) != result.Valid() {
+				t.Errorf("Valid() = %v, Err() = %v, want valid %v", result.Valid(), result.Err(), tt.wantError == "")
+			}
+		})
+	}
+}
+
+func issueFields(issues []ConfigIssue) map[string]bool {
+	fields := make(map[string]bool, len(issues))
+	for _, issue := range issues {
+		fields[issue.Field] = true
+	}
+	return fields
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// csrfHeader is the request header that carries the CSRF token of the session
+const csrfHeader = "X-CSRF-Token"
+
+// CSRFProtection guards cookie authenticated requests against cross-site request forgery.
+// Every request that is not GET, HEAD or OPTIONS must send the synchronizer token of its session in X-CSRF-Token.
+type CSRFProtection struct {
+	auth   *Authenticator
+	logger *Logger
+	// exempt lists paths that never need a token, a trailing "/" covers all paths below it
+	exempt []string
+	// bearerExempt lists paths that API clients may call with a bearer token instead of a session
+	bearerExempt []string
+}
+
+// NewCSRFProtection creates the CSRF protection for the sessions of an Authenticator
+func NewCSRFProtection(auth *Authenticator, logger *Logger) *CSRFProtection {
+	return &CSRFProtection{
+		auth:   auth,
+		logger: logger,
+		// There is no session to bind a token to before the login
+		exempt: []string{"/api/auth/login"},
+	}
+}
+
+// AllowTokenClients opts routes out of the CSRF check for requests authenticated with an API token.
+// Browsers never attach an Authorization header by themselves, so these requests cannot be forged.
+func (c *CSRFProtection) AllowTokenClients(paths ...string) {
+	c.bearerExempt = append(c.bearerExempt, paths...)
+}
+
+// Middleware validates the CSRF token of state changing requests
+func (c *CSRFProtection) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.auth.Enabled() || isSafeMethod(r.Method) || matchesPath(c.exempt, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "" {
+			if matchesPath(c.bearerExempt, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			c.reject(w, r, "This route does not accept API tokens")
+			return
+		}
+
+		cookie, err := r.Cookie(c.auth.configProvider.GetConfig().Auth.CookieName)
+		if err != nil {
+			// Without a session the request is rejected by the authentication anyway
+			next.ServeHTTP(w, r)
+			return
+		}
+		expected, ok := c.auth.sessions.CSRFToken(cookie.Value)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sent := r.Header.Get(csrfHeader)
+		if sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+			c.reject(w, r, "Missing or invalid CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *CSRFProtection) reject(w http.ResponseWriter, r *http.Request, message string) {
+	GetLoggerFromContext(r.Context()).Warn("CSRFProtection: Request rejected",
+		zap.String("path", r.URL.Path),
+		zap.String("reason", message),
+	)
+	sendErrorResponse(w, r, http.StatusForbidden, message, "csrf")
+}
+
+// isSafeMethod reports whether a method must not change state, so it needs no CSRF token
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// matchesPath reports whether a path is in a list, entries ending in "/" match everything below them
+func matchesPath(paths []string, path string) bool {
+	for _, candidate := range paths {
+		if candidate == path || (strings.HasSuffix(candidate, "/") && strings.HasPrefix(path, candidate)) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCSRFToken returns the CSRF token of the current session
+func (app *Application) handleCSRFToken(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		logger.Warn("handleCSRFToken: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	token := ""
+	if app.auth.Enabled() {
+		cookie, err := r.Cookie(app.auth.configProvider.GetConfig().Auth.CookieName)
+		if err != nil {
+			return NewAuthError("A login session is required", err)
+		}
+		var ok bool
+		if token, ok = app.auth.sessions.CSRFToken(cookie.Value); !ok {
+			return NewAuthError("A login session is required", nil)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
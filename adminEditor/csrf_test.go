@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFProtectionMiddleware(t *testing.T) {
+	app := newTestAuthApp(t)
+	session, _, err := app.auth.sessions.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrfToken, _ := app.auth.sessions.CSRFToken(session)
+	protection := NewCSRFProtection(app.auth, app.logger)
+	protection.AllowTokenClients("/api/save", "/api/media/")
+	handler := protection.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		session       string
+		token         string
+		authorization string
+		want          int
+	}{
+		{name: "GET without token", method: http.MethodGet, path: "/api/save", session: session, want: http.StatusNoContent},
+		{name: "HEAD without token", method: http.MethodHead, path: "/api/save", session: session, want: http.StatusNoContent},
+		{name: "OPTIONS without token", method: http.MethodOptions, path: "/api/save", session: session, want: http.StatusNoContent},
+		{name: "login without token", method: http.MethodPost, path: "/api/auth/login", session: session, want: http.StatusNoContent},
+		{name: "POST with token", method: http.MethodPost, path: "/api/save", session: session, token: csrfToken, want: http.StatusNoContent},
+		{name: "POST without token", method: http.MethodPost, path: "/api/save", session: session, want: http.StatusForbidden},
+		{name: "POST with wrong token", method: http.MethodPost, path: "/api/save", session: session, token: csrfToken + "x", want: http.StatusForbidden},
+		{name: "POST with session id as token", method: http.MethodPost, path: "/api/save", session: session, token: session, want: http.StatusForbidden},
+		{name: "DELETE without token", method: http.MethodDelete, path: "/api/media/photo.png", session: session, want: http.StatusForbidden},
+		{name: "POST below a login path", method: http.MethodPost, path: "/api/auth/login/other", session: session, want: http.StatusForbidden},
+		{name: "bearer on an allowed path", method: http.MethodPost, path: "/api/save", authorization: "Bearer aet_x", want: http.StatusNoContent},
+		{name: "bearer below an allowed prefix", method: http.MethodDelete, path: "/api/media/photo.png", authorization: "Bearer aet_x", want: http.StatusNoContent},
+		{name: "bearer on another path", method: http.MethodPost, path: "/api/config", authorization: "Bearer aet_x", want: http.StatusForbidden},
+		{name: "bearer with session on another path", method: http.MethodPost, path: "/api/config", session: session, token: csrfToken, authorization: "Bearer aet_x", want: http.StatusForbidden},
+		// Requests without a valid session are rejected by the authentication
+		{name: "POST without session", method: http.MethodPost, path: "/api/save", want: http.StatusNoContent},
+		{name: "POST with unknown session", method: http.MethodPost, path: "/api/save", session: "invalid", want: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(tt.method, tt.path)
+			if tt.session != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.session})
+			}
+			if tt.token != "" {
+				r.Header.Set(csrfHeader, tt.token)
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFProtectionWithoutAuthentication(t *testing.T) {
+	app := newTestAuthApp(t)
+	app.auth.users = nil
+	handler := NewCSRFProtection(app.auth, app.logger).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newTestRequest(http.MethodPost, "/api/config"))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want every request allowed", w.Code)
+	}
+}
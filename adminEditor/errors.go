@@ -90,11 +90,76 @@ func (e *FileSystemError) StatusCode() int {
 	return http.StatusInternalServerError
 }
 
+// ConflictError represents a write based on a stale version of a file
+type ConflictError struct {
+	Path          string
+	Message       string
+	ServerETag    string
+	ServerContent string
+}
+
+// Error implements the error interface
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict on path '%s': %s", e.Path, e.Message)
+}
+
+// StatusCode returns the HTTP status code for this error
+func (e *ConflictError) StatusCode() int {
+	return http.StatusConflict
+}
+
+// AuthError represents a request without valid credentials
+type AuthError struct {
+	Message string
+	Cause   error
+}
+
+// Error implements the error interface
+func (e *AuthError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("authentication error: %s (cause: %v)", e.Message, e.Cause)
+	}
+	return fmt.Sprintf("authentication error: %s", e.Message)
+}
+
+// Unwrap returns the underlying cause
+func (e *AuthError) Unwrap() error {
+	return e.Cause
+}
+
+// StatusCode returns the HTTP status code for this error
+func (e *AuthError) StatusCode() int {
+	return http.StatusUnauthorized
+}
+
+// ForbiddenError represents a request by a user who lacks a permission
+type ForbiddenError struct {
+	Permission string
+	Message    string
+}
+
+// Error implements the error interface
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("permission '%s' denied: %s", e.Permission, e.Message)
+}
+
+// StatusCode returns the HTTP status code for this error
+func (e *ForbiddenError) StatusCode() int {
+	return http.StatusForbidden
+}
+
 // ErrorResponse represents a standardized error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Details string `json:"details,omitempty"`
-	Code    int    `json:"code"`
+	Error   string          `json:"error"`
+	Details string          `json:"details,omitempty"`
+	Code    int             `json:"code"`
+	Server  *ServerRevision `json:"server,omitempty"`
+}
+
+// ServerRevision is the current server copy of a file, sent along with a conflict
+type ServerRevision struct {
+	ETag    string `json:"etag"`
+	Content string `json:"content"`
 }
 
 // NewValidationError creates a new ValidationError
@@ -127,6 +192,32 @@ func NewFileSystemError(operation, path, message string, cause error) *FileSyste
 	}
 }
 
+// NewConflictError creates a new ConflictError
+func NewConflictError(path, message, serverETag, serverContent string) *ConflictError {
+	return &ConflictError{
+		Path:          path,
+		Message:       message,
+		ServerETag:    serverETag,
+		ServerContent: serverContent,
+	}
+}
+
+// NewAuthError creates a new AuthError
+func NewAuthError(message string, cause error) *AuthError {
+	return &AuthError{
+		Message: message,
+		Cause:   cause,
+	}
+}
+
+// NewForbiddenError creates a new ForbiddenError
+func NewForbiddenError(permission, message string) *ForbiddenError {
+	return &ForbiddenError{
+		Permission: permission,
+		Message:    message,
+	}
+}
+
 // HTTPError is an interface for errors that can return HTTP status codes
 type HTTPError interface {
 	error
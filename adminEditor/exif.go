@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// EXIF tags of the IFDs the media pipeline reads and writes
+const (
+	exifTagImageDescription   = 0x010e
+	exifTagMake               = 0x010f
+	exifTagModel              = 0x0110
+	exifTagOrientation        = 0x0112
+	exifTagSoftware           = 0x0131
+	exifTagArtist             = 0x013b
+	exifTagCopyright          = 0x8298
+	exifTagExposureTime       = 0x829a
+	exifTagFNumber            = 0x829d
+	exifTagExifIFD            = 0x8769
+	exifTagISO                = 0x8827
+	exifTagGPSIFD             = 0x8825
+	exifTagExifVersion        = 0x9000
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagOffsetTimeOriginal = 0x9011
+	exifTagFocalLength        = 0x920a
+	exifTagLensMake           = 0xa433
+	exifTagLensModel          = 0xa434
+)
+
+// exifCreditTags are always kept in processed images
+var exifCreditTags = map[uint16]bool{
+	exifTagArtist:             true,
+	exifTagCopyright:          true,
+	exifTagExifVersion:        true,
+	exifTagDateTimeOriginal:   true,
+	exifTagOffsetTimeOriginal: true,
+}
+
+// exifDeviceTags describe the camera, they are only kept with imageResize.metadata.keepDevice.
+// Serial numbers and the owner name are never kept.
+var exifDeviceTags = map[uint16]bool{
+	exifTagMake:         true,
+	exifTagModel:        true,
+	exifTagSoftware:     true,
+	exifTagExposureTime: true,
+	exifTagFNumber:      true,
+	exifTagISO:          true,
+	exifTagFocalLength:  true,
+	exifTagLensMake:     true,
+	exifTagLensModel:    true,
+}
+
+// exifTypeSizes are the sizes in bytes of the TIFF field types
+var exifTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// exifHeader starts the APP1 segment that holds EXIF data in a JPEG file
+var exifHeader = []byte("Exif\x00\x00")
+
+// PhotoMetadata is the EXIF data of a photo, as far as it is useful for crediting it in a post
+type PhotoMetadata struct {
+	Artist      string `json:"artist,omitempty"`
+	Copyright   string `json:"copyright,omitempty"`
+	Description string `json:"description,omitempty"`
+	DateTaken   string `json:"dateTaken,omitempty"`
+	Camera      string `json:"camera,omitempty"`
+	Orientation int    `json:"orientation,omitempty"`
+	// HasLocation tells whether the original contains GPS data, processed images drop it by default
+	HasLocation bool `json:"hasLocation"`
+}
+
+// exifEntry is a field of an IFD, its value is kept in the byte order of the file it was read from
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// exifByteOrder reads and appends values in the byte order of a TIFF structure
+type exifByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// exifData holds the IFDs of a TIFF structure that matter for processed images
+type exifData struct {
+	order exifByteOrder
+	ifd0  []exifEntry
+	exif  []exifEntry
+	gps   []exifEntry
+}
+
+// jpegExif returns the TIFF structure of the EXIF segment of a JPEG file, or nil when there is none
+func jpegExif(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	for p := 2; p+4 <= len(data) && data[p] == 0xff; {
+		marker := data[p+1]
+		if marker == 0xda || marker == 0xd9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[p+2:]))
+		if length < 2 || p+2+length > len(data) {
+			return nil
+		}
+		segment := data[p+4 : p+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):]
+		}
+		p += 2 + length
+	}
+	return nil
+}
+
+// parseExif reads IFD0 and its EXIF and GPS sub-IFDs. Fields that point outside the data are skipped.
+func parseExif(tiff []byte) (*exifData, error) {
+	if len(tiff) < 8 {
+		return nil, errors.New("exif: data too short")
+	}
+	x := &exifData{}
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		x.order = binary.LittleEndian
+	case "MM\x00*":
+		x.order = binary.BigEndian
+	default:
+		return nil, errors.New("exif: invalid TIFF header")
+	}
+
+	x.ifd0 = x.readIFD(tiff, x.order.Uint32(tiff[4:]))
+	for _, entry := range x.ifd0 {
+		if entry.typ != 4 || entry.count != 1 {
+			continue
+		}
+		switch entry.tag {
+		case exifTagExifIFD:
+			x.exif = x.readIFD(tiff, x.order.Uint32(entry.value))
+		case exifTagGPSIFD:
+			x.gps = x.readIFD(tiff, x.order.Uint32(entry.value))
+		}
+	}
+	return x, nil
+}
+
+func (x *exifData) readIFD(tiff []byte, offset uint32) []exifEntry {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil
+	}
+	n := int(x.order.Uint16(tiff[offset:]))
+	var entries []exifEntry
+	for i := 0; i < n; i++ {
+		p := uint64(offset) + 2 + uint64(i)*12
+		if p+12 > uint64(len(tiff)) {
+			break
+		}
+		field := tiff[p : p+12]
+		entry := exifEntry{tag: x.order.Uint16(field), typ: x.order.Uint16(field[2:]), count: x.order.Uint32(field[4:])}
+		typeSize, ok := exifTypeSizes[entry.typ]
+		if !ok {
+			continue
+		}
+		size := uint64(typeSize) * uint64(entry.count)
+		if size <= 4 {
+			entry.value = append([]byte(nil), field[8:8+size]...)
+		} else {
+			start := uint64(x.order.Uint32(field[8:]))
+			if start+size > uint64(len(tiff)) {
+				continue
+			}
+			entry.value = append([]byte(nil), tiff[start:start+size]...)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func findExifEntry(entries []exifEntry, tag uint16) *exifEntry {
+	for i := range entries {
+		if entries[i].tag == tag {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+func (x *exifData) text(entries []exifEntry, tag uint16) string {
+	entry := findExifEntry(entries, tag)
+	if entry == nil || entry.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+}
+
+// metadata returns the fields of the EXIF data that are useful for crediting a photo
+func (x *exifData) metadata() PhotoMetadata {
+	metadata := PhotoMetadata{
+		Artist:      x.text(x.ifd0, exifTagArtist),
+		Copyright:   x.text(x.ifd0, exifTagCopyright),
+		Description: x.text(x.ifd0, exifTagImageDescription),
+		Camera:      strings.TrimSpace(x.text(x.ifd0, exifTagMake) + " " + x.text(x.ifd0, exifTagModel)),
+		HasLocation: len(x.gps) > 0,
+	}
+	if taken, err := time.Parse("2006:01:02 15:04:05", x.text(x.exif, exifTagDateTimeOriginal)); err == nil {
+		metadata.DateTaken = taken.Format("2006-01-02T15:04:05") + x.text(x.exif, exifTagOffsetTimeOriginal)
+	}
+	if entry := findExifEntry(x.ifd0, exifTagOrientation); entry != nil && entry.typ == 3 && entry.count == 1 {
+		metadata.Orientation = int(x.order.Uint16(entry.value))
+	}
+	return metadata
+}
+
+// sanitized returns an APP1 segment with the credits of the photo, and its location and camera when they
+// are configured to be kept. The orientation is dropped, the pixels of processed images are already rotated.
+// It returns nil when nothing is left to write.
+func (x *exifData) sanitized(keepLocation, keepDevice bool) []byte {
+	keep := func(entries []exifEntry) []exifEntry {
+		var kept []exifEntry
+		for _, entry := range entries {
+			if exifCreditTags[entry.tag] || (keepDevice && exifDeviceTags[entry.tag]) {
+				kept = append(kept, entry)
+			}
+		}
+		return kept
+	}
+	ifd0, exif := keep(x.ifd0), keep(x.exif)
+	var gps []exifEntry
+	if keepLocation {
+		gps = x.gps
+	}
+	if len(exif) == 1 && exif[0].tag == exifTagExifVersion {
+		exif = nil
+	}
+	if len(ifd0) == 0 && len(exif) == 0 && len(gps) == 0 {
+		return nil
+	}
+
+	// The pointers to the sub-IFDs are placeholders until their offsets are known
+	pointer := func(tag uint16) exifEntry {
+		return exifEntry{tag: tag, typ: 4, count: 1, value: make([]byte, 4)}
+	}
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, pointer(exifTagExifIFD))
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, pointer(exifTagGPSIFD))
+	}
+
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset + ifdSize(exif)
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case exifTagExifIFD:
+			x.order.PutUint32(ifd0[i].value, exifOffset)
+		case exifTagGPSIFD:
+			x.order.PutUint32(ifd0[i].value, gpsOffset)
+		}
+	}
+
+	tiff := make([]byte, 8, gpsOffset+ifdSize(gps))
+	if x.order == binary.LittleEndian {
+		copy(tiff, "II*\x00")
+	} else {
+		copy(tiff, "MM\x00*")
+	}
+	x.order.PutUint32(tiff[4:], 8)
+	tiff = x.appendIFD(tiff, ifd0)
+	tiff = x.appendIFD(tiff, exif)
+	tiff = x.appendIFD(tiff, gps)
+
+	segment := append([]byte{0xff, 0xe1, 0, 0}, exifHeader...)
+	segment = append(segment, tiff...)
+	if len(segment)-2 > 0xffff {
+		return nil
+	}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return segment
+}
+
+// ifdSize is the size of an IFD with the values that do not fit into its fields, 0 for an empty IFD
+func ifdSize(entries []exifEntry) uint32 {
+	if len(entries) == 0 {
+		return 0
+	}
+	size := uint32(2 + 12*len(entries) + 4)
+	for _, entry := range entries {
+		if len(entry.value) > 4 {
+			size += uint32(len(entry.value)+1) &^ 1
+		}
+	}
+	return size
+}
+
+// appendIFD appends an IFD, sorted by tag as TIFF requires, followed by its larger values
+func (x *exifData) appendIFD(tiff []byte, entries []exifEntry) []byte {
+	if len(entries) == 0 {
+		return tiff
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+	start := uint32(len(tiff))
+	dataOffset := start + uint32(2+12*len(entries)+4)
+	var data []byte
+
+	tiff = x.order.AppendUint16(tiff, uint16(len(entries)))
+	for _, entry := range entries {
+		tiff = x.order.AppendUint16(tiff, entry.tag)
+		tiff = x.order.AppendUint16(tiff, entry.typ)
+		tiff = x.order.AppendUint32(tiff, entry.count)
+		if len(entry.value) <= 4 {
+			var field [4]byte
+			copy(field[:], entry.value)
+			tiff = append(tiff, field[:]...)
+			continue
+		}
+		tiff = x.order.AppendUint32(tiff, dataOffset+uint32(len(data)))
+		data = append(data, entry.value...)
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	tiff = x.order.AppendUint32(tiff, 0)
+	return append(tiff, data...)
+}
+
+// insertExif puts an APP1 segment right after the start of a JPEG file
+func insertExif(jpeg, segment []byte) []byte {
+	if len(segment) == 0 || len(jpeg) < 2 {
+		return jpeg
+	}
+	result := make([]byte, 0, len(jpeg)+len(segment))
+	result = append(result, jpeg[:2]...)
+	result = append(result, segment...)
+	return append(result, jpeg[2:]...)
+}
+
+// photoExif reads the EXIF data of an image file, it returns nil for files without it
+func photoExif(data []byte) *exifData {
+	tiff := jpegExif(data)
+	if tiff == nil {
+		return nil
+	}
+	x, err := parseExif(tiff)
+	if err != nil {
+		return nil
+	}
+	return x
+}
+
+// handleMediaExif returns the EXIF metadata of a media file, such as the artist to credit in thumbnail.author
+func (app *Application) handleMediaExif(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		logger.Warn("handleMediaExif: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	name := r.PathValue("name")
+	if err := validateRelativePath("name", name); err != nil {
+		return err
+	}
+	config := app.configProvider.GetConfig()
+	data, err := app.fileSystem.ReadFile(filepath.Join(config.Server.MediaFolder, name))
+	if err != nil {
+		logger.Warn("handleMediaExif: Media file not found", zap.String("name", name), zap.Error(err))
+		return NewValidationError("name", "Media file not found", nil)
+	}
+
+	metadata := PhotoMetadata{}
+	if x := photoExif(data); x != nil {
+		metadata = x.metadata()
+	}
+	logger.Info("handleMediaExif: Read metadata", zap.String("name", name), zap.Bool("has_location", metadata.HasLocation))
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(metadata)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func textEntry(tag uint16, value string) exifEntry {
+	return exifEntry{tag: tag, typ: 2, count: uint32(len(value) + 1), value: []byte(value + "\x00")}
+}
+
+// testExifData returns EXIF data with credits, camera, orientation and a location
+func testExifData(order exifByteOrder) *exifData {
+	orientation := exifEntry{tag: exifTagOrientation, typ: 3, count: 1, value: order.AppendUint16(nil, 6)}
+	fNumber := exifEntry{tag: exifTagFNumber, typ: 5, count: 1, value: order.AppendUint32(order.AppendUint32(nil, 28), 10)}
+	latitude := exifEntry{tag: 2, typ: 5, count: 3, value: make([]byte, 24)}
+	return &exifData{
+		order: order,
+		ifd0: []exifEntry{
+			textEntry(exifTagArtist, "Jane Doe"),
+			textEntry(exifTagCopyright, "CC BY 4.0"),
+			textEntry(exifTagImageDescription, "Private note"),
+			textEntry(exifTagMake, "Camera Co"),
+			textEntry(exifTagModel, "X100"),
+			orientation,
+		},
+		exif: []exifEntry{
+			{tag: exifTagExifVersion, typ: 7, count: 4, value: []byte("0232")},
+			textEntry(exifTagDateTimeOriginal, "2024:05:01 12:30:00"),
+			fNumber,
+		},
+		gps: []exifEntry{
+			{tag: 1, typ: 2, count: 2, value: []byte("N\x00")},
+			latitude,
+		},
+	}
+}
+
+// sanitizedTIFF returns the TIFF structure of a sanitized APP1 segment
+func sanitizedTIFF(t *testing.T, x *exifData, keepLocation, keepDevice bool) []byte {
+	t.Helper()
+	segment := x.sanitized(keepLocation, keepDevice)
+	if len(segment) < 10 || segment[0] != 0xff || segment[1] != 0xe1 || !bytes.Equal(segment[4:10], exifHeader) {
+		t.Fatalf("sanitized() = %x, want an EXIF APP1 segment", segment)
+	}
+	if length := int(binary.BigEndian.Uint16(segment[2:])); length != len(segment)-2 {
+		t.Fatalf("segment length = %d, want %d", length, len(segment)-2)
+	}
+	return segment[10:]
+}
+
+func TestSanitizedExif(t *testing.T) {
+	tests := []struct {
+		name         string
+		keepLocation bool
+		keepDevice   bool
+		want         PhotoMetadata
+	}{
+		{
+			name: "credits only",
+			want: PhotoMetadata{Artist: "Jane Doe", Copyright: "CC BY 4.0", DateTaken: "2024-05-01T12:30:00"},
+		},
+		{
+			name:         "with location",
+			keepLocation: true,
+			want:         PhotoMetadata{Artist: "Jane Doe", Copyright: "CC BY 4.0", DateTaken: "2024-05-01T12:30:00", HasLocation: true},
+		},
+		{
+			name:       "with camera",
+			keepDevice: true,
+			want:       PhotoMetadata{Artist: "Jane Doe", Copyright: "CC BY 4.0", DateTaken: "2024-05-01T12:30:00", Camera: "Camera Co X100"},
+		},
+	}
+
+	for _, order := range []exifByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s %v", tt.name, order), func(t *testing.T) {
+				source := testExifData(order)
+				x, err := parseExif(sanitizedTIFF(t, source, tt.keepLocation, tt.keepDevice))
+				if err != nil {
+					t.Fatalf("parseExif() error = %v", err)
+				}
+				if got := x.metadata(); got != tt.want {
+					t.Errorf("metadata() = %+v, want %+v", got, tt.want)
+				}
+				if !tt.keepLocation && len(x.gps) > 0 {
+					t.Errorf("GPS fields = %+v, want none", x.gps)
+				}
+				if kept := findExifEntry(x.exif, exifTagFNumber) != nil; kept != tt.keepDevice {
+					t.Errorf("FNumber kept = %v, want %v", kept, tt.keepDevice)
+				}
+				// The source is not changed by sanitizing it
+				if len(source.ifd0) != 6 || len(source.exif) != 3 || len(source.gps) != 2 {
+					t.Errorf("source IFDs changed: %d, %d, %d fields", len(source.ifd0), len(source.exif), len(source.gps))
+				}
+			})
+		}
+	}
+}
+
+func TestSanitizedExifWithoutCredits(t *testing.T) {
+	x := &exifData{
+		order: binary.LittleEndian,
+		ifd0:  []exifEntry{textEntry(exifTagMake, "Camera Co")},
+		exif:  []exifEntry{{tag: exifTagExifVersion, typ: 7, count: 4, value: []byte("0232")}},
+		gps:   []exifEntry{{tag: 1, typ: 2, count: 2, value: []byte("N\x00")}},
+	}
+	if segment := x.sanitized(false, false); segment != nil {
+		t.Errorf("sanitized() = %x, want nil when only the EXIF version is left", segment)
+	}
+}
+
+func TestJPEGExifRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, halvesImage(8, 8), imaging.JPEG); err != nil {
+		t.Fatal(err)
+	}
+	if jpegExif(buf.Bytes()) != nil {
+		t.Fatal("jpegExif() of a JPEG without EXIF data != nil")
+	}
+	segment := testExifData(binary.BigEndian).sanitized(false, false)
+	data := insertExif(buf.Bytes(), segment)
+	if _, err := imaging.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("JPEG with EXIF data does not decode: %v", err)
+	}
+	x := photoExif(data)
+	if x == nil || x.metadata().Artist != "Jane Doe" {
+		t.Fatalf("photoExif() = %+v, want the inserted EXIF data", x)
+	}
+	for n := 0; n < len(data); n += 7 {
+		photoExif(data[:n])
+	}
+}
+
+func TestParseExifMalformed(t *testing.T) {
+	valid := sanitizedTIFF(t, testExifData(binary.LittleEndian), true, false)
+	// IFD0 starts at offset 8 with its field count, the fields are Artist, Copyright and the EXIF and GPS pointers
+	field := func(i int) int { return 10 + 12*i }
+	corrupt := func(offset int, value uint32) []byte {
+		data := append([]byte(nil), valid...)
+		binary.LittleEndian.PutUint32(data[offset:], value)
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		tiff    []byte
+		wantErr bool
+		want    PhotoMetadata
+	}{
+		{name: "empty", tiff: nil, wantErr: true},
+		{name: "header only", tiff: valid[:7], wantErr: true},
+		{name: "invalid header", tiff: append([]byte("XX*\x00"), valid[4:]...), wantErr: true},
+		{name: "IFD0 outside of the data", tiff: corrupt(4, 0xfffffff0)},
+		{name: "IFD0 at the last byte", tiff: corrupt(4, uint32(len(valid)-1))},
+		{
+			name: "too many fields",
+			tiff: func() []byte {
+				data := append([]byte(nil), valid...)
+				binary.LittleEndian.PutUint16(data[8:], 0xffff)
+				return data
+			}(),
+			want: PhotoMetadata{Artist: "Jane Doe", Copyright: "CC BY 4.0", DateTaken: "2024-05-01T12:30:00", HasLocation: true},
+		},
+		{
+			name: "value outside of the data",
+			tiff: corrupt(field(0)+8, 0xfffffff0),
+			want: PhotoMetadata{Copyright: "CC BY 4.0", DateTaken: "2024-05-01T12:30:00", HasLocation: true},
+		},
+		{
+			name: "value size overflows",
+			tiff: corrupt(field(0)+4, 0x80000000),
+			want: PhotoMetadata{Copyright: "CC BY 4.0", DateTaken: "2024-05-01T12:30:00", HasLocation: true},
+		},
+		{
+			name: "EXIF IFD outside of the data",
+			tiff: corrupt(field(2)+8, 0xfffffff0),
+			want: PhotoMetadata{Artist: "Jane Doe", Copyright: "CC BY 4.0", HasLocation: true},
+		},
+		{
+			name: "GPS IFD points at itself",
+			tiff: corrupt(field(3)+8, 8),
+			want: PhotoMetadata{Artist: "Jane Doe", Copyright: "CC BY 4.0", DateTaken: "2024-05-01T12:30:00", HasLocation: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, err := parseExif(tt.tiff)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExif() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := x.metadata(); got != tt.want {
+				t.Errorf("metadata() = %+v, want %+v", got, tt.want)
+			}
+			x.sanitized(true, true)
+		})
+	}
+
+	// Every truncation of valid data is read without a panic, as far as it goes
+	for n := 0; n <= len(valid); n++ {
+		if x, err := parseExif(valid[:n]); err == nil {
+			x.metadata()
+			x.sanitized(true, true)
+		}
+	}
+}
@@ -0,0 +1,957 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatterFormat identifies how the front matter of a post is encoded
+type FrontMatterFormat string
+
+const (
+	// FrontMatterYAML is front matter between "---" delimiters
+	FrontMatterYAML FrontMatterFormat = "yaml"
+	// FrontMatterTOML is front matter between "+++" delimiters
+	FrontMatterTOML FrontMatterFormat = "toml"
+	// FrontMatterJSON is a JSON object at the start of the file
+	FrontMatterJSON FrontMatterFormat = "json"
+)
+
+// Thumbnail represents the thumbnail block of a post's front matter
+type Thumbnail struct {
+	URL       string                 `json:"url,omitempty"`
+	Author    string                 `json:"author,omitempty"`
+	AuthorURL string                 `json:"authorUrl,omitempty"`
+	Origin    string                 `json:"origin,omitempty"`
+	OriginURL string                 `json:"originUrl,omitempty"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+
+	// plain is set when the thumbnail was written as a bare URL string
+	plain bool
+}
+
+// PostMeta holds the typed front matter of a post. Keys the editor does not know are kept in Params.
+type PostMeta struct {
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	Slug        string                 `json:"slug,omitempty"`
+	Date        string                 `json:"date,omitempty"`
+	LastMod     string                 `json:"lastmod,omitempty"`
+	PublishDate string                 `json:"publishDate,omitempty"`
+	ExpiryDate  string                 `json:"expiryDate,omitempty"`
+	Draft       bool                   `json:"draft"`
+	Tags        []string               `json:"tags,omitempty"`
+	Categories  []string               `json:"categories,omitempty"`
+	Thumbnail   *Thumbnail             `json:"thumbnail,omitempty"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+}
+
+// Post is a parsed Markdown content file
+type Post struct {
+	PostMeta
+	Format FrontMatterFormat `json:"format"`
+	Body   string            `json:"-"`
+
+	// fields keeps the original keys in file order, so unchanged values are written back as they were
+	fields []frontMatterField
+	// original is the metadata as parsed, used to detect which known keys were changed
+	original PostMeta
+	// root is the original YAML mapping node, kept for its comments
+	root *yaml.Node
+	// head is the original front matter including its delimiters, written back verbatim when nothing changed
+	head []byte
+}
+
+// frontMatterField is a single top-level front matter key as found in the file
+type frontMatterField struct {
+	Key     string
+	Value   interface{}
+	keyNode *yaml.Node
+	node    *yaml.Node
+}
+
+// frontMatterKey describes how a known front matter key maps to a PostMeta field
+type frontMatterKey struct {
+	name string
+	get  func(m *PostMeta) interface{}
+	set  func(m *PostMeta, value interface{}, node *yaml.Node) error
+}
+
+// frontMatterDateLayouts are the date layouts Hugo accepts in front matter
+var frontMatterDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05 -0700 MST",
+	"2006-01-02",
+}
+
+// knownFrontMatterKeys lists the keys that are mapped to typed PostMeta fields, in the order new keys are written
+var knownFrontMatterKeys = []frontMatterKey{
+	stringKey("title", func(m *PostMeta) *string { return &m.Title }),
+	stringKey("description", func(m *PostMeta) *string { return &m.Description }),
+	stringKey("slug", func(m *PostMeta) *string { return &m.Slug }),
+	dateKey("date", func(m *PostMeta) *string { return &m.Date }),
+	dateKey("lastmod", func(m *PostMeta) *string { return &m.LastMod }),
+	dateKey("publishDate", func(m *PostMeta) *string { return &m.PublishDate }),
+	dateKey("expiryDate", func(m *PostMeta) *string { return &m.ExpiryDate }),
+	stringListKey("tags", func(m *PostMeta) *[]string { return &m.Tags }),
+	stringListKey("categories", func(m *PostMeta) *[]string { return &m.Categories }),
+	{
+		name: "thumbnail",
+		get:  func(m *PostMeta) interface{} { return m.Thumbnail.value() },
+		set: func(m *PostMeta, value interface{}, _ *yaml.Node) error {
+			thumbnail, err := thumbnailFromValue(value)
+			m.Thumbnail = thumbnail
+			return err
+		},
+	},
+	{
+		name: "draft",
+		get:  func(m *PostMeta) interface{} { return m.Draft },
+		set: func(m *PostMeta, value interface{}, _ *yaml.Node) error {
+			draft, ok := value.(bool)
+			if !ok {
+				return fmt.Errorf("expected a boolean, got %T", value)
+			}
+			m.Draft = draft
+			return nil
+		},
+	},
+}
+
+// ParsePost splits a content file into its typed front matter and body
+func ParsePost(content []byte) (*Post, error) {
+	format, raw, body, err := splitFrontMatter(content)
+	if err != nil {
+		return nil, err
+	}
+
+	post := &Post{Format: format, Body: string(body)}
+	if format != "" {
+		post.head = content[:len(content)-len(body)]
+	}
+	if format == "" {
+		post.Format = FrontMatterYAML
+		return post, nil
+	}
+
+	switch format {
+	case FrontMatterYAML:
+		post.fields, post.root, err = decodeYAMLFrontMatter(raw)
+	case FrontMatterTOML:
+		post.fields, err = decodeTOMLFrontMatter(raw)
+	case FrontMatterJSON:
+		post.fields, err = decodeJSONFrontMatter(raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s front matter: %w", format, err)
+	}
+
+	for _, field := range post.fields {
+		known := lookupFrontMatterKey(field.Key)
+		if known == nil {
+			if post.Params == nil {
+				post.Params = make(map[string]interface{})
+			}
+			post.Params[field.Key] = field.Value
+			continue
+		}
+		if err := known.set(&post.PostMeta, field.Value, field.node); err != nil {
+			return nil, fmt.Errorf("invalid value for front matter key '%s': %w", field.Key, err)
+		}
+	}
+
+	post.original = post.PostMeta.clone()
+	return post, nil
+}
+
+// Bytes encodes the post back into a content file in its original front matter format
+func (p *Post) Bytes() ([]byte, error) {
+	fields, changed := p.mergedFields()
+
+	var buf bytes.Buffer
+	switch {
+	case !changed:
+		// Also keeps a file without front matter as it was
+		buf.Write(p.head)
+	case p.Format == FrontMatterTOML:
+		out, err := encodeTOMLFrontMatter(fields)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("+++\n")
+		buf.Write(out)
+		buf.WriteString("+++\n")
+	case p.Format == FrontMatterJSON:
+		out, err := encodeJSONFrontMatter(fields)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(out)
+		buf.WriteString("\n")
+	default:
+		out, err := encodeYAMLFrontMatter(fields, p.root)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("---\n")
+		buf.Write(out)
+		buf.WriteString("---\n")
+	}
+
+	buf.WriteString(p.Body)
+	return buf.Bytes(), nil
+}
+
+// mergedFields applies the current metadata to the original fields, keeping unchanged values as they were.
+// It also reports whether any field differs from the file.
+func (p *Post) mergedFields() ([]frontMatterField, bool) {
+	var fields []frontMatterField
+	written := make(map[string]bool)
+	kept := 0
+
+	for _, field := range p.fields {
+		if known := lookupFrontMatterKey(field.Key); known != nil {
+			written[known.name] = true
+			if sameValue(known.get(&p.original), known.get(&p.PostMeta)) {
+				fields = append(fields, field)
+				kept++
+				continue
+			}
+			// A key that is already in the file keeps false values, such as "draft: false"
+			if value := known.get(&p.PostMeta); !isEmptyValue("", value) {
+				fields = append(fields, frontMatterField{Key: field.Key, Value: value, keyNode: field.keyNode})
+			}
+			continue
+		}
+
+		written[field.Key] = true
+		value, ok := p.Params[field.Key]
+		if !ok {
+			continue
+		}
+		if sameValue(field.Value, value) {
+			fields = append(fields, field)
+			kept++
+			continue
+		}
+		fields = append(fields, frontMatterField{Key: field.Key, Value: value, keyNode: field.keyNode})
+	}
+
+	for _, known := range knownFrontMatterKeys {
+		if written[known.name] {
+			continue
+		}
+		if value := known.get(&p.PostMeta); !isEmptyValue(known.name, value) {
+			fields = append(fields, frontMatterField{Key: known.name, Value: value})
+		}
+	}
+
+	var params []string
+	for key := range p.Params {
+		if !written[key] && lookupFrontMatterKey(key) == nil {
+			params = append(params, key)
+		}
+	}
+	sort.Strings(params)
+	for _, key := range params {
+		fields = append(fields, frontMatterField{Key: key, Value: p.Params[key]})
+	}
+
+	return fields, kept != len(p.fields) || len(fields) != len(p.fields)
+}
+
+// Validate checks the typed metadata for values Hugo would reject
+func (m PostMeta) Validate() error {
+	dates := map[string]string{
+		"date":        m.Date,
+		"lastmod":     m.LastMod,
+		"publishDate": m.PublishDate,
+		"expiryDate":  m.ExpiryDate,
+	}
+	for field, value := range dates {
+		if value == "" {
+			continue
+		}
+		if _, err := parseFrontMatterDate(value); err != nil {
+			return NewValidationError(field, fmt.Sprintf("Invalid date '%s'", value), err)
+		}
+	}
+	for key := range m.Params {
+		if lookupFrontMatterKey(key) != nil {
+			return NewValidationError("params."+key, "Known front matter keys cannot be set as params", nil)
+		}
+	}
+	return nil
+}
+
+// PublishedAt returns the parsed post date, if it has a valid one
+func (m PostMeta) PublishedAt() (time.Time, bool) {
+	if m.Date == "" {
+		return time.Time{}, false
+	}
+	date, err := parseFrontMatterDate(m.Date)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// clone returns a deep copy of the metadata
+func (m PostMeta) clone() PostMeta {
+	c := m
+	if m.Tags != nil {
+		c.Tags = append([]string{}, m.Tags...)
+	}
+	if m.Categories != nil {
+		c.Categories = append([]string{}, m.Categories...)
+	}
+	if m.Thumbnail != nil {
+		thumbnail := *m.Thumbnail
+		thumbnail.Params = cloneParams(m.Thumbnail.Params)
+		c.Thumbnail = &thumbnail
+	}
+	c.Params = cloneParams(m.Params)
+	return c
+}
+
+// value converts the thumbnail to its front matter representation
+func (t *Thumbnail) value() interface{} {
+	if t == nil {
+		return nil
+	}
+	if t.plain && t.Author == "" && t.AuthorURL == "" && t.Origin == "" && t.OriginURL == "" && len(t.Params) == 0 {
+		return t.URL
+	}
+
+	value := &frontMatterMap{}
+	value.set("url", t.URL)
+	value.set("author", t.Author)
+	value.set("authorURL", t.AuthorURL)
+	value.set("origin", t.Origin)
+	value.set("originURL", t.OriginURL)
+	var params []string
+	for key := range t.Params {
+		params = append(params, key)
+	}
+	sort.Strings(params)
+	for _, key := range params {
+		value.set(key, t.Params[key])
+	}
+	if len(value.keys) == 0 {
+		return nil
+	}
+	return value
+}
+
+// frontMatterMap is a nested front matter map that keeps its keys in insertion order when encoded
+type frontMatterMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// set adds a key unless the value is an empty string
+func (m *frontMatterMap) set(key string, value interface{}) {
+	if s, ok := value.(string); ok && s == "" {
+		return
+	}
+	if m.values == nil {
+		m.values = make(map[string]interface{})
+	}
+	if _, exists := m.values[key]; !exists {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (m *frontMatterMap) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, key := range m.keys {
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(m.values[key]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, valueNode)
+	}
+	return node, nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (m *frontMatterMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		keyJSON, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		valueJSON, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(keyJSON)
+		buf.WriteString(":")
+		buf.Write(valueJSON)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// thumbnailFromValue converts a decoded thumbnail value, either a URL or a map, into a Thumbnail
+func thumbnailFromValue(value interface{}) (*Thumbnail, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return &Thumbnail{URL: v, plain: true}, nil
+	case map[string]interface{}:
+		thumbnail := &Thumbnail{}
+		for key, param := range v {
+			s, isString := param.(string)
+			switch strings.ToLower(key) {
+			case "url":
+				if isString {
+					thumbnail.URL = s
+					continue
+				}
+			case "author":
+				if isString {
+					thumbnail.Author = s
+					continue
+				}
+			case "authorurl":
+				if isString {
+					thumbnail.AuthorURL = s
+					continue
+				}
+			case "origin":
+				if isString {
+					thumbnail.Origin = s
+					continue
+				}
+			case "originurl":
+				if isString {
+					thumbnail.OriginURL = s
+					continue
+				}
+			}
+			if thumbnail.Params == nil {
+				thumbnail.Params = make(map[string]interface{})
+			}
+			thumbnail.Params[key] = param
+		}
+		return thumbnail, nil
+	default:
+		return nil, fmt.Errorf("expected a URL or a map, got %T", value)
+	}
+}
+
+// stringKey maps a front matter key to a string field
+func stringKey(name string, field func(m *PostMeta) *string) frontMatterKey {
+	return frontMatterKey{
+		name: name,
+		get:  func(m *PostMeta) interface{} { return *field(m) },
+		set: func(m *PostMeta, value interface{}, _ *yaml.Node) error {
+			s, err := scalarString(value)
+			*field(m) = s
+			return err
+		},
+	}
+}
+
+// frontMatterDate is a date kept as written in the file. YAML encodes it unquoted, so Hugo reads it as a timestamp.
+type frontMatterDate string
+
+// MarshalYAML implements yaml.Marshaler
+func (d frontMatterDate) MarshalYAML() (interface{}, error) {
+	if _, err := parseFrontMatterDate(string(d)); err != nil {
+		return string(d), nil
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: string(d)}, nil
+}
+
+// dateKey maps a front matter key to a date field, keeping the date as written in the file
+func dateKey(name string, field func(m *PostMeta) *string) frontMatterKey {
+	return frontMatterKey{
+		name: name,
+		get:  func(m *PostMeta) interface{} { return frontMatterDate(*field(m)) },
+		set: func(m *PostMeta, value interface{}, node *yaml.Node) error {
+			if node != nil && node.Kind == yaml.ScalarNode {
+				*field(m) = node.Value
+				return nil
+			}
+			s, err := scalarString(value)
+			*field(m) = s
+			return err
+		},
+	}
+}
+
+// stringListKey maps a front matter key to a list of strings, accepting a single string as well
+func stringListKey(name string, field func(m *PostMeta) *[]string) frontMatterKey {
+	return frontMatterKey{
+		name: name,
+		get:  func(m *PostMeta) interface{} { return *field(m) },
+		set: func(m *PostMeta, value interface{}, _ *yaml.Node) error {
+			switch v := value.(type) {
+			case nil:
+				*field(m) = nil
+			case string:
+				*field(m) = []string{v}
+			case []interface{}:
+				list := make([]string, 0, len(v))
+				for _, item := range v {
+					s, err := scalarString(item)
+					if err != nil {
+						return err
+					}
+					list = append(list, s)
+				}
+				*field(m) = list
+			default:
+				return fmt.Errorf("expected a list, got %T", value)
+			}
+			return nil
+		},
+	}
+}
+
+// lookupFrontMatterKey finds a known key, ignoring case like Hugo does
+func lookupFrontMatterKey(key string) *frontMatterKey {
+	for i := range knownFrontMatterKeys {
+		if strings.EqualFold(knownFrontMatterKeys[i].name, key) {
+			return &knownFrontMatterKeys[i]
+		}
+	}
+	return nil
+}
+
+// scalarString converts a decoded scalar to its string form
+func scalarString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case toml.LocalDate, toml.LocalDateTime, toml.LocalTime:
+		return fmt.Sprint(v), nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("expected a scalar, got %T", value)
+	}
+}
+
+// parseFrontMatterDate parses a front matter date in any of the layouts Hugo accepts
+func parseFrontMatterDate(value string) (time.Time, error) {
+	var lastErr error
+	for _, layout := range frontMatterDateLayouts {
+		date, err := time.Parse(layout, value)
+		if err == nil {
+			return date, nil
+		}
+		lastErr = err
+	}
+	return time.Time{}, lastErr
+}
+
+// isEmptyValue reports whether a known key should be left out of the front matter.
+// A false draft flag is only written when the file already had one.
+func isEmptyValue(name string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case frontMatterDate:
+		return v == ""
+	case []string:
+		return len(v) == 0
+	case bool:
+		return name == "draft" && !v
+	}
+	return false
+}
+
+// sameValue compares two decoded values by their JSON representation, so that
+// numbers and lists decoded from different sources compare equal
+func sameValue(a, b interface{}) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return bytes.Equal(aJSON, bJSON)
+}
+
+// cloneParams returns a shallow copy of a params map
+func cloneParams(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		c[key] = value
+	}
+	return c
+}
+
+// yamlErrorLinePattern extracts the line number from a YAML parser error
+var yamlErrorLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// frontMatterErrorField returns the top-level key at the line a front matter parse error points to.
+// It falls back to "front_matter" when the error has no usable line number.
+func frontMatterErrorField(content string, err error) string {
+	match := yamlErrorLinePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return "front_matter"
+	}
+	line, convErr := strconv.Atoi(match[1])
+	if convErr != nil {
+		return "front_matter"
+	}
+
+	// Line numbers are relative to the front matter, which starts after the opening delimiter
+	lines := strings.Split(content, "\n")
+	field := "front_matter"
+	for i := 1; i < len(lines) && i <= line; i++ {
+		text := lines[i]
+		if text == "" || text[0] == ' ' || text[0] == '\t' || text[0] == '-' || text[0] == '#' {
+			continue
+		}
+		if key, _, found := strings.Cut(text, ":"); found {
+			field = strings.Trim(strings.TrimSpace(key), `"'`)
+		}
+	}
+	return field
+}
+
+// splitFrontMatter separates the front matter from the body and detects its format.
+// Content without front matter is returned as body with an empty format.
+func splitFrontMatter(content []byte) (FrontMatterFormat, []byte, []byte, error) {
+	var format FrontMatterFormat
+	var delimiter string
+	switch {
+	case bytes.HasPrefix(content, []byte("---")):
+		format, delimiter = FrontMatterYAML, "---"
+	case bytes.HasPrefix(content, []byte("+++")):
+		format, delimiter = FrontMatterTOML, "+++"
+	case bytes.HasPrefix(content, []byte("{")):
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return "", nil, nil, fmt.Errorf("invalid json front matter: %w", err)
+		}
+		body := content[decoder.InputOffset():]
+		body = bytes.TrimPrefix(bytes.TrimPrefix(body, []byte("\r")), []byte("\n"))
+		return FrontMatterJSON, raw, body, nil
+	default:
+		return "", nil, content, nil
+	}
+
+	// The opening delimiter must be on a line of its own
+	firstLineEnd := bytes.IndexByte(content, '\n')
+	if firstLineEnd < 0 || strings.TrimSpace(string(content[:firstLineEnd])) != delimiter {
+		return "", nil, content, nil
+	}
+
+	rest := content[firstLineEnd+1:]
+	offset := 0
+	for offset <= len(rest) {
+		lineEnd := bytes.IndexByte(rest[offset:], '\n')
+		var line []byte
+		if lineEnd < 0 {
+			line = rest[offset:]
+		} else {
+			line = rest[offset : offset+lineEnd]
+		}
+		if strings.TrimSpace(string(line)) == delimiter {
+			body := []byte{}
+			if lineEnd >= 0 {
+				body = rest[offset+lineEnd+1:]
+			}
+			return format, rest[:offset], body, nil
+		}
+		if lineEnd < 0 {
+			break
+		}
+		offset += lineEnd + 1
+	}
+
+	return "", nil, nil, fmt.Errorf("%s front matter is not closed with '%s'", format, delimiter)
+}
+
+// decodeYAMLFrontMatter decodes YAML front matter, keeping the nodes for unchanged values
+func decodeYAMLFrontMatter(raw []byte) ([]frontMatterField, *yaml.Node, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(raw, &document); err != nil {
+		return nil, nil, err
+	}
+	if len(document.Content) == 0 {
+		return nil, nil, nil
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("front matter must be a mapping")
+	}
+
+	fields := make([]frontMatterField, 0, len(root.Content)/2)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		var value interface{}
+		if err := valueNode.Decode(&value); err != nil {
+			return nil, nil, fmt.Errorf("key '%s': %w", keyNode.Value, err)
+		}
+		fields = append(fields, frontMatterField{
+			Key:     keyNode.Value,
+			Value:   normalizeYAMLValue(value),
+			keyNode: keyNode,
+			node:    valueNode,
+		})
+	}
+	return fields, root, nil
+}
+
+// normalizeYAMLValue converts decoded YAML values to the types JSON and TOML decoding produce
+func normalizeYAMLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeYAMLValue(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = normalizeYAMLValue(v[key])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// encodeYAMLFrontMatter encodes the fields as YAML, reusing original nodes for unchanged values
+func encodeYAMLFrontMatter(fields []frontMatterField, original *yaml.Node) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if original != nil {
+		copied := *original
+		root = &copied
+		root.Content = nil
+	}
+
+	for _, field := range fields {
+		keyNode := field.keyNode
+		if keyNode == nil {
+			keyNode = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.Key}
+		}
+		valueNode := field.node
+		if valueNode == nil {
+			valueNode = &yaml.Node{}
+			if err := valueNode.Encode(field.Value); err != nil {
+				return nil, fmt.Errorf("key '%s': %w", field.Key, err)
+			}
+		}
+		root.Content = append(root.Content, keyNode, valueNode)
+	}
+
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tomlKeyPattern matches top-level keys and table headers, used to recover the key order
+var tomlKeyPattern = regexp.MustCompile(`(?m)^\s*(?:\[\[?\s*("[^"]+"|[A-Za-z0-9_-]+)|("[^"]+"|[A-Za-z0-9_-]+)\s*=)`)
+
+// decodeTOMLFrontMatter decodes TOML front matter, ordering the keys as they appear in the file
+func decodeTOMLFrontMatter(raw []byte) ([]frontMatterField, error) {
+	values := make(map[string]interface{})
+	if err := toml.Unmarshal(raw, &values); err != nil {
+		return nil, err
+	}
+
+	var order []string
+	seen := make(map[string]bool)
+	inTable := false
+	for _, match := range tomlKeyPattern.FindAllSubmatch(raw, -1) {
+		key := string(match[1])
+		if key != "" {
+			inTable = true
+		} else if inTable {
+			// Keys inside a table belong to that table, not to the top level
+			continue
+		} else {
+			key = string(match[2])
+		}
+		key = strings.Trim(key, `"`)
+		if _, ok := values[key]; ok && !seen[key] {
+			seen[key] = true
+			order = append(order, key)
+		}
+	}
+
+	return orderedFields(values, order), nil
+}
+
+// encodeTOMLFrontMatter encodes the fields as TOML in field order.
+// Values come before tables, as TOML requires, each group in the order of the fields.
+func encodeTOMLFrontMatter(fields []frontMatterField) ([]byte, error) {
+	keys := make([]string, 0, len(fields))
+	values := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		keys = append(keys, field.Key)
+		values = append(values, field.Value)
+	}
+	return toml.Marshal(orderedTOMLTable(keys, values))
+}
+
+// orderedTOMLTable builds a table for go-toml that keeps its keys in the given order.
+// go-toml sorts the keys of maps but keeps the fields of structs in order, so the table is
+// a struct built for the keys, with one field tagged with each key. TOML has no null, nil values are left out.
+func orderedTOMLTable(keys []string, values []interface{}) interface{} {
+	interfaceType := reflect.TypeOf((*interface{})(nil)).Elem()
+	structFields := make([]reflect.StructField, len(keys))
+	for i, key := range keys {
+		structFields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Field%d", i),
+			Type: interfaceType,
+			Tag:  reflect.StructTag(fmt.Sprintf("toml:%q", key)),
+		}
+	}
+
+	table := reflect.New(reflect.StructOf(structFields)).Elem()
+	for i, value := range values {
+		if nested, ok := value.(*frontMatterMap); ok {
+			nestedValues := make([]interface{}, len(nested.keys))
+			for j, key := range nested.keys {
+				nestedValues[j] = nested.values[key]
+			}
+			value = orderedTOMLTable(nested.keys, nestedValues)
+		}
+		if value != nil {
+			table.Field(i).Set(reflect.ValueOf(value))
+		}
+	}
+	return table.Interface()
+}
+
+// decodeJSONFrontMatter decodes a JSON front matter object, keeping its key order
+func decodeJSONFrontMatter(raw []byte) ([]frontMatterField, error) {
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, err
+	}
+
+	var order []string
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	depth := 0
+	expectKey := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case json.Delim:
+			if t == '{' || t == '[' {
+				depth++
+			} else {
+				depth--
+			}
+			expectKey = depth == 1
+			continue
+		case string:
+			if depth == 1 && expectKey {
+				order = append(order, t)
+				expectKey = false
+				continue
+			}
+		}
+		if depth == 1 {
+			expectKey = true
+		}
+	}
+
+	return orderedFields(values, order), nil
+}
+
+// encodeJSONFrontMatter encodes the fields as an indented JSON object in field order
+func encodeJSONFrontMatter(fields []frontMatterField) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, field := range fields {
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.MarshalIndent(field.Value, "  ", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("key '%s': %w", field.Key, err)
+		}
+		buf.WriteString("  ")
+		buf.Write(key)
+		buf.WriteString(": ")
+		buf.Write(value)
+		if i < len(fields)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// orderedFields turns decoded values into fields, in the given order followed by any remaining keys
+func orderedFields(values map[string]interface{}, order []string) []frontMatterField {
+	fields := make([]frontMatterField, 0, len(values))
+	seen := make(map[string]bool, len(order))
+	for _, key := range order {
+		if value, ok := values[key]; ok && !seen[key] {
+			seen[key] = true
+			fields = append(fields, frontMatterField{Key: key, Value: value})
+		}
+	}
+
+	var rest []string
+	for key := range values {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		fields = append(fields, frontMatterField{Key: key, Value: values[key]})
+	}
+	return fields
+}
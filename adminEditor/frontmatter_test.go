@@ -0,0 +1,367 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const yamlPost = `---
+# Written by hand
+title: Hello
+date: 2024-05-01T10:00:00+02:00
+custom: kept # a comment on an unknown key
+tags: [go, hugo]
+thumbnail:
+  url: img/a.jpg
+  author: Jane
+weight: 3
+draft: false
+---
+Body text
+`
+
+const tomlPost = `+++
+title = "Hello"
+date = 2024-05-01T10:00:00+02:00
+custom = "kept"
+tags = ["go", "hugo"]
+weight = 3
+draft = false
+
+[thumbnail]
+url = "img/a.jpg"
+author = "Jane"
+
+[extra]
+nested = true
++++
+Body text
+`
+
+const jsonPost = `{
+  "title": "Hello",
+  "date": "2024-05-01T10:00:00+02:00",
+  "custom": "kept",
+  "tags": ["go", "hugo"],
+  "thumbnail": {"url": "img/a.jpg", "author": "Jane"},
+  "weight": 3,
+  "draft": false
+}
+Body text
+`
+
+func TestParsePost(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		format  FrontMatterFormat
+	}{
+		{name: "yaml", content: yamlPost, format: FrontMatterYAML},
+		{name: "toml", content: tomlPost, format: FrontMatterTOML},
+		{name: "json", content: jsonPost, format: FrontMatterJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post, err := ParsePost([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("ParsePost() error = %v", err)
+			}
+			if post.Format != tt.format {
+				t.Errorf("Format = %q, want %q", post.Format, tt.format)
+			}
+			if post.Title != "Hello" || post.Draft || post.Body != "Body text\n" {
+				t.Errorf("ParsePost() = title %q, draft %v, body %q", post.Title, post.Draft, post.Body)
+			}
+			if !reflect.DeepEqual(post.Tags, []string{"go", "hugo"}) {
+				t.Errorf("Tags = %v", post.Tags)
+			}
+			if post.Thumbnail == nil || post.Thumbnail.URL != "img/a.jpg" || post.Thumbnail.Author != "Jane" {
+				t.Errorf("Thumbnail = %+v", post.Thumbnail)
+			}
+			if date, ok := post.PublishedAt(); !ok || date.UTC().Hour() != 8 {
+				t.Errorf("PublishedAt() = %v, %v from date %q", date, ok, post.Date)
+			}
+			if post.Params["custom"] != "kept" {
+				t.Errorf("Params = %v, want the unknown key custom", post.Params)
+			}
+		})
+	}
+}
+
+func TestParsePostEdgeCases(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		check   func(t *testing.T, post *Post)
+		wantErr bool
+	}{
+		{
+			name:    "no front matter",
+			content: "Just text\n",
+			check: func(t *testing.T, post *Post) {
+				if post.Format != FrontMatterYAML || post.Body != "Just text\n" || post.Title != "" {
+					t.Errorf("ParsePost() = %+v", post)
+				}
+			},
+		},
+		{
+			name:    "dashes that are not a delimiter line",
+			content: "--- not front matter\n",
+			check: func(t *testing.T, post *Post) {
+				if post.Body != "--- not front matter\n" {
+					t.Errorf("Body = %q", post.Body)
+				}
+			},
+		},
+		{
+			name:    "plain thumbnail and single tag",
+			content: "---\nthumbnail: img/a.jpg\ntags: go\n---\n",
+			check: func(t *testing.T, post *Post) {
+				if post.Thumbnail == nil || post.Thumbnail.URL != "img/a.jpg" || !post.Thumbnail.plain {
+					t.Errorf("Thumbnail = %+v", post.Thumbnail)
+				}
+				if !reflect.DeepEqual(post.Tags, []string{"go"}) {
+					t.Errorf("Tags = %v", post.Tags)
+				}
+			},
+		},
+		{
+			name:    "keys are matched ignoring case",
+			content: "---\nTitle: Hello\nDraft: true\n---\n",
+			check: func(t *testing.T, post *Post) {
+				if post.Title != "Hello" || !post.Draft || len(post.Params) != 0 {
+					t.Errorf("ParsePost() = %+v", post.PostMeta)
+				}
+			},
+		},
+		{name: "unclosed yaml", content: "---\ntitle: Hello\n", wantErr: true},
+		{name: "unclosed toml", content: "+++\ntitle = 'Hello'\n", wantErr: true},
+		{name: "invalid yaml", content: "---\ntitle: [Hello\n---\n", wantErr: true},
+		{name: "invalid json", content: "{\"title\": \n", wantErr: true},
+		{name: "yaml that is not a mapping", content: "---\n- a\n---\n", wantErr: true},
+		{name: "draft that is not a boolean", content: "---\ndraft: maybe\n---\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post, err := ParsePost([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePost() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, post)
+			}
+		})
+	}
+}
+
+func TestPostBytesUnchanged(t *testing.T) {
+	for _, content := range []string{yamlPost, tomlPost, jsonPost, "Only a body\n"} {
+		post, err := ParsePost([]byte(content))
+		if err != nil {
+			t.Fatalf("ParsePost() error = %v", err)
+		}
+		out, err := post.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes() error = %v", err)
+		}
+		if string(out) != content {
+			t.Errorf("Bytes() of an unchanged post =\n%s\nwant\n%s", out, content)
+		}
+	}
+}
+
+func TestPostBytesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "yaml",
+			content: yamlPost,
+			want: `---
+# Written by hand
+title: Changed
+date: 2024-05-01T10:00:00+02:00
+custom: kept # a comment on an unknown key
+tags: [go, hugo]
+thumbnail:
+  url: img/a.jpg
+  author: Jane
+weight: 3
+draft: true
+description: New description
+added: value
+---
+Body text
+`,
+		},
+		{
+			name:    "toml",
+			content: tomlPost,
+			want: `+++
+title = 'Changed'
+date = 2024-05-01T10:00:00+02:00
+custom = 'kept'
+tags = ['go', 'hugo']
+weight = 3
+draft = true
+description = 'New description'
+added = 'value'
+
+[thumbnail]
+author = 'Jane'
+url = 'img/a.jpg'
+
+[extra]
+nested = true
++++
+Body text
+`,
+		},
+		{
+			name:    "json",
+			content: jsonPost,
+			want: `{
+  "title": "Changed",
+  "date": "2024-05-01T10:00:00+02:00",
+  "custom": "kept",
+  "tags": [
+    "go",
+    "hugo"
+  ],
+  "thumbnail": {
+    "author": "Jane",
+    "url": "img/a.jpg"
+  },
+  "weight": 3,
+  "draft": true,
+  "description": "New description",
+  "added": "value"
+}
+Body text
+`,
+		},
+	}
+
+	// Top-level keys keep their order, the keys of unchanged tables are sorted for TOML and JSON
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post, err := ParsePost([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("ParsePost() error = %v", err)
+			}
+			post.Title = "Changed"
+			post.Description = "New description"
+			post.Draft = true
+			post.Params["added"] = "value"
+
+			out, err := post.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("Bytes() =\n%s\nwant\n%s", out, tt.want)
+			}
+
+			// The written post reads back with the same metadata and unknown keys
+			reparsed, err := ParsePost(out)
+			if err != nil {
+				t.Fatalf("ParsePost() of the written post error = %v", err)
+			}
+			if reparsed.Title != "Changed" || reparsed.Description != "New description" || !reparsed.Draft {
+				t.Errorf("reparsed metadata = %+v", reparsed.PostMeta)
+			}
+			if !sameValue(reparsed.Params, post.Params) {
+				t.Errorf("reparsed params = %v, want %v", reparsed.Params, post.Params)
+			}
+			if reparsed.Body != post.Body {
+				t.Errorf("reparsed body = %q, want %q", reparsed.Body, post.Body)
+			}
+		})
+	}
+}
+
+func TestPostBytesRemovesKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "yaml",
+			content: "---\ntitle: Hello\ntags: [a]\ncustom: 1\nother: 2\n---\n",
+			want:    "---\ntitle: Hello\nother: 2\n---\n",
+		},
+		{
+			name:    "toml",
+			content: "+++\ntitle = 'Hello'\ntags = ['a']\ncustom = 1\nother = 2\n+++\n",
+			want:    "+++\ntitle = 'Hello'\nother = 2\n+++\n",
+		},
+		{
+			name:    "json",
+			content: "{\"title\": \"Hello\", \"tags\": [\"a\"], \"custom\": 1, \"other\": 2}\n",
+			want:    "{\n  \"title\": \"Hello\",\n  \"other\": 2\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post, err := ParsePost([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("ParsePost() error = %v", err)
+			}
+			post.Tags = nil
+			delete(post.Params, "custom")
+
+			out, err := post.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("Bytes() =\n%s\nwant\n%s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostMetaValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    PostMeta
+		wantErr bool
+	}{
+		{name: "valid dates", meta: PostMeta{Date: "2024-05-01", LastMod: "2024-05-01T10:00:00Z"}},
+		{name: "invalid date", meta: PostMeta{Date: "yesterday"}, wantErr: true},
+		{name: "known key as param", meta: PostMeta{Params: map[string]interface{}{"Title": "x"}}, wantErr: true},
+		{name: "unknown param", meta: PostMeta{Params: map[string]interface{}{"weight": 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.meta.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTOMLChangedThumbnailKeepsKeyOrder(t *testing.T) {
+	post, err := ParsePost([]byte(tomlPost))
+	if err != nil {
+		t.Fatalf("ParsePost() error = %v", err)
+	}
+	post.Thumbnail.Origin = "Unsplash"
+
+	out, err := post.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	want := "[thumbnail]\nurl = 'img/a.jpg'\nauthor = 'Jane'\norigin = 'Unsplash'\n"
+	if !strings.Contains(string(out), want) {
+		t.Errorf("Bytes() =\n%s\nwant it to contain\n%s", out, want)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultHistoryLimit is the number of revisions listed when no limit is requested
+	defaultHistoryLimit = 50
+	// historyFieldSeparator separates the fields of a formatted git log line
+	historyFieldSeparator = "\x1f"
+)
+
+// revisionPattern matches the revision names accepted from clients.
+// Only commit hashes and HEAD are allowed so a revision can never be read as a git option.
+var revisionPattern = regexp.MustCompile(`^([0-9a-fA-F]{4,40}|HEAD)$`)
+
+// GitHistoryImpl records every save as a commit in the git working tree that holds the content
+type GitHistoryImpl struct {
+	configProvider ConfigProvider
+	logger         *Logger
+	root           string
+
+	// mu serializes git commands, git does not allow concurrent writes to the index
+	mu sync.Mutex
+}
+
+// NewGitHistory creates a version history for the git repository containing the content folders.
+// History is disabled when it is switched off in the configuration or git is not available.
+func NewGitHistory(configProvider ConfigProvider, logger *Logger) *GitHistoryImpl {
+	history := &GitHistoryImpl{
+		configProvider: configProvider,
+		logger:         logger,
+	}
+
+	config := configProvider.GetConfig()
+	if !config.History.Enabled {
+		logger.Info("NewGitHistory: Version history is disabled")
+		return history
+	}
+
+	folder, err := languageFolder(config.DefaultLanguage(), config)
+	if err != nil {
+		logger.Warn("NewGitHistory: No content folder, version history is disabled", zap.Error(err))
+		return history
+	}
+	absFolder, err := filepath.Abs(folder)
+	if err != nil {
+		logger.Warn("NewGitHistory: Could not resolve content folder, version history is disabled", zap.Error(err))
+		return history
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	cmd.Dir = absFolder
+	output, err := cmd.Output()
+	if err != nil {
+		logger.Warn("NewGitHistory: Content folder is not inside a git repository, version history is disabled",
+			zap.String("folder", absFolder),
+			zap.Error(err),
+		)
+		return history
+	}
+
+	history.root = strings.TrimSpace(string(output))
+	logger.Info("NewGitHistory: Recording saves in git repository", zap.String("root", history.root))
+	return history
+}
+
+// Enabled reports whether saves are recorded
+func (h *GitHistoryImpl) Enabled() bool {
+	return h.root != ""
+}
+
+// Commit records the current content of a file as a new revision.
+// Only the given file is committed, other changes in the working tree are left alone.
+func (h *GitHistoryImpl) Commit(path, message string) error {
+	if !h.Enabled() {
+		return nil
+	}
+	relPath, err := h.relativePath(path)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status, err := h.git("status", "--porcelain", "--", relPath)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(status)) == 0 {
+		// Saving unchanged content does not create an empty revision
+		return nil
+	}
+
+	if _, err := h.git("add", "--", relPath); err != nil {
+		return err
+	}
+	if _, err := h.git("commit", "--quiet", "--no-verify", "-m", message, "--", relPath); err != nil {
+		return err
+	}
+	h.logger.Info("GitHistory.Commit: Recorded revision", zap.String("path", relPath), zap.String("message", message))
+	return nil
+}
+
+// Log lists the revisions of a file, newest first
+func (h *GitHistoryImpl) Log(path string, limit int) ([]Revision, error) {
+	if err := h.available(); err != nil {
+		return nil, err
+	}
+	relPath, err := h.relativePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	format := strings.Join([]string{"%H", "%an", "%ae", "%aI", "%s"}, historyFieldSeparator)
+	output, err := h.git("log", "--follow", "-n", strconv.Itoa(limit), "--format="+format, "--", relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []Revision{}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, historyFieldSeparator)
+		if len(fields) != 5 {
+			continue
+		}
+		revisions = append(revisions, Revision{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Date:    fields[3],
+			Message: fields[4],
+		})
+	}
+	return revisions, nil
+}
+
+// Diff returns a unified diff of a file between two revisions.
+// An empty "to" compares against the file on disk.
+func (h *GitHistoryImpl) Diff(path, from, to string) (string, error) {
+	if err := h.available(); err != nil {
+		return "", err
+	}
+	relPath, err := h.relativePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"diff", "--no-color", "--no-ext-diff"}
+	for _, revision := range []string{from, to} {
+		if revision == "" {
+			continue
+		}
+		if err := validateRevision(revision); err != nil {
+			return "", err
+		}
+		args = append(args, revision)
+	}
+	args = append(args, "--", relPath)
+
+	output, err := h.git(args...)
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// Show returns the content of a file at the given revision
+func (h *GitHistoryImpl) Show(path, revision string) ([]byte, error) {
+	if err := h.available(); err != nil {
+		return nil, err
+	}
+	if err := validateRevision(revision); err != nil {
+		return nil, err
+	}
+	relPath, err := h.relativePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return h.git("show", revision+":"+relPath)
+}
+
+// available returns an error when history is disabled
+func (h *GitHistoryImpl) available() error {
+	if h.Enabled() {
+		return nil
+	}
+	return NewAPIError("git", "history", "Version history is not available", http.StatusServiceUnavailable, nil)
+}
+
+// relativePath converts a path on disk to a slash separated path relative to the repository root
+func (h *GitHistoryImpl) relativePath(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", NewFileSystemError("history", path, "Failed to resolve path", err)
+	}
+	// The repository root is reported with symlinks resolved
+	if resolved, err := filepath.EvalSymlinks(filepath.Dir(absPath)); err == nil {
+		absPath = filepath.Join(resolved, filepath.Base(absPath))
+	}
+	rel, err := filepath.Rel(h.root, absPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", NewValidationError("file", "File is not part of the git repository", err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// git runs a git command in the repository root with the configured author
+func (h *GitHistoryImpl) git(args ...string) ([]byte, error) {
+	config := h.configProvider.GetConfig()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = h.root
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+config.History.AuthorName,
+		"GIT_AUTHOR_EMAIL="+config.History.AuthorEmail,
+		"GIT_COMMITTER_NAME="+config.History.AuthorName,
+		"GIT_COMMITTER_EMAIL="+config.History.AuthorEmail,
+		"GIT_TERMINAL_PROMPT=0",
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = "git command failed"
+		}
+		// Unknown revisions or files missing from a revision are client errors
+		if strings.Contains(message, "does not exist") || strings.Contains(message, "unknown revision") ||
+			strings.Contains(message, "bad revision") || strings.Contains(message, "invalid object name") {
+			return nil, NewValidationError("revision", message, err)
+		}
+		return nil, NewAPIError("git", args[0], message, http.StatusInternalServerError, err)
+	}
+	return output, nil
+}
+
+// validateRevision rejects anything that is not a commit hash or HEAD
+func validateRevision(revision string) error {
+	if !revisionPattern.MatchString(revision) {
+		return NewValidationError("revision", fmt.Sprintf("Invalid revision '%s'", revision), nil)
+	}
+	return nil
+}
+
+// recordRevision commits a saved file to the version history.
+// A failed commit is logged but does not fail the save, the file is already written.
+func (app *Application) recordRevision(logger *Logger, fullPath, message string) {
+	if err := app.history.Commit(fullPath, message); err != nil {
+		logger.Error("recordRevision: Could not record revision", zap.String("path", fullPath), zap.Error(err))
+	}
+}
+
+// handleHistory lists the revisions of a post
+func (app *Application) handleHistory(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		logger.Warn("handleHistory: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	filename := r.URL.Query().Get("file")
+	if filename == "" {
+		logger.Warn("handleHistory: Filename is required")
+		return NewValidationError("filename", "Filename is required", nil)
+	}
+	fullPath, err := getFullPath(filename, app.configProvider.GetConfig())
+	if err != nil {
+		logger.Warn("handleHistory: Invalid filename", zap.String("filename", filename), zap.Error(err))
+		return err
+	}
+
+	limit := defaultHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return NewValidationError("limit", "Limit must be a positive number", err)
+		}
+		limit = parsed
+	}
+
+	revisions, err := app.history.Log(fullPath, limit)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("handleHistory: Responding with revisions", zap.String("path", fullPath), zap.Int("count", len(revisions)))
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(revisions)
+}
+
+// handleHistoryDiff returns a unified diff of a post between two revisions.
+// Without "to" the revision is compared against the current file.
+func (app *Application) handleHistoryDiff(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		logger.Warn("handleHistoryDiff: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	query := r.URL.Query()
+	filename := query.Get("file")
+	if filename == "" {
+		logger.Warn("handleHistoryDiff: Filename is required")
+		return NewValidationError("filename", "Filename is required", nil)
+	}
+	from := query.Get("from")
+	if from == "" {
+		logger.Warn("handleHistoryDiff: From revision is required")
+		return NewValidationError("from", "From revision is required", nil)
+	}
+	fullPath, err := getFullPath(filename, app.configProvider.GetConfig())
+	if err != nil {
+		logger.Warn("handleHistoryDiff: Invalid filename", zap.String("filename", filename), zap.Error(err))
+		return err
+	}
+
+	diff, err := app.history.Diff(fullPath, from, query.Get("to"))
+	if err != nil {
+		return err
+	}
+
+	logger.Info("handleHistoryDiff: Responding with diff", zap.String("path", fullPath), zap.String("from", from), zap.String("to", query.Get("to")))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write([]byte(diff))
+	return err
+}
+
+// handleHistoryRestore rolls a post back to an earlier revision and records the restore as a new revision.
+// Like a save, an If-Match header with the ETag of the loaded version makes it fail with a conflict
+// when the post was changed since.
+func (app *Application) handleHistoryRestore(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		logger.Warn("handleHistoryRestore: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	var request struct {
+		File     string `json:"file"`
+		Revision string `json:"revision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.Error("handleHistoryRestore: Invalid request body", zap.Error(err))
+		return NewValidationError("request_body", "Invalid request body", err)
+	}
+	if request.File == "" || request.Revision == "" {
+		logger.Warn("handleHistoryRestore: File and revision are required")
+		return NewValidationError("request_body", "File and revision are required", nil)
+	}
+
+	fullPath, err := getFullPath(request.File, app.configProvider.GetConfig())
+	if err != nil {
+		logger.Warn("handleHistoryRestore: Invalid filename", zap.String("filename", request.File), zap.Error(err))
+		return err
+	}
+
+	content, err := app.history.Show(fullPath, request.Revision)
+	if err != nil {
+		return err
+	}
+	// Restoring replaces the file like a save, so it must not interleave with one
+	app.saveMu.Lock()
+	defer app.saveMu.Unlock()
+	current, etag, err := app.currentRevision(fullPath)
+	if err != nil {
+		return err
+	}
+	if err := app.authorizePostChange(r, fileLanguage(request.File), current, content); err != nil {
+		logger.Warn("handleHistoryRestore: Change not allowed", zap.String("path", fullPath), zap.Error(err))
+		return err
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, etag) {
+			logger.Warn("handleHistoryRestore: File changed since it was loaded",
+				zap.String("path", fullPath),
+				zap.String("ifMatch", ifMatch),
+				zap.String("etag", etag),
+			)
+			return NewConflictError(request.File, "File was changed since it was loaded", etag, string(current))
+		}
+	}
+	if err := app.fileSystem.WriteFile(fullPath, content, 0644); err != nil {
+		return err
+	}
+	app.postIndex.Update(fullPath)
+	app.recordRevision(logger, fullPath, fmt.Sprintf("Restore %s to %s", request.File, request.Revision))
+	app.recordAudit(r, AuditPostRestore, fullPath, contentHash(current), contentHash(content), map[string]string{"revision": request.Revision})
+
+	logger.Info("handleHistoryRestore: Restored file", zap.String("path", fullPath), zap.String("revision", request.Revision))
+	if info, err := app.fileSystem.Stat(fullPath); err == nil {
+		w.Header().Set("ETag", contentETag(content, info.ModTime()))
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = w.Write(content)
+	return err
+}
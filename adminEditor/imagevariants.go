@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"go.uber.org/zap"
+)
+
+// defaultImageQuality is the JPEG quality of variants without a quality
+const defaultImageQuality = 85
+
+// webpEffort is how hard the lossless WebP encoder works for a smaller file. WebP variants have
+// no quality, their quality setting only applies to the source format onlyIfSmaller falls back to.
+const webpEffort = 85
+
+// imageVariantNamePattern keeps variant names usable in file names
+var imageVariantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// imageEncoder writes an image in an output format, with a quality from 1 to 100 that lossless formats ignore
+type imageEncoder func(w io.Writer, img image.Image, quality int) error
+
+// imageEncoders are the output formats a variant can be saved in
+var imageEncoders = map[string]imageEncoder{
+	"jpeg": func(w io.Writer, img image.Image, quality int) error {
+		return imaging.Encode(w, img, imaging.JPEG, imaging.JPEGQuality(quality))
+	},
+	"png": func(w io.Writer, img image.Image, quality int) error {
+		level := png.DefaultCompression
+		switch {
+		case quality < 34:
+			level = png.BestSpeed
+		case quality > 66:
+			level = png.BestCompression
+		}
+		return imaging.Encode(w, img, imaging.PNG, imaging.PNGCompressionLevel(level))
+	},
+	"webp": func(w io.Writer, img image.Image, _ int) error {
+		return encodeWebP(w, img, webpEffort)
+	},
+}
+
+// imageFormatExtensions are the file extensions of the output formats. AVIF has no pure Go encoder
+// this build could use, so it is known but only accepted once it has an entry in imageEncoders.
+var imageFormatExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"webp": ".webp",
+	"avif": ".avif",
+}
+
+// ImageManifest describes a processed image and its variants, so templates can build a srcset from it.
+// It is returned by ProcessMediaFile and saved as "<name>.variants.json" next to the image.
+type ImageManifest struct {
+	Source   string             `json:"source"`
+	Filename string             `json:"filename"`
+	Width    int                `json:"width"`
+	Height   int                `json:"height"`
+	Variants []ImageVariantFile `json:"variants"`
+}
+
+// ImageVariantFile is a variant saved for a processed image
+type ImageVariantFile struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int    `json:"size"`
+}
+
+// variantFileName returns the file name of a variant, such as "post-sm.jpg" for "post.jpg"
+func variantFileName(newName, variant, ext string) string {
+	return newName + "-" + variant + ext
+}
+
+// manifestFileName returns the file name of the manifest of a processed image
+func manifestFileName(newName string) string {
+	return newName + ".variants.json"
+}
+
+// validateImageVariants checks the configured image variants
+func validateImageVariants(result *ConfigValidation, variants []ImageVariant) {
+	seen := make(map[string]bool, len(variants))
+	for i, variant := range variants {
+		field := fmt.Sprintf("server.imageResize.variants[%d]", i)
+		switch {
+		case !imageVariantNamePattern.MatchString(variant.Name):
+			result.errorf(field+".name", "invalid variant name: %q. Must contain only lowercase letters, digits and '-'", variant.Name)
+		case seen[variant.Name]:
+			result.errorf(field+".name", "duplicate variant name: %q", variant.Name)
+		}
+		seen[variant.Name] = true
+
+		if variant.Width <= 0 {
+			result.errorf(field+".width", "invalid variant width: %d. Must be greater than 0", variant.Width)
+		}
+		if variant.Height < 0 {
+			result.errorf(field+".height", "invalid variant height: %d. Must not be negative", variant.Height)
+		}
+		switch variant.Method {
+		case "fit", "resize":
+		case "fill":
+			if variant.Height == 0 {
+				result.errorf(field+".height", "variant with method 'fill' needs a height to crop to")
+			}
+		default:
+			result.errorf(field+".method", "invalid resize method: %s. Must be 'fit', 'fill', or 'resize'", variant.Method)
+		}
+		if variant.Quality < 0 || variant.Quality > 100 {
+			result.errorf(field+".quality", "invalid variant quality: %d. Must be between 1 and 100, or 0 for the default", variant.Quality)
+		}
+		_, known := imageFormatExtensions[variant.Format]
+		_, available := imageEncoders[variant.Format]
+		switch {
+		case variant.Format == "" || available:
+		case known:
+			result.errorf(field+".format", "%s output is not available in this build, there is no encoder for it", variant.Format)
+		default:
+			result.errorf(field+".format", "invalid output format: %q. Must be one of: %s, or empty for the source format", variant.Format, strings.Join(availableImageFormats(), ", "))
+		}
+		if variant.OnlyIfSmaller && variant.Format == "" {
+			result.warnf(field+".onlyIfSmaller", "onlyIfSmaller has no effect without a format")
+		}
+		if variant.Format == "webp" && variant.Quality != 0 && !variant.OnlyIfSmaller {
+			result.warnf(field+".quality", "quality has no effect on lossless webp output, it only applies to the source format onlyIfSmaller falls back to")
+		}
+	}
+}
+
+// availableImageFormats returns the output formats that have an encoder
+func availableImageFormats() []string {
+	formats := make([]string, 0, len(imageEncoders))
+	for format := range imageEncoders {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// resizeVariant scales an image to a variant. It returns false when the image is smaller than the variant,
+// because an enlarged copy only adds bytes to a srcset. "fill" crops follow the crop of the media file.
+func resizeVariant(src image.Image, variant ImageVariant, crop *MediaCrop) (image.Image, bool) {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	if rect, ok := crop.rectangle(src.Bounds()); ok && variant.Method == "fill" {
+		srcWidth, srcHeight = rect.Dx(), rect.Dy()
+	}
+	if srcWidth < variant.Width || (variant.Method == "fill" && srcHeight < variant.Height) {
+		return nil, false
+	}
+	switch variant.Method {
+	case "fill":
+		return fillCrop(src, variant.Width, variant.Height, crop), true
+	case "resize":
+		return imaging.Resize(src, variant.Width, variant.Height, imaging.Lanczos), true
+	default:
+		height := variant.Height
+		if height == 0 {
+			height = srcHeight
+		}
+		return imaging.Fit(src, variant.Width, height, imaging.Lanczos), true
+	}
+}
+
+// encodeVariant encodes a variant in its output format and returns the data, format name and extension.
+// Without a format, or when onlyIfSmaller finds the output format larger, the source format is used.
+func encodeVariant(img image.Image, variant ImageVariant, source imaging.Format, ext string) ([]byte, string, string, error) {
+	quality := variant.Quality
+	if quality == 0 {
+		quality = defaultImageQuality
+	}
+	sourceFormat := strings.ToLower(source.String())
+	encodeSource := func(quality int) ([]byte, error) {
+		var buf bytes.Buffer
+		err := imaging.Encode(&buf, img, source, imaging.JPEGQuality(quality))
+		return buf.Bytes(), err
+	}
+
+	if variant.Format == "" {
+		data, err := encodeSource(quality)
+		return data, sourceFormat, ext, err
+	}
+	encode, ok := imageEncoders[variant.Format]
+	if !ok {
+		return nil, "", "", fmt.Errorf("%s output is not available in this build", variant.Format)
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, img, quality); err != nil {
+		return nil, "", "", err
+	}
+	if variant.OnlyIfSmaller && variant.Format != sourceFormat {
+		data, err := encodeSource(quality)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if len(data) <= buf.Len() {
+			return data, sourceFormat, ext, nil
+		}
+	}
+	return buf.Bytes(), variant.Format, imageFormatExtensions[variant.Format], nil
+}
+
+// saveImage encodes an image in the format of its file extension and writes it, JPEG files with the given EXIF segment
+func (s *ImageProcessingServiceImpl) saveImage(img image.Image, path string, exifSegment []byte) error {
+	format, err := imaging.FormatFromFilename(path)
+	if err != nil {
+		return NewValidationError("file", fmt.Sprintf("Unsupported image format: %s", filepath.Ext(path)), err)
+	}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, format, imaging.JPEGQuality(defaultImageQuality)); err != nil {
+		return NewFileSystemError("Save", path, "Error encoding image", err)
+	}
+	data := buf.Bytes()
+	if format == imaging.JPEG {
+		data = insertExif(data, exifSegment)
+	}
+	return s.fileSystem.WriteFile(path, data, 0644)
+}
+
+// saveVariants saves the configured variants of a decoded image to the asset folder
+func (s *ImageProcessingServiceImpl) saveVariants(src image.Image, newName, ext string, config Config, exifSegment []byte, crop *MediaCrop) ([]ImageVariantFile, error) {
+	source, err := imaging.FormatFromExtension(ext)
+	if err != nil {
+		return nil, NewValidationError("file", fmt.Sprintf("Unsupported image format: %s", ext), err)
+	}
+
+	files := []ImageVariantFile{}
+	for _, variant := range config.Server.ImageResize.Variants {
+		resized, ok := resizeVariant(src, variant, crop)
+		if !ok {
+			s.logger.Info("saveVariants: Image is smaller than variant, skipping it",
+				zap.String("variant", variant.Name),
+				zap.Int("width", variant.Width),
+			)
+			continue
+		}
+
+		data, format, variantExt, err := encodeVariant(resized, variant, source, ext)
+		if err != nil {
+			return nil, NewFileSystemError("Encode", variant.Name, "Error encoding image variant", err)
+		}
+		if variant.Format != "" && format != variant.Format {
+			s.logger.Info("saveVariants: Output format is not smaller, keeping source format",
+				zap.String("variant", variant.Name),
+				zap.String("format", variant.Format),
+			)
+		}
+
+		if format == "jpeg" {
+			data = insertExif(data, exifSegment)
+		}
+
+		name := variantFileName(newName, variant.Name, variantExt)
+		path := filepath.Join(config.Server.AssetFolder, name)
+		if err := s.fileSystem.WriteFile(path, data, 0644); err != nil {
+			return nil, err
+		}
+		files = append(files, ImageVariantFile{
+			Name:   variant.Name,
+			File:   name,
+			Format: format,
+			Width:  resized.Bounds().Dx(),
+			Height: resized.Bounds().Dy(),
+			Size:   len(data),
+		})
+		s.logger.Info("saveVariants: Image variant saved", zap.String("variant", variant.Name), zap.String("path", path))
+	}
+	return files, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestResizeVariant(t *testing.T) {
+	src := halvesImage(200, 100)
+	square := &MediaCrop{Rect: &CropRect{X: 0, Y: 0, Width: 50, Height: 50}}
+
+	tests := []struct {
+		name     string
+		variant  ImageVariant
+		crop     *MediaCrop
+		wantSize image.Point
+		wantSkip bool
+	}{
+		{name: "fit to width", variant: ImageVariant{Width: 100, Method: "fit"}, wantSize: image.Pt(100, 50)},
+		{name: "fit within height", variant: ImageVariant{Width: 100, Height: 30, Method: "fit"}, wantSize: image.Pt(60, 30)},
+		{name: "resize to exact size", variant: ImageVariant{Width: 100, Height: 20, Method: "resize"}, wantSize: image.Pt(100, 20)},
+		{name: "fill", variant: ImageVariant{Width: 40, Height: 40, Method: "fill"}, wantSize: image.Pt(40, 40)},
+		{name: "source width", variant: ImageVariant{Width: 200, Method: "fit"}, wantSize: image.Pt(200, 100)},
+		{name: "wider than source", variant: ImageVariant{Width: 201, Method: "fit"}, wantSkip: true},
+		{name: "fill higher than source", variant: ImageVariant{Width: 50, Height: 150, Method: "fill"}, wantSkip: true},
+		{name: "fill within crop rectangle", variant: ImageVariant{Width: 50, Height: 50, Method: "fill"}, crop: square, wantSize: image.Pt(50, 50)},
+		{name: "fill wider than crop rectangle", variant: ImageVariant{Width: 60, Height: 60, Method: "fill"}, crop: square, wantSkip: true},
+		{name: "fit ignores crop rectangle", variant: ImageVariant{Width: 60, Method: "fit"}, crop: square, wantSize: image.Pt(60, 30)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resized, ok := resizeVariant(src, tt.variant, tt.crop)
+			if ok == tt.wantSkip {
+				t.Fatalf("resizeVariant() ok = %v, want %v", ok, !tt.wantSkip)
+			}
+			if !ok {
+				return
+			}
+			if size := resized.Bounds().Size(); size != tt.wantSize {
+				t.Errorf("resizeVariant() size = %v, want %v", size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestEncodeVariant(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	noise := testImage(64, 64, func(x, y int) color.NRGBA {
+		return color.NRGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: 255}
+	})
+
+	tests := []struct {
+		name       string
+		img        image.Image
+		variant    ImageVariant
+		wantFormat string
+		wantExt    string
+		wantErr    bool
+	}{
+		{name: "source format", img: noise, wantFormat: "jpeg", wantExt: ".jpeg"},
+		{name: "png", img: noise, variant: ImageVariant{Format: "png"}, wantFormat: "png", wantExt: ".png"},
+		{name: "webp", img: noise, variant: ImageVariant{Format: "webp"}, wantFormat: "webp", wantExt: ".webp"},
+		// Lossless WebP of noise is far larger than a JPEG of it
+		{name: "larger webp falls back", img: noise, variant: ImageVariant{Format: "webp", OnlyIfSmaller: true}, wantFormat: "jpeg", wantExt: ".jpeg"},
+		{name: "smaller webp is kept", img: testImage(64, 64, gradient), variant: ImageVariant{Format: "webp", OnlyIfSmaller: true, Quality: 100}, wantFormat: "webp", wantExt: ".webp"},
+		{name: "format without encoder", img: noise, variant: ImageVariant{Format: "avif"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, format, ext, err := encodeVariant(tt.img, tt.variant, imaging.JPEG, ".jpeg")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encodeVariant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if format != tt.wantFormat || ext != tt.wantExt {
+				t.Errorf("encodeVariant() = %s, %s, want %s, %s", format, ext, tt.wantFormat, tt.wantExt)
+			}
+			if _, decoded, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || decoded != tt.wantFormat {
+				t.Errorf("data decodes as %q, %v, want %s", decoded, err, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestProcessMediaFileWritesVariants(t *testing.T) {
+	processor, config := newTestImageProcessor(t, nil)
+	config.Server.ImageResize.Variants = []ImageVariant{
+		{Name: "sm", Width: 80, Method: "fit"},
+		{Name: "md", Width: 150, Method: "fit", Format: "webp"},
+		{Name: "xl", Width: 400, Method: "fit"},
+	}
+	processor.configProvider = NewAppConfig(&config, nil, processor.logger)
+
+	manifest, err := processor.ProcessMediaFile(MediaProcessRequest{File: "photo.png", NewName: "processed"})
+	if err != nil {
+		t.Fatalf("ProcessMediaFile() error = %v", err)
+	}
+
+	// The source is 200 pixels wide, so there is no xl variant
+	want := []ImageVariantFile{
+		{Name: "sm", File: "processed-sm.png", Format: "png", Width: 80, Height: 40},
+		{Name: "md", File: "processed-md.webp", Format: "webp", Width: 150, Height: 75},
+	}
+	if len(manifest.Variants) != len(want) {
+		t.Fatalf("variants = %+v, want %d variants", manifest.Variants, len(want))
+	}
+	for i, variant := range manifest.Variants {
+		info, err := os.Stat(filepath.Join(config.Server.AssetFolder, variant.File))
+		if err != nil {
+			t.Fatalf("variant %s was not written: %v", variant.Name, err)
+		}
+		if info.Size() != int64(variant.Size) {
+			t.Errorf("variant %s size = %d, file has %d bytes", variant.Name, variant.Size, info.Size())
+		}
+		variant.Size = 0
+		if variant != want[i] {
+			t.Errorf("variant = %+v, want %+v", variant, want[i])
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.Server.AssetFolder, manifestFileName("processed")))
+	if err != nil {
+		t.Fatalf("manifest was not written: %v", err)
+	}
+	var saved ImageManifest
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&saved, manifest) {
+		t.Errorf("saved manifest = %+v, want %+v", saved, *manifest)
+	}
+}
@@ -18,8 +18,12 @@ type FileSystem interface {
 	ReadDir(dirname string) ([]os.FileInfo, error)
 	MkdirAll(path string, perm os.FileMode) error
 	Remove(name string) error
+	Rename(oldpath, newpath string) error
 	Stat(name string) (os.FileInfo, error)
 	Create(name string) (*os.File, error)
+	// CreateTemp starts a new version of a file that is written in parts, such as an upload.
+	// The target is only replaced by Commit, closing the file without Commit discards it.
+	CreateTemp(name string, perm os.FileMode) (*PendingFile, error)
 	Walk(root string, walkFn filepath.WalkFunc) error
 }
 
@@ -32,11 +36,21 @@ type HTTPClient interface {
 type ConfigProvider interface {
 	GetConfig() Config
 	LoadConfig() error
+	// ConfigFile returns the path of the configuration file in use, or "" when there is none
+	ConfigFile() string
+	// Reload reads the configuration file again, applies it and reports what changed
+	Reload() (ConfigChange, error)
+	// Subscribe registers a function that is called after the configuration has changed
+	Subscribe(fn func(previous, current Config))
+	// Watch reloads the configuration whenever its file changes, until Close is called
+	Watch() error
+	Close() error
 }
 
 // ImageProcessingService defines the interface for image processing operations
 type ImageProcessingService interface {
-	ProcessMediaFile(request MediaProcessRequest) (string, error)
+	// ProcessMediaFile resizes a media file into the asset folder and returns the manifest of the image and its variants
+	ProcessMediaFile(request MediaProcessRequest) (*ImageManifest, error)
 }
 
 // MediaProcessRequest represents the request structure for processing a media file
@@ -44,3 +58,27 @@ type MediaProcessRequest struct {
 	File    string `json:"file"`
 	NewName string `json:"newName"`
 }
+
+// VersionHistory defines the interface for recording and restoring revisions of content files
+type VersionHistory interface {
+	Enabled() bool
+	Commit(path, message string) error
+	Log(path string, limit int) ([]Revision, error)
+	Diff(path, from, to string) (string, error)
+	Show(path, revision string) ([]byte, error)
+}
+
+// Revision describes a single recorded version of a file
+type Revision struct {
+	Hash    string `json:"hash"`
+	Author  string `json:"author"`
+	Email   string `json:"email"`
+	Date    string `json:"date"`
+	Message string `json:"message"`
+}
+
+// UserStore defines the interface for looking up and authenticating local accounts
+type UserStore interface {
+	Authenticate(username, password string) (*User, error)
+	Lookup(username string) (*User, bool)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// defaultLanguages returns the languages used when the configuration does not define any
+func defaultLanguages() map[string]LanguageConfig {
+	return map[string]LanguageConfig{
+		"en": {
+			ContentFolder: "../content/en",
+			Name:          "English",
+			Default:       true,
+		},
+		"de": {
+			ContentFolder: "../content/de",
+			Name:          "Deutsch",
+		},
+	}
+}
+
+// validateLanguages checks that every language has a content folder and that exactly one is the default
+func validateLanguages(languages map[string]LanguageConfig) error {
+	if len(languages) == 0 {
+		return fmt.Errorf("at least one language must be configured")
+	}
+
+	defaults := 0
+	for code, language := range languages {
+		if code == "" {
+			return fmt.Errorf("language code cannot be empty")
+		}
+		if language.ContentFolder == "" {
+			return fmt.Errorf("language '%s' has no content folder", code)
+		}
+		if language.Default {
+			defaults++
+		}
+	}
+
+	if defaults != 1 {
+		return fmt.Errorf("exactly one language must be marked as default, found %d", defaults)
+	}
+	return nil
+}
+
+// Language looks up a configured language by its code
+func (c Config) Language(code string) (LanguageConfig, bool) {
+	language, ok := c.Languages[code]
+	return language, ok
+}
+
+// DefaultLanguage returns the code of the default language
+func (c Config) DefaultLanguage() string {
+	for _, code := range c.LanguageCodes() {
+		if c.Languages[code].Default {
+			return code
+		}
+	}
+	return ""
+}
+
+// LanguageCodes returns all configured language codes in a stable order
+func (c Config) LanguageCodes() []string {
+	codes := make([]string, 0, len(c.Languages))
+	for code := range c.Languages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
@@ -143,6 +143,21 @@ func RequestIDMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// ActorKey is the key used to store the acting identity of a request in the request context
+type ActorKey struct{}
+
+// actorHolder is filled in by the authentication further down the chain, so the request log can name the actor
+type actorHolder struct {
+	name string
+}
+
+// SetActor records who is making a request, for the request log
+func SetActor(ctx context.Context, actor string) {
+	if holder, ok := ctx.Value(ActorKey{}).(*actorHolder); ok {
+		holder.name = actor
+	}
+}
+
 // LoggingMiddleware is middleware that logs HTTP requests
 func LoggingMiddleware(logger *Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -153,10 +168,15 @@ func LoggingMiddleware(logger *Logger) func(http.Handler) http.Handler {
 			wrapped := &responseWriter{w, http.StatusOK}
 
 			// Call the next handler
-			next.ServeHTTP(wrapped, r)
+			actor := &actorHolder{}
+			next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), ActorKey{}, actor)))
 
 			// Log the request
-			logger.LogHTTPRequest(r, time.Since(start), wrapped.status)
+			requestLogger := logger
+			if actor.name != "" {
+				requestLogger = logger.WithField("actor", actor.name)
+			}
+			requestLogger.LogHTTPRequest(r, time.Since(start), wrapped.status)
 		})
 	}
 }
@@ -2,15 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"io"
@@ -19,16 +24,23 @@ import (
 	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	_ "markdown-editor/docs"
 
 	"github.com/disintegration/imaging"
+	"github.com/fsnotify/fsnotify"
 	"github.com/gosimple/slug"
 	"github.com/joho/godotenv"
+	"github.com/spf13/viper"
 	httpSwagger "github.com/swaggo/http-swagger" // swagger UI handler
 	_ "github.com/swaggo/swag"                   // swagger embed files
 	"go.uber.org/zap"
@@ -160,30 +172,38 @@ func (c *FluxClient) GenerateLandscapeImage(prompt string, outputFile string) er
 	return nil
 }
 
-// AppConfig implements ConfigProvider interface
+// AppConfig implements ConfigProvider interface.
+// The configuration is swapped atomically on a reload, readers always see a complete configuration.
 type AppConfig struct {
-	config *Config
+	config atomic.Pointer[Config]
+	viper  *viper.Viper
 	logger *Logger
+
+	// mu serializes reloads and guards the subscribers and the watcher
+	mu          sync.Mutex
+	subscribers []func(previous, current Config)
+	watcher     *fsnotify.Watcher
 }
 
-// NewAppConfig creates a new instance of AppConfig
-func NewAppConfig(config *Config, logger *Logger) *AppConfig {
-	return &AppConfig{
-		config: config,
+// NewAppConfig creates a new instance of AppConfig, v is used to read the configuration file again on a reload
+func NewAppConfig(config *Config, v *viper.Viper, logger *Logger) *AppConfig {
+	provider := &AppConfig{
+		viper:  v,
 		logger: logger,
 	}
+	provider.config.Store(config)
+	return provider
 }
 
 // GetConfig returns the current configuration
 func (p *AppConfig) GetConfig() Config {
-	return *p.config
+	return *p.config.Load()
 }
 
-// LoadConfig is a no-op implementation since configuration is loaded via Viper
+// LoadConfig reads the configuration file again and applies it
 func (p *AppConfig) LoadConfig() error {
-	// Configuration is already loaded via Viper, so this is a no-op
-	p.logger.Info("LoadConfig: Configuration already loaded via Viper")
-	return nil
+	_, err := p.Reload()
+	return err
 }
 
 // OSFileSystem implements FileSystem interface using os package
@@ -263,6 +283,20 @@ func (fs *OSFileSystem) Remove(name string) error {
 	return nil
 }
 
+// Rename renames (moves) a file
+func (fs *OSFileSystem) Rename(oldpath, newpath string) error {
+	err := os.Rename(oldpath, newpath)
+	if err != nil {
+		fs.logger.Error("Rename: Error renaming file",
+			zap.String("oldpath", oldpath),
+			zap.String("newpath", newpath),
+			zap.Error(err),
+		)
+		return NewFileSystemError("Rename", oldpath, "Error renaming file", err)
+	}
+	return nil
+}
+
 // Stat returns file info
 func (fs *OSFileSystem) Stat(name string) (os.FileInfo, error) {
 	info, err := os.Stat(name)
@@ -289,6 +323,24 @@ func (fs *OSFileSystem) Create(name string) (*os.File, error) {
 	return file, nil
 }
 
+// CreateTemp creates a file that is written in place, Close without Commit removes it again.
+// Wrapped in an AtomicFileSystem, the target is only replaced once the file is complete.
+func (fs *OSFileSystem) CreateTemp(name string, perm os.FileMode) (*PendingFile, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		fs.logger.Error("CreateTemp: Error creating file",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return nil, NewFileSystemError("CreateTemp", name, "Error creating file", err)
+	}
+	discard := func() {
+		file.Close()
+		os.Remove(name)
+	}
+	return &PendingFile{File: file, commit: file.Close, discard: discard}, nil
+}
+
 // Walk walks the file tree
 func (fs *OSFileSystem) Walk(root string, walkFn filepath.WalkFunc) error {
 	err := filepath.Walk(root, walkFn)
@@ -331,22 +383,65 @@ type Application struct {
 	httpClient     HTTPClient
 	imageGenerator ImageGenerator
 	imageProcessor ImageProcessingService
-	logger         *Logger
-	config         *Config
+	postIndex      *PostIndex
+	searchIndex    *SearchIndex
+	history        VersionHistory
+	auth           *Authenticator
+	audit          *AuditLog
+	// operations tracks saves and image processing, so a shutdown waits for them
+	operations *OperationTracker
+
+	// saveMu serializes the version check and write of a save
+	saveMu sync.Mutex
+	// configMu serializes changes to the configuration file
+	configMu sync.Mutex
+	// sidecarMu serializes changes to media sidecars, which hold both the metadata and the crop of a file
+	sidecarMu sync.Mutex
+	// mediaSizes caches the dimensions of media files for the media list
+	mediaSizes mediaDimensionCache
+	// newPosts are the paths of posts that are being created, guarded by saveMu
+	newPosts map[string]bool
+
+	logger *Logger
 }
 
 // NewApplication creates a new instance of Application with all dependencies
 func NewApplication(logger *Logger) (*Application, error) {
 	// Load configuration using Viper
-	config, err := LoadConfig(logger.Logger)
+	config, v, err := LoadConfig(logger.Logger)
 	if err != nil {
-		logger.Fatal("Failed to load configuration", zap.Error(err))
+		logger.Error("Failed to load configuration", zap.Error(err))
+		return nil, err
+	}
+
+	// Create the folders the configuration refers to, validation never does this itself
+	if err := bootstrapFolders(*config, logger); err != nil {
+		logger.Error("Failed to create configured folders", zap.Error(err))
 		return nil, err
 	}
 
 	// Create concrete implementations
-	configProvider := NewAppConfig(config, logger)
-	fileSystem := NewOSFileSystem(logger)
+	configProvider := NewAppConfig(config, v, logger)
+	atomicFileSystem := NewAtomicFileSystem(NewOSFileSystem(logger), config.Server.KeepBackups, logger)
+
+	// Report temporary files of writes that were interrupted by a crash, in every folder files are written to
+	recoveryRoots := []string{}
+	for _, root := range sandboxRootsFromConfig(*config) {
+		recoveryRoots = append(recoveryRoots, root.Path)
+	}
+	if file := configProvider.ConfigFile(); file != "" {
+		recoveryRoots = append(recoveryRoots, file)
+	}
+	if _, err := atomicFileSystem.Recover(recoveryRoots); err != nil {
+		logger.Warn("Recovery pass for interrupted writes failed", zap.Error(err))
+	}
+
+	// Confine every file access to the configured folders
+	fileSystem, err := NewSandboxFileSystem(atomicFileSystem, sandboxRootsFromConfig(*config), logger)
+	if err != nil {
+		logger.Error("Failed to set up file system sandbox", zap.Error(err))
+		return nil, err
+	}
 	httpClient := NewHTTPClient(30 * time.Second)
 
 	// Create FluxClient with the HTTPClient interface
@@ -358,29 +453,108 @@ func NewApplication(logger *Logger) (*Application, error) {
 	imageGenerator := NewFluxClient(apiKey, logger)
 	imageProcessor := NewImageProcessingServiceImpl(configProvider, fileSystem, logger)
 
-	return &Application{
+	postIndex := NewPostIndex(configProvider, fileSystem, logger)
+	if err := postIndex.Build(); err != nil {
+		logger.Error("Failed to build post index", zap.Error(err))
+		return nil, err
+	}
+	searchIndex := NewSearchIndex(postIndex, configProvider, fileSystem, logger)
+	if err := searchIndex.Build(); err != nil {
+		logger.Error("Failed to build search index", zap.Error(err))
+		return nil, err
+	}
+
+	auth, err := NewAuthenticator(configProvider, logger)
+	if err != nil {
+		logger.Error("Failed to initialize authentication", zap.Error(err))
+		return nil, err
+	}
+
+	var audit *AuditLog
+	if config.Audit.Enabled {
+		audit, err = NewAuditLog(config.Audit, logger)
+		if err != nil {
+			logger.Error("Failed to open audit log", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	app := &Application{
 		configProvider: configProvider,
 		fileSystem:     fileSystem,
 		httpClient:     httpClient,
 		imageGenerator: imageGenerator,
 		imageProcessor: imageProcessor,
+		postIndex:      postIndex,
+		searchIndex:    searchIndex,
+		history:        NewGitHistory(configProvider, logger),
+		auth:           auth,
+		audit:          audit,
+		operations:     NewOperationTracker(),
 		logger:         logger,
-		config:         config,
-	}, nil
+	}
+	app.subscribeToConfig(fileSystem)
+	return app, nil
 }
 
 func main() {
+	hashPassword := flag.Bool("hash-password", false, "read a password from stdin, print its bcrypt hash for the users file and exit")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration, print all errors and warnings and exit")
+	flag.Parse()
+	if *hashPassword {
+		if err := hashPasswordFromStdin(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *checkConfig {
+		if err := checkConfigFile(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Initialize structured logger
 	logger, err := NewLogger()
 	if err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
-	defer logger.Sync()
+
+	err = run(logger)
+	if err != nil {
+		logger.Error("Server stopped with an error", zap.Error(err))
+	}
+	// os.Exit skips deferred calls, so the logger is flushed here
+	logger.Sync()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// run starts the servers and blocks until they have been shut down by SIGINT or SIGTERM, or have failed
+func run(logger *Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Initialize application with dependency injection
 	app, err := NewApplication(logger)
 	if err != nil {
-		logger.Fatal("Failed to initialize application", zap.Error(err))
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
+
+	// Keep the post index current while content is edited outside the editor
+	if err := app.postIndex.Watch(); err != nil {
+		logger.Warn("Post index will not follow changes on disk", zap.Error(err))
+	}
+	// Apply changes to the configuration file without a restart
+	if err := app.configProvider.Watch(); err != nil {
+		logger.Warn("Configuration will not be reloaded when its file changes", zap.Error(err))
+	}
+	// Deferred calls run after the servers have drained, so no request still uses them
+	defer app.configProvider.Close()
+	defer app.postIndex.Close()
+	if app.audit != nil {
+		defer app.audit.Close()
 	}
 
 	// Set up middleware chain
@@ -392,17 +566,63 @@ func main() {
 	logger.Info("main: Serving static files from /static")
 
 	// API endpoints with error handling
-	mux.HandleFunc("/api/config", WithErrorHandling(app.handleConfig))
-	mux.HandleFunc("/api/save", WithErrorHandling(app.handleSave))
-	mux.HandleFunc("/api/load", WithErrorHandling(app.handleLoad))
-	mux.HandleFunc("/api/list", WithErrorHandling(app.handleList))
-	mux.HandleFunc("/api/media-list", WithErrorHandling(app.handleMediaList))
-	mux.HandleFunc("/api/process-media", WithErrorHandling(app.handleProcessMedia))
-	mux.HandleFunc("/api/create-post", WithErrorHandling(app.handleCreatePost))
-	mux.HandleFunc("/api/tags", WithErrorHandling(app.handleGetTags))
-	mux.HandleFunc("/api/categories", WithErrorHandling(app.handleGetCategories))
-	mux.HandleFunc("/api/delete-media", WithErrorHandling(app.handleDeleteMedia))
-	mux.HandleFunc("/api/upload-media", WithErrorHandling(app.handleUploadMediaFolder))
+	mux.HandleFunc("/api/auth/login", WithErrorHandling(app.handleLogin))
+	mux.HandleFunc("/api/auth/logout", WithErrorHandling(app.handleLogout))
+	mux.HandleFunc("/api/auth/me", WithErrorHandling(app.handleMe))
+	mux.HandleFunc("/api/auth/csrf", WithErrorHandling(app.handleCSRFToken))
+	mux.HandleFunc("/api/tokens", WithErrorHandling(app.handleTokens))
+	mux.HandleFunc("/api/tokens/{id}", WithErrorHandling(app.handleRevokeToken))
+	// Each route requires a permission, writes to posts are checked again in the handler for ownership and publishing.
+	// Routes that write files are tracked as operations, so a shutdown lets them finish.
+	track := app.operations.Track
+	mux.HandleFunc("/api/config", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleConfig)))
+	mux.HandleFunc("/api/config/reload", app.RequirePermission(PermConfigWrite, WithErrorHandling(app.handleConfigReload)))
+	mux.HandleFunc("/api/config/validate", app.RequirePermission(PermConfigWrite, WithErrorHandling(app.handleConfigValidate)))
+	mux.HandleFunc("GET /api/shortcodes", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleShortcodes)))
+	mux.HandleFunc("/api/shortcodes", app.RequirePermission(PermConfigWrite, WithErrorHandling(app.handleShortcodes)))
+	mux.HandleFunc("/api/shortcodes/{id}", app.RequirePermission(PermConfigWrite, WithErrorHandling(app.handleShortcode)))
+	mux.HandleFunc("/api/shortcodes/{id}/render", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleRenderShortcode)))
+	mux.HandleFunc("/api/save", app.RequirePermission(PermPostsWrite, track("save", WithErrorHandling(app.handleSave))))
+	mux.HandleFunc("/api/load", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleLoad)))
+	mux.HandleFunc("/api/merge", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleMerge)))
+	mux.HandleFunc("/api/list", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleList)))
+	mux.HandleFunc("/api/posts", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handlePosts)))
+	mux.HandleFunc("/api/posts/{lang}/{path...}", app.RequirePermission(PermPostsRead, track("post-meta", WithErrorHandling(app.handlePostMeta))))
+	mux.HandleFunc("/api/search", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleSearch)))
+	mux.HandleFunc("/api/history", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleHistory)))
+	mux.HandleFunc("/api/history/diff", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleHistoryDiff)))
+	mux.HandleFunc("/api/history/restore", app.RequirePermission(PermPostsWrite, track("restore", WithErrorHandling(app.handleHistoryRestore))))
+	mux.HandleFunc("/api/media-list", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleMediaList)))
+	mux.HandleFunc("GET /api/media/{name}", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleMediaRecord)))
+	mux.HandleFunc("/api/media/{name}", app.RequirePermission(PermMediaWrite, track("media-meta", WithErrorHandling(app.handleMediaRecord))))
+	mux.HandleFunc("/api/media/{name}/exif", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleMediaExif)))
+	mux.HandleFunc("/api/media/{name}/crop", app.RequirePermission(PermMediaWrite, track("media-crop", WithErrorHandling(app.handleMediaCrop))))
+	mux.HandleFunc("/api/process-media", app.RequirePermission(PermMediaWrite, track("process-media", WithErrorHandling(app.handleProcessMedia))))
+	mux.HandleFunc("/api/create-post", app.RequirePermission(PermPostsWrite, track("create-post", WithErrorHandling(app.handleCreatePost))))
+	mux.HandleFunc("/api/tags", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleGetTags)))
+	mux.HandleFunc("/api/categories", app.RequirePermission(PermPostsRead, WithErrorHandling(app.handleGetCategories)))
+	mux.HandleFunc("/api/audit", app.RequirePermission(PermAuditRead, WithErrorHandling(app.handleAudit)))
+	mux.HandleFunc("/api/taxonomy/regenerate", app.RequirePermission(PermTaxonomyWrite, track("regenerate-taxonomy", WithErrorHandling(app.handleRegenerateTaxonomy))))
+	mux.HandleFunc("/api/delete-media", app.RequirePermission(PermMediaDelete, track("delete-media", WithErrorHandling(app.handleDeleteMedia))))
+	mux.HandleFunc("/api/upload-media", app.RequirePermission(PermMediaWrite, track("upload-media", WithErrorHandling(app.handleUploadMediaFolder))))
+
+	// Scripts authenticated with an API token may call these routes without a CSRF token
+	csrf := NewCSRFProtection(app.auth, logger)
+	csrf.AllowTokenClients(
+		"/api/save",
+		"/api/merge",
+		"/api/posts/",
+		"/api/history/restore",
+		"/api/create-post",
+		"/api/process-media",
+		"/api/media/",
+		"/api/upload-media",
+		"/api/delete-media",
+		"/api/taxonomy/regenerate",
+		"/api/config/reload",
+		"/api/shortcodes",
+		"/api/shortcodes/",
+	)
 
 	// Updated Swagger handler
 	mux.Handle("/swagger/", httpSwagger.Handler(
@@ -428,7 +648,7 @@ func main() {
 		config.Server.CertFile = "cert.pem"
 		config.Server.KeyFile = "key.pem"
 		if err := generateSelfSignedCert(config.Server.CertFile, config.Server.KeyFile, logger); err != nil {
-			logger.Fatal("Failed to generate self-signed certificates", zap.Error(err))
+			return fmt.Errorf("failed to generate self-signed certificates: %w", err)
 		}
 		logger.Info("Self-signed certificates generated successfully for development.")
 	} else if config.Server.CertFile == "" || config.Server.KeyFile == "" {
@@ -458,86 +678,85 @@ func main() {
 		LoggingMiddleware(logger)(
 			ErrorHandlerMiddleware(logger)(
 				SecurityHeadersMiddleware(logger)(
-					mux,
+					csrf.Middleware(
+						AuthMiddleware(app.auth, logger)(
+							mux,
+						),
+					),
 				),
 			),
 		),
 	)
 
-	// DEBUG: Add logging to verify server startup logic
-	logger.Info("DEBUG: Server startup logic check",
-		zap.Bool("redirectHTTPToHTTPS", config.Server.RedirectHTTPToHTTPS),
-		zap.String("certFile", config.Server.CertFile),
-		zap.String("keyFile", config.Server.KeyFile),
-		zap.String("env", env),
-	)
-
-	// Start HTTP server for redirect if enabled
-	if config.Server.RedirectHTTPToHTTPS && config.Server.CertFile != "" && config.Server.KeyFile != "" {
-		go func() {
-			logger.Info("Starting HTTP to HTTPS redirect server", zap.String("address", httpAddr))
-			redirectServer := &http.Server{
-				Addr: httpAddr,
-				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					http.Redirect(w, r, fmt.Sprintf("https://%s%s", r.Host, r.RequestURI), http.StatusMovedPermanently)
-				}),
-			}
-			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				logger.Fatal("HTTP redirect server failed to start", zap.Error(err))
-			}
-		}()
-	}
-
-	// Start HTTPS server if certificates are available
-	if config.Server.CertFile != "" && config.Server.KeyFile != "" {
-		go func() {
-			logger.Info("Starting HTTPS server", zap.String("address", httpsAddr))
-			httpsServer := &http.Server{
-				Addr:    httpsAddr,
-				Handler: handlerChain,
-				TLSConfig: &tls.Config{
-					MinVersion: tls.VersionTLS12,
-				},
-			}
-			if err := httpsServer.ListenAndServeTLS(config.Server.CertFile, config.Server.KeyFile); err != nil && err != http.ErrServerClosed {
-				logger.Fatal("HTTPS server failed to start", zap.Error(err))
-			}
-		}()
-		
-		// In development mode, also start HTTP server alongside HTTPS
-		if env == "development" {
-			go func() {
-				logger.Info("Starting HTTP server in development mode", zap.String("address", httpAddr))
-				httpServer := &http.Server{
-					Addr:    httpAddr,
-					Handler: handlerChain,
-				}
-				if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-					logger.Fatal("HTTP server failed to start", zap.Error(err))
-				}
-			}()
+	servers := NewServerManager(app.operations, config.Server.ShutdownTimeout, logger)
+	hasCertificate := config.Server.CertFile != "" && config.Server.KeyFile != ""
+
+	// HTTP server that redirects to HTTPS, if enabled
+	if config.Server.RedirectHTTPToHTTPS && hasCertificate {
+		servers.Add("redirect", &http.Server{
+			Addr: httpAddr,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, fmt.Sprintf("https://%s%s", r.Host, r.RequestURI), http.StatusMovedPermanently)
+			}),
+		})
+	}
+
+	if hasCertificate {
+		servers.AddTLS("https", &http.Server{
+			Addr:    httpsAddr,
+			Handler: handlerChain,
+			TLSConfig: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			},
+		}, config.Server.CertFile, config.Server.KeyFile)
+
+		// In development mode, also serve HTTP alongside HTTPS
+		if env == "development" && !config.Server.RedirectHTTPToHTTPS {
+			servers.Add("http", &http.Server{Addr: httpAddr, Handler: handlerChain})
 		}
 	} else {
 		logger.Warn("HTTPS server not started: certFile or keyFile not configured. Consider generating self-signed certificates for development.")
-		// If HTTPS is not configured, start HTTP server on the main port
-		go func() {
-			logger.Info("Starting HTTP server", zap.String("address", httpAddr))
-			if err := http.ListenAndServe(httpAddr, handlerChain); err != nil && err != http.ErrServerClosed {
-				logger.Fatal("HTTP server failed to start", zap.Error(err))
-			}
-		}()
+		// If HTTPS is not configured, serve HTTP on the main port
+		servers.Add("http", &http.Server{Addr: httpAddr, Handler: handlerChain})
 	}
 
-	// Block main thread indefinitely
-	select {}
+	// Move the servers when their ports change in the configuration
+	app.configProvider.Subscribe(func(previous, current Config) {
+		if previous.Server.Port != current.Server.Port {
+			addr := fmt.Sprintf(":%d", current.Server.Port)
+			for _, name := range []string{"http", "redirect"} {
+				if err := servers.Rebind(name, addr); err != nil {
+					logger.Error("Could not move server to its new port", zap.String("server", name), zap.Error(err))
+				}
+			}
+		}
+		if previous.Server.HTTPSPort != current.Server.HTTPSPort {
+			if err := servers.Rebind("https", fmt.Sprintf(":%d", current.Server.HTTPSPort)); err != nil {
+				logger.Error("Could not move server to its new port", zap.String("server", "https"), zap.Error(err))
+			}
+		}
+	})
+
+	return servers.Run(ctx)
 }
 
+// handleConfig returns the settings the editor needs, never the full configuration
 func (app *Application) handleConfig(w http.ResponseWriter, r *http.Request) error {
 	logger := GetLoggerFromContext(r.Context())
 	logger.Info("handleConfig: Handling config request")
 
+	config := app.configProvider.GetConfig()
+	client := ClientConfig{
+		Shortcodes: sortedShortcodes(config.Shortcodes),
+		Languages:  make(map[string]ClientLanguage, len(config.Languages)),
+		Sections:   config.Sections,
+	}
+	for code, language := range config.Languages {
+		client.Languages[code] = ClientLanguage{Name: language.Name, Default: language.Default}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(app.configProvider.GetConfig())
+	return json.NewEncoder(w).Encode(client)
 }
 
 func (app *Application) handleSave(w http.ResponseWriter, r *http.Request) error {
@@ -560,12 +779,47 @@ func (app *Application) handleSave(w http.ResponseWriter, r *http.Request) error
 		return NewValidationError("request_body", "Error reading request body", err)
 	}
 
-	fullPath := getFullPath(filename, app.configProvider.GetConfig())
+	fullPath, err := getFullPath(filename, app.configProvider.GetConfig())
+	if err != nil {
+		logger.Warn("handleSave: Invalid filename", zap.String("filename", filename), zap.Error(err))
+		return err
+	}
+
+	// Checking the version and writing must not interleave with another save
+	app.saveMu.Lock()
+	defer app.saveMu.Unlock()
+
+	server, etag, err := app.currentRevision(fullPath)
+	if err != nil {
+		return err
+	}
+	if err := app.authorizePostChange(r, fileLanguage(filename), server, content); err != nil {
+		logger.Warn("handleSave: Change not allowed", zap.String("path", fullPath), zap.Error(err))
+		return err
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, etag) {
+			logger.Warn("handleSave: File changed since it was loaded",
+				zap.String("path", fullPath),
+				zap.String("ifMatch", ifMatch),
+				zap.String("etag", etag),
+			)
+			return NewConflictError(filename, "File was changed since it was loaded", etag, string(server))
+		}
+	}
+
 	err = app.fileSystem.WriteFile(fullPath, content, 0644)
 	if err != nil {
 		return err
 	}
+	app.postIndex.Update(fullPath)
+	app.recordRevision(logger, fullPath, "Update "+filename)
+	app.recordAudit(r, AuditPostSave, fullPath, contentHash(server), contentHash(content), nil)
 
+	if info, err := app.fileSystem.Stat(fullPath); err == nil {
+		w.Header().Set("ETag", contentETag(content, info.ModTime()))
+	}
 	logger.Info("handleSave: Successfully saved file", zap.String("path", fullPath))
 	w.WriteHeader(http.StatusOK)
 	return nil
@@ -580,14 +834,23 @@ func (app *Application) handleLoad(w http.ResponseWriter, r *http.Request) error
 		return NewValidationError("filename", "Filename is required", nil)
 	}
 
-	fullPath := getFullPath(filename, app.configProvider.GetConfig())
-	content, err := app.fileSystem.ReadFile(fullPath)
+	fullPath, err := getFullPath(filename, app.configProvider.GetConfig())
+	if err != nil {
+		logger.Warn("handleLoad: Invalid filename", zap.String("filename", filename), zap.Error(err))
+		return err
+	}
+	content, etag, err := app.currentRevision(fullPath)
 	if err != nil {
 		return err
 	}
+	if etag == "" {
+		logger.Warn("handleLoad: File not found", zap.String("path", fullPath))
+		return NewFileSystemError("read", fullPath, "File not found", os.ErrNotExist)
+	}
 
 	logger.Info("handleLoad: Successfully loaded file", zap.String("path", fullPath))
 	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("ETag", etag)
 	_, err = w.Write(content)
 	return err
 }
@@ -596,34 +859,64 @@ func (app *Application) handleList(w http.ResponseWriter, r *http.Request) error
 	logger := GetLoggerFromContext(r.Context())
 	logger.Info("handleList: Handling list request")
 
-	files := make(map[string][]string)
 	config := app.configProvider.GetConfig()
+	w.Header().Set("Content-Type", "application/json")
 
-	germanFiles, err := listFiles(config.Server.GermanFolder, app.fileSystem)
-	if err != nil {
-		return err
+	lang := r.URL.Query().Get("lang")
+	section := r.URL.Query().Get("section")
+
+	if lang != "" && section != "" {
+		folder, err := sectionFolder(lang, section, config)
+		if err != nil {
+			logger.Warn("handleList: Unknown language or section", zap.String("lang", lang), zap.String("section", section))
+			return err
+		}
+		sectionFiles, err := listFiles(folder, app.fileSystem)
+		if err != nil {
+			return err
+		}
+		logger.Info("handleList: Responding with section files", zap.String("lang", lang), zap.String("section", section))
+		return json.NewEncoder(w).Encode(sectionFiles)
 	}
-	files["de"] = germanFiles
 
-	englishFiles, err := listFiles(config.Server.EnglishFolder, app.fileSystem)
-	if err != nil {
-		return err
+	if lang != "" {
+		sections, err := app.listSections(lang, config)
+		if err != nil {
+			logger.Warn("handleList: Unknown language", zap.String("lang", lang))
+			return err
+		}
+		logger.Info("handleList: Responding with language files grouped by section", zap.String("lang", lang))
+		return json.NewEncoder(w).Encode(sections)
 	}
-	files["en"] = englishFiles
 
-	w.Header().Set("Content-Type", "application/json")
-	lang := r.URL.Query().Get("lang")
-	switch lang {
-	case "de":
-		logger.Info("handleList: Responding with German files")
-		return json.NewEncoder(w).Encode(germanFiles)
-	case "en":
-		logger.Info("handleList: Responding with English files")
-		return json.NewEncoder(w).Encode(englishFiles)
-	default:
-		logger.Info("handleList: Responding with all files")
-		return json.NewEncoder(w).Encode(files)
+	files := make(map[string]map[string][]string)
+	for _, code := range config.LanguageCodes() {
+		sections, err := app.listSections(code, config)
+		if err != nil {
+			return err
+		}
+		files[code] = sections
 	}
+
+	logger.Info("handleList: Responding with all files", zap.Int("languages", len(files)))
+	return json.NewEncoder(w).Encode(files)
+}
+
+// listSections lists the Markdown files of a language grouped by section
+func (app *Application) listSections(lang string, config Config) (map[string][]string, error) {
+	sections := make(map[string][]string)
+	for _, section := range config.Sections {
+		folder, err := sectionFolder(lang, section.Name, config)
+		if err != nil {
+			return nil, err
+		}
+		sectionFiles, err := listFiles(folder, app.fileSystem)
+		if err != nil {
+			return nil, err
+		}
+		sections[section.Name] = sectionFiles
+	}
+	return sections, nil
 }
 
 func (app *Application) handleMediaList(w http.ResponseWriter, r *http.Request) error {
@@ -631,21 +924,14 @@ func (app *Application) handleMediaList(w http.ResponseWriter, r *http.Request)
 	logger.Info("handleMediaList: Handling media list request")
 
 	config := app.configProvider.GetConfig()
-	files, err := app.fileSystem.ReadDir(config.Server.MediaFolder)
+	records, err := app.mediaRecords(config.Server.MediaFolder)
 	if err != nil {
 		return err
 	}
 
-	var mediaFiles []string
-	for _, file := range files {
-		if !file.IsDir() {
-			mediaFiles = append(mediaFiles, file.Name())
-		}
-	}
-
-	logger.Info("handleMediaList: Found media files", zap.Int("count", len(mediaFiles)))
+	logger.Info("handleMediaList: Found media files", zap.Int("count", len(records)))
 	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(mediaFiles)
+	return json.NewEncoder(w).Encode(records)
 }
 
 func (app *Application) handleProcessMedia(w http.ResponseWriter, r *http.Request) error {
@@ -675,18 +961,31 @@ func (app *Application) handleProcessMedia(w http.ResponseWriter, r *http.Reques
 		NewName: request.NewName,
 	}
 
-	newFileName, err := app.imageProcessor.ProcessMediaFile(mediaRequest)
+	destination := filepath.Join(app.configProvider.GetConfig().Server.AssetFolder, request.NewName+filepath.Ext(request.File))
+	hashBefore := app.fileHash(destination)
+	manifest, err := app.imageProcessor.ProcessMediaFile(mediaRequest)
 	if err != nil {
 		return err
 	}
+	app.recordAudit(r, AuditMediaProcess, destination, hashBefore, app.fileHash(destination), map[string]string{
+		"source":   request.File,
+		"variants": strconv.Itoa(len(manifest.Variants)),
+	})
 
 	response := struct {
-		Filename string `json:"filename"`
+		Filename string             `json:"filename"`
+		Manifest string             `json:"manifest"`
+		Variants []ImageVariantFile `json:"variants"`
 	}{
-		Filename: newFileName,
+		Filename: manifest.Filename,
+		Manifest: manifestFileName(request.NewName),
+		Variants: manifest.Variants,
 	}
 
-	logger.Info("handleProcessMedia: Successfully processed media file", zap.String("filename", newFileName))
+	logger.Info("handleProcessMedia: Successfully processed media file",
+		zap.String("filename", manifest.Filename),
+		zap.Int("variants", len(manifest.Variants)),
+	)
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(response)
 }
@@ -793,10 +1092,11 @@ func (app *Application) processThumbnail(request *NewPostRequest) error {
 			NewName: request.Slug,
 		}
 
-		newFileName, err := app.imageProcessor.ProcessMediaFile(reqMediaFile)
+		manifest, err := app.imageProcessor.ProcessMediaFile(reqMediaFile)
 		if err != nil {
 			return err
 		}
+		newFileName := manifest.Filename
 
 		logger.Info("processThumbnail: Processed media file",
 			zap.String("from", request.Thumbnail.LocalFile),
@@ -809,41 +1109,114 @@ func (app *Application) processThumbnail(request *NewPostRequest) error {
 }
 
 // generatePostContent generates the post content from the request data
-func (app *Application) generatePostContent(request *NewPostRequest) string {
+func (app *Application) generatePostContent(request *NewPostRequest) (string, error) {
 	logger := app.logger
 	logger.Info("generatePostContent: Generating markdown content", zap.String("title", request.Title))
 
-	content := generateMarkdownContent(*request)
+	content, err := generateMarkdownContent(*request, app.archetypeDefaults(request.Section))
+	if err != nil {
+		logger.Error("generatePostContent: Error encoding front matter", zap.Error(err))
+		return "", err
+	}
 
 	logger.Info("generatePostContent: Generated markdown content", zap.Int("length", len(content)))
-	return content
+	return content, nil
+}
+
+// archetypeDefaults returns the front matter defaults for a section, or none if they cannot be loaded
+func (app *Application) archetypeDefaults(sectionName string) map[string]interface{} {
+	logger := app.logger
+
+	config := app.configProvider.GetConfig()
+	section, ok := config.Section(sectionName)
+	if !ok {
+		return map[string]interface{}{}
+	}
+
+	defaults, err := loadArchetypeDefaults(section, config, app.fileSystem)
+	if err != nil {
+		logger.Warn("archetypeDefaults: Could not load archetype defaults",
+			zap.String("section", sectionName),
+			zap.Error(err),
+		)
+		return map[string]interface{}{}
+	}
+
+	logger.Info("archetypeDefaults: Loaded archetype defaults",
+		zap.String("section", sectionName),
+		zap.Int("count", len(defaults)),
+	)
+	return defaults
+}
+
+// newPostPath returns the folder and file name of a new post, from its language, section and title
+func (app *Application) newPostPath(request *NewPostRequest) (string, string, error) {
+	targetFolder, err := sectionFolder(request.Language, request.Section, app.configProvider.GetConfig())
+	if err != nil {
+		app.logger.Warn("newPostPath: Invalid language or section specified",
+			zap.String("language", request.Language),
+			zap.String("section", request.Section),
+		)
+		return "", "", err
+	}
+	return targetFolder, createSlug(request.Title) + ".md", nil
+}
+
+// reserveNewPost claims the path of a new post until release is called. It fails when a post with that
+// name exists or is being created, before the thumbnail of the new post can replace the images of another one.
+func (app *Application) reserveNewPost(request *NewPostRequest) (release func(), err error) {
+	targetFolder, filename, err := app.newPostPath(request)
+	if err != nil {
+		return nil, err
+	}
+	fullPath := filepath.Join(targetFolder, filename)
+
+	app.saveMu.Lock()
+	defer app.saveMu.Unlock()
+	if err := app.checkNewPostLocked(request, fullPath, filename); err != nil {
+		return nil, err
+	}
+	if app.newPosts[fullPath] {
+		app.logger.Warn("reserveNewPost: Post is being created", zap.String("path", fullPath))
+		return nil, NewConflictError(request.Language+"/"+request.Section+"/"+filename, "A post with this name is being created", "", "")
+	}
+	if app.newPosts == nil {
+		app.newPosts = make(map[string]bool)
+	}
+	app.newPosts[fullPath] = true
+	return func() {
+		app.saveMu.Lock()
+		delete(app.newPosts, fullPath)
+		app.saveMu.Unlock()
+	}, nil
+}
+
+// checkNewPostLocked fails when a post already exists at the path of a new post, saveMu must be held
+func (app *Application) checkNewPostLocked(request *NewPostRequest, fullPath, filename string) error {
+	if _, err := app.fileSystem.Stat(fullPath); err == nil {
+		app.logger.Warn("checkNewPostLocked: Post already exists", zap.String("path", fullPath))
+		return NewConflictError(request.Language+"/"+request.Section+"/"+filename, "A post with this name already exists", "", "")
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
 }
 
 // savePostToFile saves the post content to the file system
 func (app *Application) savePostToFile(request *NewPostRequest, content string) (string, string, error) {
 	logger := app.logger
 
-	// Create filename from title
-	filename := createSlug(request.Title) + ".md"
-	logger.Info("savePostToFile: Generated filename",
+	// The file name follows the title, the folder the language and section
+	targetFolder, filename, err := app.newPostPath(request)
+	if err != nil {
+		return "", "", err
+	}
+	logger.Info("savePostToFile: Target file set",
+		zap.String("folder", targetFolder),
 		zap.String("filename", filename),
 		zap.String("title", request.Title),
 	)
 
-	// Determine the target folder based on language
-	var targetFolder string
-	config := app.configProvider.GetConfig()
-	switch request.Language {
-	case "de":
-		targetFolder = config.Server.GermanFolder
-	case "en":
-		targetFolder = config.Server.EnglishFolder
-	default:
-		logger.Warn("savePostToFile: Invalid language specified", zap.String("language", request.Language))
-		return "", "", NewValidationError("language", "Invalid language", nil)
-	}
-	logger.Info("savePostToFile: Target folder set", zap.String("folder", targetFolder))
-
 	// Ensure target folder exists
 	if _, err := app.fileSystem.Stat(targetFolder); os.IsNotExist(err) {
 		logger.Info("savePostToFile: Target folder does not exist", zap.String("path", targetFolder))
@@ -853,12 +1226,27 @@ func (app *Application) savePostToFile(request *NewPostRequest, content string)
 		logger.Info("savePostToFile: Created target folder", zap.String("path", targetFolder))
 	}
 
-	// Save the file with detailed error logging
+	// Parse the generated content again, so that broken front matter never reaches Hugo
+	if err := validatePostContent(content, request); err != nil {
+		logger.Warn("savePostToFile: Generated content failed validation", zap.Error(err))
+		return "", "", err
+	}
+
+	// A new post never replaces an existing one, and the check must not interleave with a save
 	fullPath := filepath.Join(targetFolder, filename)
+	app.saveMu.Lock()
+	defer app.saveMu.Unlock()
+	if err := app.checkNewPostLocked(request, fullPath, filename); err != nil {
+		return "", "", err
+	}
+
+	// Save the file with detailed error logging
 	logger.Info("savePostToFile: Attempting to save file", zap.String("path", fullPath))
 	if err := app.fileSystem.WriteFile(fullPath, []byte(content), 0644); err != nil {
 		return "", "", err
 	}
+	app.postIndex.Update(fullPath)
+	app.recordRevision(logger, fullPath, fmt.Sprintf("Create %s/%s/%s", request.Language, request.Section, filename))
 	logger.Info("savePostToFile: Successfully saved post",
 		zap.String("path", fullPath),
 		zap.Int("content_length", len(content)),
@@ -896,6 +1284,27 @@ func (app *Application) handleCreatePost(w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
+	// Default to the configured language and section, the permissions depend on the language
+	config := app.configProvider.GetConfig()
+	if request.Language == "" {
+		request.Language = config.DefaultLanguage()
+		logger.Info("handleCreatePost: No language specified, using default", zap.String("language", request.Language))
+	}
+	if request.Section == "" {
+		request.Section = config.DefaultSection()
+		logger.Info("handleCreatePost: No section specified, using default", zap.String("section", request.Section))
+	}
+
+	// New posts belong to their creator and stay drafts unless the creator may publish
+	if err := app.authorize(r, PermPostsWrite, request.Language); err != nil {
+		logger.Warn("handleCreatePost: Language not allowed", zap.String("language", request.Language), zap.Error(err))
+		return err
+	}
+	if user, ok := UserFromContext(r.Context()); ok {
+		request.Owner = user.Username
+		request.ForceDraft = app.authorize(r, PermPostsPublish, request.Language) != nil
+	}
+
 	// Generate slug if not provided
 	if request.Slug == "" {
 		request.Slug = slug.Make(request.Title)
@@ -905,13 +1314,23 @@ func (app *Application) handleCreatePost(w http.ResponseWriter, r *http.Request)
 		)
 	}
 
+	// Claim the post before its thumbnail is written, a duplicate must not touch the images of the existing post
+	release, err := app.reserveNewPost(request)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Process thumbnail
 	if err := app.processThumbnail(request); err != nil {
 		return err
 	}
 
 	// Generate post content
-	content := app.generatePostContent(request)
+	content, err := app.generatePostContent(request)
+	if err != nil {
+		return err
+	}
 
 	// Save post to file
 	filename, fullPath, err := app.savePostToFile(request, content)
@@ -919,16 +1338,27 @@ func (app *Application) handleCreatePost(w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
+	app.recordAudit(r, AuditPostCreate, fullPath, "", contentHash([]byte(content)), nil)
+
 	// Update post metadata
+	taxonomyFiles := []string{"data/tags.json", "data/categories.json"}
+	taxonomyBefore := []string{app.fileHash(taxonomyFiles[0]), app.fileHash(taxonomyFiles[1])}
 	if err := app.updatePostMetadata(request); err != nil {
 		return err
 	}
+	for i, path := range taxonomyFiles {
+		if after := app.fileHash(path); after != taxonomyBefore[i] {
+			app.recordAudit(r, AuditTaxonomyUpdate, path, taxonomyBefore[i], after, map[string]string{"post": fullPath})
+		}
+	}
 
 	// Prepare and send response with proper error handling
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]string{
 		"filename": filename,
 		"path":     fullPath,
+		"language": request.Language,
+		"section":  request.Section,
 	}
 	logger.Debug("handleCreatePost: Preparing response", zap.Any("response", response))
 
@@ -974,56 +1404,115 @@ func createSlug(title string) string {
 	return slug
 }
 
-func generateMarkdownContent(post NewPostRequest) string {
-	var sb strings.Builder
-
-	sb.WriteString("---\n")
-	sb.WriteString(fmt.Sprintf("title: '%s'\n", post.Title))
+// generateMarkdownContent builds the front matter of a new post. Archetype defaults are
+// added for every key the request does not set itself.
+func generateMarkdownContent(post NewPostRequest, defaults map[string]interface{}) (string, error) {
 	if post.Slug == "" {
 		post.Slug = slug.Make(post.Title)
 	}
-	sb.WriteString(fmt.Sprintf("slug: %s\n", post.Slug))
-	if post.Description != "" {
-		sb.WriteString(fmt.Sprintf("description: '%s'\n", post.Description))
-	}
 
 	// Convert date string to time.Time
 	date, err := time.Parse("2006-01-02T15:04", post.Date)
 	if err != nil {
 		date = time.Now()
 	}
-	// Convert date to UTC
-	utcDate := date.UTC()
-	sb.WriteString(fmt.Sprintf("date: %s\n", utcDate.Format(time.RFC3339)))
 
-	if len(post.Tags) > 0 {
-		tags, _ := json.Marshal(post.Tags)
-		sb.WriteString(fmt.Sprintf("tags: %s\n", strings.ReplaceAll(string(tags), "\"", "")))
+	meta := PostMeta{
+		Title:       post.Title,
+		Description: post.Description,
+		Slug:        post.Slug,
+		Date:        date.UTC().Format(time.RFC3339),
+		Draft:       true,
+		Tags:        post.Tags,
+		Categories:  post.Categories,
 	}
-
-	if len(post.Categories) > 0 {
-		categories, _ := json.Marshal(post.Categories)
-		sb.WriteString(fmt.Sprintf("categories: %s\n", strings.ReplaceAll(string(categories), "\"", "")))
+	if post.Thumbnail.URL != "" {
+		meta.Thumbnail = &Thumbnail{
+			URL:       post.Thumbnail.URL,
+			Author:    post.Thumbnail.Author,
+			AuthorURL: post.Thumbnail.AuthorURL,
+			Origin:    post.Thumbnail.Origin,
+		}
 	}
 
-	if post.Thumbnail.URL != "" {
-		sb.WriteString("thumbnail:\n")
-		sb.WriteString(fmt.Sprintf("  url: %s\n", post.Thumbnail.URL))
-		if post.Thumbnail.Author != "" {
-			sb.WriteString(fmt.Sprintf("  author: %s\n", post.Thumbnail.Author))
+	for key, value := range defaults {
+		if strings.EqualFold(key, "draft") {
+			if draft, ok := value.(bool); ok && !post.ForceDraft {
+				meta.Draft = draft
+			}
+			continue
 		}
-		if post.Thumbnail.AuthorURL != "" {
-			sb.WriteString(fmt.Sprintf("  authorUrl: %s\n", post.Thumbnail.AuthorURL))
+		if lookupFrontMatterKey(key) != nil {
+			continue
 		}
-		if post.Thumbnail.Origin != "" {
-			sb.WriteString(fmt.Sprintf("  origin: %s\n", post.Thumbnail.Origin))
+		if meta.Params == nil {
+			meta.Params = make(map[string]interface{})
+		}
+		meta.Params[key] = value
+	}
+
+	if post.Owner != "" {
+		if meta.Params == nil {
+			meta.Params = make(map[string]interface{})
 		}
+		meta.Params[postOwnerKey] = post.Owner
+	}
+
+	generated := &Post{PostMeta: meta, Format: FrontMatterYAML}
+	content, err := generated.Bytes()
+	if err != nil {
+		return "", err
 	}
+	return string(content), nil
+}
 
-	sb.WriteString("draft: true\n")
+// validatePostContent parses generated content and checks that the front matter holds exactly what was requested
+func validatePostContent(content string, request *NewPostRequest) error {
+	post, err := ParsePost([]byte(content))
+	if err != nil {
+		return NewValidationError(frontMatterErrorField(content, err), "Generated front matter is not valid", err)
+	}
+
+	checks := []struct {
+		field    string
+		expected interface{}
+		actual   interface{}
+	}{
+		{"title", request.Title, post.Title},
+		{"description", request.Description, post.Description},
+		{"slug", request.Slug, post.Slug},
+		{"tags", request.Tags, post.Tags},
+		{"categories", request.Categories, post.Categories},
+	}
+	if request.Thumbnail.URL != "" {
+		if post.Thumbnail == nil {
+			return NewValidationError("thumbnail", "Thumbnail is missing from generated front matter", nil)
+		}
+		checks = append(checks, []struct {
+			field    string
+			expected interface{}
+			actual   interface{}
+		}{
+			{"thumbnail.url", request.Thumbnail.URL, post.Thumbnail.URL},
+			{"thumbnail.author", request.Thumbnail.Author, post.Thumbnail.Author},
+			{"thumbnail.authorUrl", request.Thumbnail.AuthorURL, post.Thumbnail.AuthorURL},
+			{"thumbnail.origin", request.Thumbnail.Origin, post.Thumbnail.Origin},
+		}...)
+	}
+
+	for _, check := range checks {
+		if isEmptyValue("", check.expected) && isEmptyValue("", check.actual) {
+			continue
+		}
+		if !sameValue(check.expected, check.actual) {
+			return NewValidationError(check.field, "Value does not survive encoding as front matter", nil)
+		}
+	}
 
-	sb.WriteString("---\n")
-	return sb.String()
+	if _, ok := post.PublishedAt(); !ok {
+		return NewValidationError("date", "Generated date is not valid", nil)
+	}
+	return nil
 }
 
 // getAllTags reads and returns all tags from the tags data file
@@ -1217,14 +1706,23 @@ func NewImageProcessingServiceImpl(configProvider ConfigProvider, fileSystem Fil
 	}
 }
 
-// ProcessMediaFile processes the media file and returns the new filename
-func (s *ImageProcessingServiceImpl) ProcessMediaFile(request MediaProcessRequest) (string, error) {
+// ProcessMediaFile resizes a media file, its thumbnail and all configured variants in one pass over the decoded image.
+// It returns the manifest of the processed image, which is also saved next to it.
+func (s *ImageProcessingServiceImpl) ProcessMediaFile(request MediaProcessRequest) (*ImageManifest, error) {
 	logger := s.logger
 	logger.Info("ProcessMediaFile: Processing file",
 		zap.String("file", request.File),
 		zap.String("new_name", request.NewName),
 	)
 
+	// The names become paths of the processed images, so they are checked here
+	if err := validateRelativePath("file", request.File); err != nil {
+		return nil, err
+	}
+	if err := validateRelativePath("newName", request.NewName); err != nil {
+		return nil, err
+	}
+
 	config := s.configProvider.GetConfig()
 	sourceFile := filepath.Join(config.Server.MediaFolder, request.File)
 	ext := filepath.Ext(request.File)
@@ -1236,13 +1734,31 @@ func (s *ImageProcessingServiceImpl) ProcessMediaFile(request MediaProcessReques
 		zap.String("destination", destFile),
 	)
 
-	// Open the source image
-	src, err := imaging.Open(sourceFile)
+	// Open the source image, turned upright as its EXIF orientation says
+	data, err := s.fileSystem.ReadFile(sourceFile)
+	if err != nil {
+		return nil, err
+	}
+	src, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
 	if err != nil {
-		return "", NewFileSystemError("Open", sourceFile, "Error opening source image", err)
+		return nil, NewFileSystemError("Open", sourceFile, "Error opening source image", err)
 	}
 	logger.Info("ProcessMediaFile: Source image opened successfully")
 
+	// Thumbnails and "fill" crops follow the focal point or crop rectangle of the media file
+	sidecar, err := readMediaSidecar(s.fileSystem, config.Server.MediaFolder, request.File)
+	if err != nil {
+		return nil, err
+	}
+	crop := sidecar.Crop
+
+	// Processed images keep the credits of a photo, location and camera only when configured
+	var exifSegment []byte
+	if x := photoExif(data); x != nil {
+		exifSegment = x.sanitized(config.Server.ImageResize.Metadata.KeepLocation, config.Server.ImageResize.Metadata.KeepDevice)
+		logger.Info("ProcessMediaFile: Source image has EXIF data", zap.Bool("has_location", len(x.gps) > 0))
+	}
+
 	// Get the dimensions of the source image
 	srcWidth := src.Bounds().Dx()
 	srcHeight := src.Bounds().Dy()
@@ -1251,45 +1767,76 @@ func (s *ImageProcessingServiceImpl) ProcessMediaFile(request MediaProcessReques
 		zap.Int("height", srcHeight),
 	)
 
-	// Calculate the new height to maintain the aspect ratio
-	newHeight := (config.Server.ImageResize.MaxWidth * srcHeight) / srcWidth
-	logger.Info("ProcessMediaFile: Calculated new height", zap.Int("height", newHeight))
-
-	// Resize the image
+	// Resize the image, a "fill" crop is taken from the crop rectangle or around the focal point
 	var resized image.Image
 	if config.Server.ImageResize.Method == "fit" {
+		newHeight := (config.Server.ImageResize.MaxWidth * srcHeight) / srcWidth
+		logger.Info("ProcessMediaFile: Calculated new height", zap.Int("height", newHeight))
 		resized = imaging.Fit(src, config.Server.ImageResize.MaxWidth, newHeight, imaging.Lanczos)
 		logger.Info("ProcessMediaFile: Image resized using 'fit' method")
 	} else {
-		resized = imaging.Fill(src, config.Server.ImageResize.MaxWidth, newHeight, imaging.Center, imaging.Lanczos)
+		fillSource, fillCropArea := src, crop
+		if rect, ok := crop.rectangle(src.Bounds()); ok {
+			fillSource, fillCropArea = imaging.Crop(src, rect), nil
+			logger.Info("ProcessMediaFile: Image uses crop rectangle", zap.Stringer("rect", rect))
+		}
+		newHeight := (config.Server.ImageResize.MaxWidth * fillSource.Bounds().Dy()) / fillSource.Bounds().Dx()
+		logger.Info("ProcessMediaFile: Calculated new height", zap.Int("height", newHeight))
+		resized = fillCrop(fillSource, config.Server.ImageResize.MaxWidth, newHeight, fillCropArea)
 		logger.Info("ProcessMediaFile: Image resized using 'fill' method")
 	}
 
 	// Save the resized image
-	err = imaging.Save(resized, destFile)
-	if err != nil {
-		return "", NewFileSystemError("Save", destFile, "Error saving resized image", err)
+	if err := s.saveImage(resized, destFile, exifSegment); err != nil {
+		return nil, err
 	}
 	logger.Info("ProcessMediaFile: Resized image saved", zap.String("path", destFile))
 
-	// Create and save thumbnail
+	// Create and save thumbnail from the crop rectangle or the whole image, with a height that follows its own width
+	thumbnailSource, thumbnailCrop := src, crop
+	if rect, ok := crop.rectangle(src.Bounds()); ok {
+		thumbnailSource, thumbnailCrop = imaging.Crop(src, rect), nil
+		logger.Info("ProcessMediaFile: Thumbnail uses crop rectangle", zap.Stringer("rect", rect))
+	}
+	thumbnailWidth := thumbnailSource.Bounds().Dx()
+	thumbnailHeight := (config.Server.ThumbnailResize.MaxWidth*thumbnailSource.Bounds().Dy() + thumbnailWidth/2) / thumbnailWidth
 	var thumbnail image.Image
 	if config.Server.ThumbnailResize.Method == "fit" {
-		thumbnail = imaging.Fit(src, config.Server.ThumbnailResize.MaxWidth, newHeight, imaging.Lanczos)
+		thumbnail = imaging.Fit(thumbnailSource, config.Server.ThumbnailResize.MaxWidth, thumbnailHeight, imaging.Lanczos)
 		logger.Info("ProcessMediaFile: Thumbnail resized using 'fit' method")
 	} else {
-		thumbnail = imaging.Fill(src, config.Server.ThumbnailResize.MaxWidth, newHeight, imaging.Center, imaging.Lanczos)
+		thumbnail = fillCrop(thumbnailSource, config.Server.ThumbnailResize.MaxWidth, thumbnailHeight, thumbnailCrop)
 		logger.Info("ProcessMediaFile: Thumbnail resized using 'fill' method")
 	}
 
 	thumbnailFile := filepath.Join(config.Server.AssetFolder, "thumb_"+newFileName)
-	err = imaging.Save(thumbnail, thumbnailFile)
-	if err != nil {
-		return "", NewFileSystemError("Save", thumbnailFile, "Error saving thumbnail", err)
+	if err := s.saveImage(thumbnail, thumbnailFile, exifSegment); err != nil {
+		return nil, err
 	}
 	logger.Info("ProcessMediaFile: Thumbnail saved", zap.String("path", thumbnailFile))
 
-	return newFileName, nil
+	variants, err := s.saveVariants(src, request.NewName, ext, config, exifSegment, crop)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &ImageManifest{
+		Source:   request.File,
+		Filename: newFileName,
+		Width:    resized.Bounds().Dx(),
+		Height:   resized.Bounds().Dy(),
+		Variants: variants,
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	manifestFile := filepath.Join(config.Server.AssetFolder, manifestFileName(request.NewName))
+	if err := s.fileSystem.WriteFile(manifestFile, manifestJSON, 0644); err != nil {
+		return nil, err
+	}
+	logger.Info("ProcessMediaFile: Manifest saved", zap.String("path", manifestFile), zap.Int("variants", len(variants)))
+
+	return manifest, nil
 }
 
 // processMediaFile processes the media file and returns the new filename
@@ -1302,7 +1849,11 @@ func (app *Application) processMediaFile(request struct {
 		File:    request.File,
 		NewName: request.NewName,
 	}
-	return app.imageProcessor.ProcessMediaFile(mediaRequest)
+	manifest, err := app.imageProcessor.ProcessMediaFile(mediaRequest)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Filename, nil
 }
 
 func (app *Application) handleUploadMediaFolder(w http.ResponseWriter, r *http.Request) error {
@@ -1334,18 +1885,23 @@ func (app *Application) handleUploadMediaFolder(w http.ResponseWriter, r *http.R
 	fullPath := filepath.Join(config.Server.MediaFolder, filename)
 	logger.Info("handleUploadMediaFolder: Saving uploaded file", zap.String("path", fullPath))
 
-	// Create a new file in the media folder
-	dst, err := app.fileSystem.Create(fullPath)
+	// Write the upload to a temporary file, it only appears in the media folder once it is complete
+	dst, err := app.fileSystem.CreateTemp(fullPath, 0644)
 	if err != nil {
 		return err
 	}
 	defer dst.Close()
 
 	// Copy the uploaded file to the destination
-	_, err = io.Copy(dst, file)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), file)
 	if err != nil {
 		return err
 	}
+	if err := dst.Commit(); err != nil {
+		return err
+	}
+	app.recordAudit(r, AuditMediaUpload, fullPath, "", hex.EncodeToString(hash.Sum(nil)), map[string]string{"originalName": header.Filename})
 
 	// Return the filename in the response
 	response := struct {
@@ -1374,9 +1930,9 @@ func (app *Application) handleDeleteMedia(w http.ResponseWriter, r *http.Request
 	}
 
 	// Ensure the filename is safe
-	if strings.Contains(filename, "..") {
+	if err := validateRelativePath("filename", filename); err != nil {
 		logger.Warn("handleDeleteMedia: Invalid filename attempted", zap.String("filename", filename))
-		return NewValidationError("filename", "Invalid filename", nil)
+		return err
 	}
 
 	// Create the full path for the file
@@ -1390,12 +1946,23 @@ func (app *Application) handleDeleteMedia(w http.ResponseWriter, r *http.Request
 	}
 
 	// Delete the file
+	hashBefore := app.fileHash(fullPath)
 	err := app.fileSystem.Remove(fullPath)
 	if err != nil {
 		return err
 	}
+	app.recordAudit(r, AuditMediaDelete, fullPath, hashBefore, "", nil)
 	logger.Info("handleDeleteMedia: Successfully deleted file", zap.String("path", fullPath))
 
+	// The metadata and crop of the file go with it
+	app.sidecarMu.Lock()
+	if sidecarPath := mediaSidecarPath(config.Server.MediaFolder, filename); app.fileHash(sidecarPath) != "" {
+		if err := app.fileSystem.Remove(sidecarPath); err != nil {
+			logger.Warn("handleDeleteMedia: Error deleting media sidecar", zap.String("path", sidecarPath), zap.Error(err))
+		}
+	}
+	app.sidecarMu.Unlock()
+
 	// Also delete thumbnail if it exists
 	thumbPath := filepath.Join(config.Server.MediaFolder, "thumb_"+filename)
 	err = app.fileSystem.Remove(thumbPath)
@@ -1417,7 +1984,7 @@ func (app *Application) createImageWithImagePig(prompt string, outputFile string
 	logger.Info("createImageWithImagePig: Getting API key from configuration")
 
 	// Get API key from configuration
-	apiKey := app.config.Secrets.ImagePigAPIKey
+	apiKey := app.configProvider.GetConfig().Secrets.ImagePigAPIKey
 	if apiKey == "" {
 		// Fallback to environment variable for backward compatibility
 		logger.Warn("createImageWithImagePig: API key not found in configuration, trying environment variable")
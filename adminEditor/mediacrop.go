@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"go.uber.org/zap"
+)
+
+// MediaCrop keeps the subject of a media file in view when it is cropped: either a focal point
+// that "fill" crops are centered on, or an explicit rectangle that thumbnails and "fill" crops are taken from.
+type MediaCrop struct {
+	Focus *FocalPoint `json:"focus,omitempty"`
+	Rect  *CropRect   `json:"rect,omitempty"`
+}
+
+// FocalPoint is a point of an image as fractions of its width and height, 0.5/0.5 is the center
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// CropRect is a rectangle in pixels of the upright source image
+type CropRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// validate checks a crop against the size of the upright source image
+func (c *MediaCrop) validate(bounds image.Rectangle) error {
+	if c.Focus != nil && c.Rect != nil {
+		return NewValidationError("crop", "Set either a focal point or a crop rectangle, not both", nil)
+	}
+	if c.Focus != nil && (c.Focus.X < 0 || c.Focus.X > 1 || c.Focus.Y < 0 || c.Focus.Y > 1) {
+		return NewValidationError("focus", "Focal point must be between 0 and 1", nil)
+	}
+	if c.Rect != nil {
+		rect := image.Rect(c.Rect.X, c.Rect.Y, c.Rect.X+c.Rect.Width, c.Rect.Y+c.Rect.Height)
+		if c.Rect.Width <= 0 || c.Rect.Height <= 0 || c.Rect.X < 0 || c.Rect.Y < 0 || !rect.In(image.Rect(0, 0, bounds.Dx(), bounds.Dy())) {
+			return NewValidationError("rect", fmt.Sprintf("Crop rectangle must lie within the image of %dx%d pixels", bounds.Dx(), bounds.Dy()), nil)
+		}
+	}
+	return nil
+}
+
+// rectangle returns the explicit crop rectangle within an image, false when there is none
+func (c *MediaCrop) rectangle(bounds image.Rectangle) (image.Rectangle, bool) {
+	if c == nil || c.Rect == nil {
+		return image.Rectangle{}, false
+	}
+	rect := image.Rect(c.Rect.X, c.Rect.Y, c.Rect.X+c.Rect.Width, c.Rect.Y+c.Rect.Height).Add(bounds.Min).Intersect(bounds)
+	return rect, !rect.Empty()
+}
+
+// fillCrop scales and crops an image to exactly width x height. An explicit crop rectangle is filled,
+// otherwise the largest area of the target's aspect ratio around the focal point, or the center without one.
+func fillCrop(src image.Image, width, height int, crop *MediaCrop) image.Image {
+	if rect, ok := crop.rectangle(src.Bounds()); ok {
+		return imaging.Fill(imaging.Crop(src, rect), width, height, imaging.Center, imaging.Lanczos)
+	}
+	if crop == nil || crop.Focus == nil {
+		return imaging.Fill(src, width, height, imaging.Center, imaging.Lanczos)
+	}
+
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	cropWidth, cropHeight := srcWidth, srcHeight
+	if srcWidth*height > srcHeight*width {
+		cropWidth = (srcHeight*width + height/2) / height
+	} else {
+		cropHeight = (srcWidth*height + width/2) / width
+	}
+	x := min(max(int(crop.Focus.X*float64(srcWidth))-cropWidth/2, 0), srcWidth-cropWidth)
+	y := min(max(int(crop.Focus.Y*float64(srcHeight))-cropHeight/2, 0), srcHeight-cropHeight)
+	area := image.Rect(x, y, x+cropWidth, y+cropHeight).Add(bounds.Min)
+	return imaging.Resize(imaging.Crop(src, area), width, height, imaging.Lanczos)
+}
+
+// derivedImages returns the names the processed images of a media file were saved under, read from the manifests
+func (app *Application) derivedImages(assetFolder, name string) ([]string, error) {
+	files, err := app.fileSystem.ReadDir(assetFolder)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".variants.json") {
+			continue
+		}
+		data, err := app.fileSystem.ReadFile(filepath.Join(assetFolder, file.Name()))
+		if err != nil {
+			continue
+		}
+		var manifest ImageManifest
+		if json.Unmarshal(data, &manifest) == nil && manifest.Source == name {
+			names = append(names, strings.TrimSuffix(manifest.Filename, filepath.Ext(manifest.Filename)))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// handleMediaCrop returns or sets the focal point or crop rectangle of a media file.
+// Setting it processes the images derived from the file again, so their thumbnails and "fill" variants follow it.
+// An empty crop resets the file to center crops.
+func (app *Application) handleMediaCrop(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		logger.Warn("handleMediaCrop: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	name := r.PathValue("name")
+	if err := validateRelativePath("name", name); err != nil {
+		return err
+	}
+	config := app.configProvider.GetConfig()
+	mediaFolder := config.Server.MediaFolder
+
+	if r.Method == http.MethodGet {
+		sidecar, err := readMediaSidecar(app.fileSystem, mediaFolder, name)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(map[string]interface{}{
+			"name": name,
+			"crop": sidecar.Crop,
+		})
+	}
+
+	var crop MediaCrop
+	if err := json.NewDecoder(r.Body).Decode(&crop); err != nil {
+		logger.Error("handleMediaCrop: Invalid request body", zap.Error(err))
+		return NewValidationError("request_body", "Invalid request body", err)
+	}
+	data, err := app.fileSystem.ReadFile(filepath.Join(mediaFolder, name))
+	if err != nil {
+		logger.Warn("handleMediaCrop: Media file not found", zap.String("name", name), zap.Error(err))
+		return NewValidationError("name", "Media file not found", nil)
+	}
+	src, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return NewValidationError("name", "Media file is not an image that can be cropped", err)
+	}
+	if err := crop.validate(src.Bounds()); err != nil {
+		return err
+	}
+
+	sidecar, hashBefore, hashAfter, err := app.setMediaCrop(mediaFolder, name, &crop)
+	if err != nil {
+		return err
+	}
+
+	derived, err := app.derivedImages(config.Server.AssetFolder, name)
+	if err != nil {
+		return err
+	}
+	rendered := []string{}
+	for _, newName := range derived {
+		manifest, err := app.imageProcessor.ProcessMediaFile(MediaProcessRequest{File: name, NewName: newName})
+		if err != nil {
+			logger.Error("handleMediaCrop: Error processing derived image", zap.String("new_name", newName), zap.Error(err))
+			return err
+		}
+		rendered = append(rendered, manifest.Filename)
+	}
+	app.recordAudit(r, AuditMediaMeta, mediaSidecarPath(mediaFolder, name), hashBefore, hashAfter, map[string]string{
+		"media":    name,
+		"rendered": strings.Join(rendered, ","),
+	})
+
+	logger.Info("handleMediaCrop: Crop saved", zap.String("name", name), zap.Strings("rendered", rendered))
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":     name,
+		"crop":     sidecar.Crop,
+		"rendered": rendered,
+	})
+}
+
+// setMediaCrop saves the crop of a media file in its sidecar, an empty crop removes it.
+// It returns the updated sidecar and the hashes of the sidecar file before and after the change.
+func (app *Application) setMediaCrop(mediaFolder, name string, crop *MediaCrop) (MediaSidecar, string, string, error) {
+	// The crop shares the sidecar with the metadata, a change of either must not overwrite the other
+	app.sidecarMu.Lock()
+	defer app.sidecarMu.Unlock()
+
+	sidecar, err := readMediaSidecar(app.fileSystem, mediaFolder, name)
+	if err != nil {
+		return sidecar, "", "", err
+	}
+	sidecarPath := mediaSidecarPath(mediaFolder, name)
+	hashBefore := app.fileHash(sidecarPath)
+	sidecar.Crop = crop
+	if crop.Focus == nil && crop.Rect == nil {
+		sidecar.Crop = nil
+	}
+	if err := writeMediaSidecar(app.fileSystem, mediaFolder, name, sidecar); err != nil {
+		return sidecar, "", "", err
+	}
+	return sidecar, hashBefore, app.fileHash(sidecarPath), nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"go.uber.org/zap"
+)
+
+var (
+	red  = color.NRGBA{R: 255, A: 255}
+	blue = color.NRGBA{B: 255, A: 255}
+)
+
+// halvesImage is red on the left and blue on the right half
+func halvesImage(width, height int) *image.NRGBA {
+	return testImage(width, height, func(x, y int) color.NRGBA {
+		if x < width/2 {
+			return red
+		}
+		return blue
+	})
+}
+
+// newTestImageProcessor processes media from a temporary media folder with "fill" resizing
+func newTestImageProcessor(t *testing.T, crop *MediaCrop) (*ImageProcessingServiceImpl, Config) {
+	t.Helper()
+	dir := t.TempDir()
+	var config Config
+	config.Server.MediaFolder = filepath.Join(dir, "media")
+	config.Server.AssetFolder = filepath.Join(dir, "assets")
+	config.Server.ImageResize.Method = "fill"
+	config.Server.ImageResize.MaxWidth = 100
+	config.Server.ImageResize.Variants = []ImageVariant{{Name: "square", Width: 40, Height: 40, Method: "fill"}}
+	config.Server.ThumbnailResize.Method = "fill"
+	config.Server.ThumbnailResize.MaxWidth = 50
+	for _, folder := range []string{config.Server.MediaFolder, config.Server.AssetFolder} {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := imaging.Save(halvesImage(200, 100), filepath.Join(config.Server.MediaFolder, "photo.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := &Logger{Logger: zap.NewNop()}
+	fs := NewOSFileSystem(logger)
+	if crop != nil {
+		if err := writeMediaSidecar(fs, config.Server.MediaFolder, "photo.png", MediaSidecar{Crop: crop}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewImageProcessingServiceImpl(NewAppConfig(&config, nil, logger), fs, logger), config
+}
+
+// colorAt returns the color of a pixel given as fractions of the width and height of an image
+func colorAt(t *testing.T, path string, x, y float64) color.NRGBA {
+	t.Helper()
+	img, err := imaging.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := img.Bounds()
+	return color.NRGBAModel.Convert(img.At(bounds.Min.X+int(x*float64(bounds.Dx())), bounds.Min.Y+int(y*float64(bounds.Dy())))).(color.NRGBA)
+}
+
+func TestProcessMediaFileFillFollowsCrop(t *testing.T) {
+	tests := []struct {
+		name       string
+		crop       *MediaCrop
+		wantSize   image.Point
+		wantLeft   color.NRGBA
+		wantRight  color.NRGBA
+		wantSquare color.NRGBA // left of the square variant
+	}{
+		{
+			name:       "without crop",
+			wantSize:   image.Pt(100, 50),
+			wantLeft:   red,
+			wantRight:  blue,
+			wantSquare: red,
+		},
+		{
+			name:       "crop rectangle",
+			crop:       &MediaCrop{Rect: &CropRect{X: 100, Y: 0, Width: 100, Height: 100}},
+			wantSize:   image.Pt(100, 100),
+			wantLeft:   blue,
+			wantRight:  blue,
+			wantSquare: blue,
+		},
+		{
+			// The main image keeps the aspect ratio of the source, only crops to another ratio can move
+			name:       "focal point on the right",
+			crop:       &MediaCrop{Focus: &FocalPoint{X: 0.9, Y: 0.5}},
+			wantSize:   image.Pt(100, 50),
+			wantLeft:   red,
+			wantRight:  blue,
+			wantSquare: blue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, config := newTestImageProcessor(t, tt.crop)
+			manifest, err := processor.ProcessMediaFile(MediaProcessRequest{File: "photo.png", NewName: "processed"})
+			if err != nil {
+				t.Fatalf("ProcessMediaFile() error = %v", err)
+			}
+			if size := image.Pt(manifest.Width, manifest.Height); size != tt.wantSize {
+				t.Errorf("main image size = %v, want %v", size, tt.wantSize)
+			}
+
+			main := filepath.Join(config.Server.AssetFolder, "processed.png")
+			if got := colorAt(t, main, 0.1, 0.5); got != tt.wantLeft {
+				t.Errorf("left of the main image = %v, want %v", got, tt.wantLeft)
+			}
+			if got := colorAt(t, main, 0.9, 0.5); got != tt.wantRight {
+				t.Errorf("right of the main image = %v, want %v", got, tt.wantRight)
+			}
+			thumbnail := filepath.Join(config.Server.AssetFolder, "thumb_processed.png")
+			if got := colorAt(t, thumbnail, 0.9, 0.5); got != tt.wantRight {
+				t.Errorf("right of the thumbnail = %v, want %v", got, tt.wantRight)
+			}
+			square := filepath.Join(config.Server.AssetFolder, "processed-square.png")
+			if got := colorAt(t, square, 0.1, 0.5); got != tt.wantSquare {
+				t.Errorf("left of the square variant = %v, want %v", got, tt.wantSquare)
+			}
+		})
+	}
+}
+
+// stripesImage has four stripes of red, green, blue and white, side by side or one below the other
+func stripesImage(width, height int, horizontal bool) *image.NRGBA {
+	colors := []color.NRGBA{red, {G: 255, A: 255}, blue, {R: 255, G: 255, B: 255, A: 255}}
+	return testImage(width, height, func(x, y int) color.NRGBA {
+		if horizontal {
+			return colors[y*4/height]
+		}
+		return colors[x*4/width]
+	})
+}
+
+func TestFillCrop(t *testing.T) {
+	green, white := color.NRGBA{G: 255, A: 255}, color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	wide, tall := stripesImage(200, 100, false), stripesImage(100, 200, true)
+
+	tests := []struct {
+		name string
+		src  image.Image
+		// the target is one stripe of the source, so the crop is not scaled
+		width, height int
+		crop          *MediaCrop
+		want          color.NRGBA
+	}{
+		{name: "focal point on the left", src: wide, width: 50, height: 100, crop: &MediaCrop{Focus: &FocalPoint{X: 0.1, Y: 0.5}}, want: red},
+		{name: "focal point centered on a stripe", src: wide, width: 50, height: 100, crop: &MediaCrop{Focus: &FocalPoint{X: 0.375, Y: 0.5}}, want: green},
+		{name: "focal point right of the center", src: wide, width: 50, height: 100, crop: &MediaCrop{Focus: &FocalPoint{X: 0.625, Y: 0}}, want: blue},
+		{name: "focal point on the right edge", src: wide, width: 50, height: 100, crop: &MediaCrop{Focus: &FocalPoint{X: 1, Y: 1}}, want: white},
+		{name: "focal point on the top", src: tall, width: 100, height: 50, crop: &MediaCrop{Focus: &FocalPoint{X: 0.5, Y: 0}}, want: red},
+		{name: "focal point on the bottom", src: tall, width: 100, height: 50, crop: &MediaCrop{Focus: &FocalPoint{X: 0.5, Y: 0.9}}, want: white},
+		{name: "crop rectangle", src: wide, width: 50, height: 100, crop: &MediaCrop{Rect: &CropRect{X: 100, Y: 0, Width: 50, Height: 100}}, want: blue},
+		{name: "sub-image", src: wide.SubImage(image.Rect(50, 0, 200, 100)), width: 50, height: 100, crop: &MediaCrop{Focus: &FocalPoint{X: 0, Y: 0.5}}, want: green},
+		{name: "sub-image with crop rectangle", src: wide.SubImage(image.Rect(50, 0, 200, 100)), width: 50, height: 100, crop: &MediaCrop{Rect: &CropRect{X: 100, Y: 0, Width: 50, Height: 100}}, want: white},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fillCrop(tt.src, tt.width, tt.height, tt.crop)
+			if size := got.Bounds().Size(); size != image.Pt(tt.width, tt.height) {
+				t.Fatalf("fillCrop() size = %v, want %dx%d", size, tt.width, tt.height)
+			}
+			bounds := got.Bounds()
+			for _, p := range []image.Point{bounds.Min, bounds.Max.Sub(image.Pt(1, 1)), bounds.Min.Add(bounds.Size().Div(2))} {
+				if c := color.NRGBAModel.Convert(got.At(p.X, p.Y)); c != tt.want {
+					t.Errorf("pixel %v = %v, want %v", p, c, tt.want)
+				}
+			}
+		})
+	}
+
+	// Without a focal point, the center is kept
+	centered := fillCrop(wide, 100, 100, nil)
+	if left, right := colorOf(centered, 5, 50), colorOf(centered, 94, 50); left != green || right != blue {
+		t.Errorf("centered crop is %v to %v, want green to blue", left, right)
+	}
+}
+
+func colorOf(img image.Image, x, y int) color.NRGBA {
+	return color.NRGBAModel.Convert(img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y)).(color.NRGBA)
+}
+
+func TestMediaCropValidate(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	rect := func(x, y, width, height int) *MediaCrop {
+		return &MediaCrop{Rect: &CropRect{X: x, Y: y, Width: width, Height: height}}
+	}
+	focus := func(x, y float64) *MediaCrop {
+		return &MediaCrop{Focus: &FocalPoint{X: x, Y: y}}
+	}
+
+	tests := []struct {
+		name    string
+		crop    *MediaCrop
+		bounds  image.Rectangle
+		wantErr bool
+	}{
+		{name: "no crop", crop: &MediaCrop{}},
+		{name: "focal point at the origin", crop: focus(0, 0)},
+		{name: "focal point at the far corner", crop: focus(1, 1)},
+		{name: "focal point left of the image", crop: focus(-0.01, 0.5), wantErr: true},
+		{name: "focal point below the image", crop: focus(0.5, 1.01), wantErr: true},
+		{name: "focal point and rectangle", crop: &MediaCrop{Focus: &FocalPoint{X: 0.5, Y: 0.5}, Rect: &CropRect{Width: 10, Height: 10}}, wantErr: true},
+		{name: "whole image", crop: rect(0, 0, 200, 100)},
+		{name: "last pixel", crop: rect(199, 99, 1, 1)},
+		{name: "zero width", crop: rect(0, 0, 0, 10), wantErr: true},
+		{name: "negative height", crop: rect(10, 50, 10, -10), wantErr: true},
+		{name: "negative x", crop: rect(-1, 0, 10, 10), wantErr: true},
+		{name: "one pixel too wide", crop: rect(100, 0, 101, 10), wantErr: true},
+		{name: "one pixel too high", crop: rect(0, 1, 10, 100), wantErr: true},
+		{name: "image that does not start at the origin", crop: rect(0, 0, 200, 100), bounds: image.Rect(10, 10, 210, 110)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := bounds
+			if !tt.bounds.Empty() {
+				b = tt.bounds
+			}
+			if err := tt.crop.validate(b); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// mediaMetaFolder is the folder below MediaFolder with a JSON sidecar for each media file
+const mediaMetaFolder = ".meta"
+
+// maxMediaTextLength limits the length of the texts recorded about a media file
+const maxMediaTextLength = 2000
+
+// MediaMetadata describes a media file for the posts it is used in, such as the credits of a thumbnail
+type MediaMetadata struct {
+	Alt       string `json:"alt,omitempty"`
+	Caption   string `json:"caption,omitempty"`
+	Author    string `json:"author,omitempty"`
+	AuthorURL string `json:"authorUrl,omitempty"`
+	Origin    string `json:"origin,omitempty"`
+	License   string `json:"license,omitempty"`
+}
+
+// MediaSidecar is what the editor records about a media file, saved as ".meta/<name>.json" in the media folder
+type MediaSidecar struct {
+	MediaMetadata
+	Crop *MediaCrop `json:"crop,omitempty"`
+}
+
+// MediaRecord is a media file of the library with its file information and sidecar.
+// Width and height are those of the upright image and missing for formats that cannot be decoded.
+type MediaRecord struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Width   int       `json:"width,omitempty"`
+	Height  int       `json:"height,omitempty"`
+	MediaSidecar
+}
+
+// mediaDimensionCache remembers the dimensions of media images, so listing the library
+// only decodes files that are new or changed
+type mediaDimensionCache struct {
+	mu      sync.Mutex
+	entries map[string]mediaDimensions
+}
+
+type mediaDimensions struct {
+	size    int64
+	modTime time.Time
+	width   int
+	height  int
+}
+
+// mediaSidecarPath returns the path of the sidecar of a media file
+func mediaSidecarPath(mediaFolder, name string) string {
+	return filepath.Join(mediaFolder, mediaMetaFolder, name+".json")
+}
+
+// readMediaSidecar reads the sidecar of a media file, a file without one has an empty sidecar
+func readMediaSidecar(fs FileSystem, mediaFolder, name string) (MediaSidecar, error) {
+	var sidecar MediaSidecar
+	data, err := fs.ReadFile(mediaSidecarPath(mediaFolder, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return sidecar, nil
+		}
+		return sidecar, err
+	}
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return sidecar, NewFileSystemError("Unmarshal", mediaSidecarPath(mediaFolder, name), "Invalid media sidecar", err)
+	}
+	return sidecar, nil
+}
+
+// writeMediaSidecar saves the sidecar of a media file
+func writeMediaSidecar(fs FileSystem, mediaFolder, name string, sidecar MediaSidecar) error {
+	path := mediaSidecarPath(mediaFolder, name)
+	if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fs.WriteFile(path, data, 0644)
+}
+
+// dimensions returns the upright width and height of a media image, 0x0 when it cannot be decoded
+func (c *mediaDimensionCache) dimensions(fs FileSystem, path string, info os.FileInfo) (int, int) {
+	c.mu.Lock()
+	cached, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.width, cached.height
+	}
+
+	entry := mediaDimensions{size: info.Size(), modTime: info.ModTime()}
+	if data, err := fs.ReadFile(path); err == nil {
+		if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			entry.width, entry.height = config.Width, config.Height
+			// Orientations 5 to 8 turn the image by 90 degrees
+			if x := photoExif(data); x != nil && x.metadata().Orientation >= 5 {
+				entry.width, entry.height = entry.height, entry.width
+			}
+		}
+	}
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]mediaDimensions)
+	}
+	c.entries[path] = entry
+	c.mu.Unlock()
+	return entry.width, entry.height
+}
+
+// mediaRecord returns the record of a media file of the library
+func (app *Application) mediaRecord(mediaFolder string, info os.FileInfo, sidecar MediaSidecar) MediaRecord {
+	width, height := app.mediaSizes.dimensions(app.fileSystem, filepath.Join(mediaFolder, info.Name()), info)
+	return MediaRecord{
+		Name:         info.Name(),
+		Size:         info.Size(),
+		ModTime:      info.ModTime(),
+		Width:        width,
+		Height:       height,
+		MediaSidecar: sidecar,
+	}
+}
+
+// mediaRecords lists the media files with their sidecars, sorted by name
+func (app *Application) mediaRecords(mediaFolder string) ([]MediaRecord, error) {
+	files, err := app.fileSystem.ReadDir(mediaFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only files with a sidecar are read, the sidecar folder is created at startup
+	sidecars := make(map[string]bool)
+	if metaFiles, err := app.fileSystem.ReadDir(filepath.Join(mediaFolder, mediaMetaFolder)); err == nil {
+		for _, file := range metaFiles {
+			sidecars[strings.TrimSuffix(file.Name(), ".json")] = true
+		}
+	}
+
+	records := []MediaRecord{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		var sidecar MediaSidecar
+		if sidecars[file.Name()] {
+			if sidecar, err = readMediaSidecar(app.fileSystem, mediaFolder, file.Name()); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, app.mediaRecord(mediaFolder, file, sidecar))
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// validateMediaMetadata trims the texts recorded about a media file and checks them
+func validateMediaMetadata(metadata *MediaMetadata) error {
+	fields := []struct {
+		name      string
+		value     *string
+		multiline bool
+	}{
+		{"alt", &metadata.Alt, false},
+		{"caption", &metadata.Caption, true},
+		{"author", &metadata.Author, false},
+		{"authorUrl", &metadata.AuthorURL, false},
+		{"origin", &metadata.Origin, false},
+		{"license", &metadata.License, false},
+	}
+	for _, field := range fields {
+		*field.value = strings.TrimSpace(*field.value)
+		if len(*field.value) > maxMediaTextLength {
+			return NewValidationError(field.name, "Text is too long", nil)
+		}
+		if !field.multiline && strings.ContainsAny(*field.value, "\r\n") {
+			return NewValidationError(field.name, "Text must be a single line", nil)
+		}
+	}
+	if metadata.AuthorURL != "" {
+		if u, err := url.Parse(metadata.AuthorURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return NewValidationError("authorUrl", "Author URL must be an http or https URL", nil)
+		}
+	}
+	return nil
+}
+
+// handleMediaRecord returns, updates or clears the metadata of a media file.
+// PUT changes the fields it contains and keeps the others, DELETE clears the metadata but keeps the file and its crop.
+func (app *Application) handleMediaRecord(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	name := r.PathValue("name")
+	if err := validateRelativePath("name", name); err != nil {
+		return err
+	}
+	mediaFolder := app.configProvider.GetConfig().Server.MediaFolder
+	info, err := app.fileSystem.Stat(filepath.Join(mediaFolder, name))
+	if err != nil || info.IsDir() {
+		logger.Warn("handleMediaRecord: Media file not found", zap.String("name", name))
+		return NewValidationError("name", "Media file not found", nil)
+	}
+	// The metadata shares the sidecar with the crop, a change of either must not overwrite the other
+	if r.Method != http.MethodGet {
+		app.sidecarMu.Lock()
+		defer app.sidecarMu.Unlock()
+	}
+	sidecar, err := readMediaSidecar(app.fileSystem, mediaFolder, name)
+	if err != nil {
+		return err
+	}
+	sidecarPath := mediaSidecarPath(mediaFolder, name)
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPut:
+		var update struct {
+			Alt       *string `json:"alt"`
+			Caption   *string `json:"caption"`
+			Author    *string `json:"author"`
+			AuthorURL *string `json:"authorUrl"`
+			Origin    *string `json:"origin"`
+			License   *string `json:"license"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			logger.Error("handleMediaRecord: Invalid request body", zap.Error(err))
+			return NewValidationError("request_body", "Invalid request body", err)
+		}
+		metadata := sidecar.MediaMetadata
+		for _, field := range []struct {
+			value *string
+			dest  *string
+		}{
+			{update.Alt, &metadata.Alt},
+			{update.Caption, &metadata.Caption},
+			{update.Author, &metadata.Author},
+			{update.AuthorURL, &metadata.AuthorURL},
+			{update.Origin, &metadata.Origin},
+			{update.License, &metadata.License},
+		} {
+			if field.value != nil {
+				*field.dest = *field.value
+			}
+		}
+		if err := validateMediaMetadata(&metadata); err != nil {
+			return err
+		}
+
+		hashBefore := app.fileHash(sidecarPath)
+		sidecar.MediaMetadata = metadata
+		if err := writeMediaSidecar(app.fileSystem, mediaFolder, name, sidecar); err != nil {
+			return err
+		}
+		app.recordAudit(r, AuditMediaMeta, sidecarPath, hashBefore, app.fileHash(sidecarPath), map[string]string{"media": name})
+		logger.Info("handleMediaRecord: Metadata saved", zap.String("name", name))
+
+	case http.MethodDelete:
+		hashBefore := app.fileHash(sidecarPath)
+		if hashBefore == "" || (sidecar.Crop != nil && sidecar.MediaMetadata == (MediaMetadata{})) {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		// The crop stays, the images derived from the file are cropped with it
+		if sidecar.Crop != nil {
+			sidecar.MediaMetadata = MediaMetadata{}
+			if err := writeMediaSidecar(app.fileSystem, mediaFolder, name, sidecar); err != nil {
+				return err
+			}
+		} else if err := app.fileSystem.Remove(sidecarPath); err != nil {
+			return err
+		}
+		app.recordAudit(r, AuditMediaMeta, sidecarPath, hashBefore, app.fileHash(sidecarPath), map[string]string{"media": name})
+		logger.Info("handleMediaRecord: Metadata removed", zap.String("name", name))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+
+	default:
+		logger.Warn("handleMediaRecord: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(app.mediaRecord(mediaFolder, info, sidecar))
+}
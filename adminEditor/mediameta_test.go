@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"go.uber.org/zap"
+)
+
+// newTestMediaApp returns an application with a media folder holding photo.png
+func newTestMediaApp(t *testing.T) *Application {
+	t.Helper()
+	var config Config
+	config.Server.MediaFolder = filepath.Join(t.TempDir(), "media")
+	if err := os.MkdirAll(config.Server.MediaFolder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := imaging.Save(halvesImage(20, 10), filepath.Join(config.Server.MediaFolder, "photo.png")); err != nil {
+		t.Fatal(err)
+	}
+	logger := &Logger{Logger: zap.NewNop()}
+	return &Application{
+		configProvider: NewAppConfig(&config, nil, logger),
+		fileSystem:     NewOSFileSystem(logger),
+		logger:         logger,
+	}
+}
+
+// changeMediaRecord sends a PUT or DELETE to handleMediaRecord for photo.png
+func changeMediaRecord(app *Application, method, body string) error {
+	r := httptest.NewRequest(method, "/api/media/photo.png", strings.NewReader(body))
+	r.SetPathValue("name", "photo.png")
+	return app.handleMediaRecord(httptest.NewRecorder(), r)
+}
+
+func TestMediaRecordDeleteKeepsCrop(t *testing.T) {
+	app := newTestMediaApp(t)
+	mediaFolder := app.configProvider.GetConfig().Server.MediaFolder
+	if err := changeMediaRecord(app, http.MethodPut, `{"alt": "A photo"}`); err != nil {
+		t.Fatalf("PUT error = %v", err)
+	}
+	if _, _, _, err := app.setMediaCrop(mediaFolder, "photo.png", &MediaCrop{Focus: &FocalPoint{X: 0.2, Y: 0.5}}); err != nil {
+		t.Fatalf("setMediaCrop() error = %v", err)
+	}
+	if err := changeMediaRecord(app, http.MethodDelete, ""); err != nil {
+		t.Fatalf("DELETE error = %v", err)
+	}
+
+	sidecar, err := readMediaSidecar(app.fileSystem, mediaFolder, "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.Alt != "" || sidecar.Crop == nil || sidecar.Crop.Focus.X != 0.2 {
+		t.Errorf("sidecar after DELETE = %+v, want the crop without metadata", sidecar)
+	}
+
+	// Without a crop, the sidecar is removed
+	if _, _, _, err := app.setMediaCrop(mediaFolder, "photo.png", &MediaCrop{}); err != nil {
+		t.Fatalf("setMediaCrop() error = %v", err)
+	}
+	if err := changeMediaRecord(app, http.MethodPut, `{"alt": "A photo"}`); err != nil {
+		t.Fatalf("PUT error = %v", err)
+	}
+	if err := changeMediaRecord(app, http.MethodDelete, ""); err != nil {
+		t.Fatalf("DELETE error = %v", err)
+	}
+	if _, err := os.Stat(mediaSidecarPath(mediaFolder, "photo.png")); !os.IsNotExist(err) {
+		t.Errorf("Stat(sidecar) error = %v, want the sidecar removed", err)
+	}
+}
+
+// slowSidecarFileSystem delays reading sidecars, so changes that run at the same time read the same version
+type slowSidecarFileSystem struct {
+	FileSystem
+}
+
+func (fs slowSidecarFileSystem) ReadFile(filename string) ([]byte, error) {
+	if strings.Contains(filename, mediaMetaFolder) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return fs.FileSystem.ReadFile(filename)
+}
+
+func TestMediaSidecarChangesDoNotOverwriteEachOther(t *testing.T) {
+	app := newTestMediaApp(t)
+	app.fileSystem = slowSidecarFileSystem{app.fileSystem}
+	mediaFolder := app.configProvider.GetConfig().Server.MediaFolder
+
+	// A crop and metadata are changed at the same time, each change must keep the other
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _, _, errs[0] = app.setMediaCrop(mediaFolder, "photo.png", &MediaCrop{Focus: &FocalPoint{X: 0.2, Y: 0.5}})
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = changeMediaRecord(app, http.MethodPut, `{"caption": "A caption"}`)
+	}()
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("change error = %v", err)
+		}
+	}
+
+	sidecar, err := readMediaSidecar(app.fileSystem, mediaFolder, "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.Crop == nil || sidecar.Caption != "A caption" {
+		t.Errorf("sidecar = %+v, want both the crop and the caption", sidecar)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"strings"
+)
+
+// Conflict markers written around edits that could not be merged automatically
+const (
+	mergeMarkerOurs   = "<<<<<<< yours"
+	mergeMarkerBase   = "||||||| original"
+	mergeMarkerSplit  = "======="
+	mergeMarkerTheirs = ">>>>>>> server"
+)
+
+// MergeResult is the outcome of a three-way merge
+type MergeResult struct {
+	Content   string `json:"content"`
+	Conflicts int    `json:"conflicts"`
+	Clean     bool   `json:"clean"`
+}
+
+// mergeThreeWay merges two edited versions of a text with their common ancestor.
+// Edits to different lines are combined, overlapping edits are kept side by side between conflict markers.
+func mergeThreeWay(base, ours, theirs string) MergeResult {
+	baseLines := splitLines(base)
+	ourLines := splitLines(ours)
+	theirLines := splitLines(theirs)
+
+	toOurs := matchLines(baseLines, ourLines)
+	toTheirs := matchLines(baseLines, theirLines)
+
+	var merged strings.Builder
+	result := MergeResult{}
+
+	baseAt, oursAt, theirsAt := 0, 0, 0
+	for {
+		// Find the next base line that is unchanged in both versions
+		next := -1
+		for i := baseAt; i < len(baseLines); i++ {
+			if toOurs[i] >= oursAt && toTheirs[i] >= theirsAt {
+				next = i
+				break
+			}
+		}
+
+		if next == baseAt && toOurs[next] == oursAt && toTheirs[next] == theirsAt {
+			merged.WriteString(baseLines[baseAt])
+			baseAt, oursAt, theirsAt = baseAt+1, oursAt+1, theirsAt+1
+			continue
+		}
+
+		baseEnd, oursEnd, theirsEnd := len(baseLines), len(ourLines), len(theirLines)
+		if next != -1 {
+			baseEnd, oursEnd, theirsEnd = next, toOurs[next], toTheirs[next]
+		}
+		chunkBase := baseLines[baseAt:baseEnd]
+		chunkOurs := ourLines[oursAt:oursEnd]
+		chunkTheirs := theirLines[theirsAt:theirsEnd]
+
+		switch {
+		case equalLines(chunkOurs, chunkBase):
+			writeLines(&merged, chunkTheirs)
+		case equalLines(chunkTheirs, chunkBase), equalLines(chunkOurs, chunkTheirs):
+			writeLines(&merged, chunkOurs)
+		default:
+			result.Conflicts++
+			writeConflict(&merged, chunkBase, chunkOurs, chunkTheirs)
+		}
+
+		if next == -1 {
+			break
+		}
+		baseAt, oursAt, theirsAt = baseEnd, oursEnd, theirsEnd
+	}
+
+	result.Content = merged.String()
+	result.Clean = result.Conflicts == 0
+	return result
+}
+
+// matchLines returns, for every line of a, the index of the matching line in b or -1.
+// Matches follow a shortest edit script computed with Myers' O(ND) algorithm.
+func matchLines(a, b []string) []int {
+	matches := make([]int, len(a))
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	n, m := len(a), len(b)
+	total := n + m
+	if total == 0 {
+		return matches
+	}
+	offset := total + 1
+	v := make([]int, 2*total+3)
+	// trace[d] holds the diagonals -d-1..d+1 of v as they were before round d
+	var trace [][]int
+
+	// Forward pass, recording the furthest reaching path of every diagonal for each edit distance
+search:
+	for d := 0; d <= total; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Backtrack through the recorded paths and collect the diagonal moves, which are matching lines
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0 && (x > 0 || y > 0); d-- {
+		snapshot := trace[d]
+		at := func(k int) int { return snapshot[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		if d == 0 {
+			prevX, prevY = 0, 0
+		}
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			matches[x] = y
+		}
+		x, y = prevX, prevY
+	}
+	return matches
+}
+
+// splitLines splits text into lines that keep their line endings, so joining them restores the text
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(sb *strings.Builder, lines []string) {
+	for _, line := range lines {
+		sb.WriteString(line)
+	}
+}
+
+// writeConflict writes both sides of an overlapping edit between diff3 style conflict markers
+func writeConflict(sb *strings.Builder, base, ours, theirs []string) {
+	section := func(marker string, lines []string) {
+		sb.WriteString(marker)
+		sb.WriteString("\n")
+		writeLines(sb, lines)
+		if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	section(mergeMarkerOurs, ours)
+	section(mergeMarkerBase, base)
+	section(mergeMarkerSplit, theirs)
+	sb.WriteString(mergeMarkerTheirs)
+	sb.WriteString("\n")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestMergeThreeWay(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts int
+	}{
+		{
+			name:   "non-overlapping edits",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nb\nc\nD\ne\n",
+			want:   "a\nB\nc\nD\ne\n",
+		},
+		{
+			name:   "insertions at both ends",
+			base:   "a\nb\n",
+			ours:   "first\na\nb\n",
+			theirs: "a\nb\nlast\n",
+			want:   "first\na\nb\nlast\n",
+		},
+		{
+			name:   "deletion and edit elsewhere",
+			base:   "a\nb\nc\nd\n",
+			ours:   "a\nc\nd\n",
+			theirs: "a\nb\nc\nD\n",
+			want:   "a\nc\nD\n",
+		},
+		{
+			name:   "identical edits on both sides",
+			base:   "a\nb\nc\n",
+			ours:   "a\nX\nc\n",
+			theirs: "a\nX\nc\n",
+			want:   "a\nX\nc\n",
+		},
+		{
+			name:   "only one side changed",
+			base:   "a\nb\n",
+			ours:   "a\nb\n",
+			theirs: "a\nB\n",
+			want:   "a\nB\n",
+		},
+		{
+			name:      "overlapping edits",
+			base:      "a\nb\nc\n",
+			ours:      "a\nours\nc\n",
+			theirs:    "a\ntheirs\nc\n",
+			want:      "a\n<<<<<<< yours\nours\n||||||| original\nb\n=======\ntheirs\n>>>>>>> server\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:      "adjacent edits",
+			base:      "a\nb\nc\nd\n",
+			ours:      "a\nB\nc\nd\n",
+			theirs:    "a\nb\nC\nd\n",
+			want:      "a\n<<<<<<< yours\nB\nc\n||||||| original\nb\nc\n=======\nb\nC\n>>>>>>> server\nd\n",
+			conflicts: 1,
+		},
+		{
+			name:      "edit against deletion",
+			base:      "a\nb\nc\n",
+			ours:      "a\nB\nc\n",
+			theirs:    "a\nc\n",
+			want:      "a\n<<<<<<< yours\nB\n||||||| original\nb\n=======\n>>>>>>> server\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:      "two separate conflicts",
+			base:      "a\nb\nc\nd\ne\n",
+			ours:      "a\n1\nc\n2\ne\n",
+			theirs:    "a\n3\nc\n4\ne\n",
+			want:      "a\n<<<<<<< yours\n1\n||||||| original\nb\n=======\n3\n>>>>>>> server\nc\n<<<<<<< yours\n2\n||||||| original\nd\n=======\n4\n>>>>>>> server\ne\n",
+			conflicts: 2,
+		},
+		{
+			name:   "missing trailing newline is kept",
+			base:   "a\nb\nc",
+			ours:   "A\nb\nc",
+			theirs: "a\nb\nc\nd",
+			want:   "A\nb\nc\nd",
+		},
+		{
+			name:      "conflict without trailing newline",
+			base:      "a\nb",
+			ours:      "a\nx",
+			theirs:    "a\ny",
+			want:      "a\n<<<<<<< yours\nx\n||||||| original\nb\n=======\ny\n>>>>>>> server\n",
+			conflicts: 1,
+		},
+		{
+			name:   "empty base with the same text",
+			base:   "",
+			ours:   "x\n",
+			theirs: "x\n",
+			want:   "x\n",
+		},
+		{
+			name:   "empty base and one side empty",
+			base:   "",
+			ours:   "x\ny\n",
+			theirs: "",
+			want:   "x\ny\n",
+		},
+		{
+			name:      "empty base with different texts",
+			base:      "",
+			ours:      "x\n",
+			theirs:    "y\n",
+			want:      "<<<<<<< yours\nx\n||||||| original\n=======\ny\n>>>>>>> server\n",
+			conflicts: 1,
+		},
+		{
+			name: "all empty",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := mergeThreeWay(tt.base, tt.ours, tt.theirs)
+			if result.Content != tt.want {
+				t.Errorf("mergeThreeWay() content =\n%q\nwant\n%q", result.Content, tt.want)
+			}
+			if result.Conflicts != tt.conflicts {
+				t.Errorf("mergeThreeWay() conflicts = %d, want %d", result.Conflicts, tt.conflicts)
+			}
+			if result.Clean != (tt.conflicts == 0) {
+				t.Errorf("mergeThreeWay() clean = %v with %d conflicts", result.Clean, result.Conflicts)
+			}
+		})
+	}
+}
+
+func TestMatchLinesFindsLongestCommonSubsequence(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+		matches := matchLines(a, b)
+		if len(matches) != len(a) {
+			t.Fatalf("matchLines(%v, %v) returned %d matches, want %d", a, b, len(matches), len(a))
+		}
+
+		matched, last := 0, -1
+		for x, y := range matches {
+			if y == -1 {
+				continue
+			}
+			if y <= last || y >= len(b) || a[x] != b[y] {
+				t.Fatalf("matchLines(%v, %v) = %v is not a common subsequence", a, b, matches)
+			}
+			matched, last = matched+1, y
+		}
+		if want := lcsLength(a, b); matched != want {
+			t.Fatalf("matchLines(%v, %v) matched %d lines, want %d", a, b, matched, want)
+		}
+	}
+}
+
+func TestSplitLinesRestoresText(t *testing.T) {
+	for _, text := range []string{"", "a", "a\n", "a\nb", "a\r\nb\r\n", "\n\n"} {
+		if got := strings.Join(splitLines(text), ""); got != text {
+			t.Errorf("splitLines(%q) joined = %q", text, got)
+		}
+	}
+}
+
+// lcsLength computes the length of the longest common subsequence by dynamic programming
+func lcsLength(a, b []string) int {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else {
+				table[i][j] = max(table[i+1][j], table[i][j+1])
+			}
+		}
+	}
+	return table[0][0]
+}
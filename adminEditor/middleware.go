@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"go.uber.org/zap"
@@ -96,10 +97,39 @@ func HandleError(w http.ResponseWriter, r *http.Request, err error) {
 		statusCode = http.StatusInternalServerError
 	}
 
+	// A conflict carries the server copy, so the client can merge instead of starting over
+	var conflictErr *ConflictError
+	if errors.As(err, &conflictErr) {
+		sendConflictResponse(w, r, conflictErr)
+		return
+	}
+
 	// Send the error response
 	sendErrorResponse(w, r, statusCode, err.Error(), "")
 }
 
+// sendConflictResponse sends a 409 response including the current server copy
+func sendConflictResponse(w http.ResponseWriter, r *http.Request, err *ConflictError) {
+	w.Header().Set("Content-Type", "application/json")
+	if err.ServerETag != "" {
+		w.Header().Set("ETag", err.ServerETag)
+	}
+	w.WriteHeader(http.StatusConflict)
+
+	errorResponse := ErrorResponse{
+		Error: err.Error(),
+		Code:  http.StatusConflict,
+		Server: &ServerRevision{
+			ETag:    err.ServerETag,
+			Content: err.ServerContent,
+		},
+	}
+	if encodeErr := json.NewEncoder(w).Encode(errorResponse); encodeErr != nil {
+		logger := GetLoggerFromContext(r.Context())
+		logger.LogError(r, encodeErr, "Failed to encode conflict response")
+	}
+}
+
 // sendErrorResponse sends a standardized error response
 func sendErrorResponse(w http.ResponseWriter, r *http.Request, statusCode int, message, details string) {
 	// Set the content type
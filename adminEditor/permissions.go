@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Role is the set of permissions granted to a user
+type Role string
+
+// Roles of the editor, from least to most privileged
+const (
+	RoleAuthor   Role = "author"
+	RoleReviewer Role = "reviewer"
+	RoleAdmin    Role = "admin"
+)
+
+// Permission allows a group of actions
+type Permission string
+
+const (
+	// PermPostsRead allows reading posts, media lists, tags and history
+	PermPostsRead Permission = "posts:read"
+	// PermPostsWrite allows creating posts and editing one's own drafts
+	PermPostsWrite Permission = "posts:write"
+	// PermPostsEditAny allows editing posts of other users and published posts
+	PermPostsEditAny Permission = "posts:edit-any"
+	// PermPostsPublish allows publishing a post, by setting "draft: false"
+	PermPostsPublish Permission = "posts:publish"
+	// PermMediaWrite allows uploading and processing media
+	PermMediaWrite Permission = "media:write"
+	// PermMediaDelete allows deleting media
+	PermMediaDelete Permission = "media:delete"
+	// PermConfigWrite allows changing the configuration
+	PermConfigWrite Permission = "config:write"
+	// PermTaxonomyWrite allows regenerating the tag and category data files
+	PermTaxonomyWrite Permission = "taxonomy:write"
+	// PermAuditRead allows reading the audit log
+	PermAuditRead Permission = "audit:read"
+)
+
+// postOwnerKey is the front matter key that records which user created a post.
+// It is not the "author" key, which Hinode shows as the byline of a post.
+const postOwnerKey = "owner"
+
+// rolePermissions lists the permissions of each role
+var rolePermissions = map[Role][]Permission{
+	RoleAuthor: {
+		PermPostsRead, PermPostsWrite, PermMediaWrite,
+	},
+	RoleReviewer: {
+		PermPostsRead, PermPostsWrite, PermPostsEditAny, PermPostsPublish, PermMediaWrite,
+	},
+	RoleAdmin: {
+		PermPostsRead, PermPostsWrite, PermPostsEditAny, PermPostsPublish, PermMediaWrite,
+		PermMediaDelete, PermConfigWrite, PermTaxonomyWrite, PermAuditRead,
+	},
+}
+
+// languageScopedPermissions are limited to the languages of a user, reading stays possible for all languages
+var languageScopedPermissions = map[Permission]bool{
+	PermPostsWrite:   true,
+	PermPostsEditAny: true,
+	PermPostsPublish: true,
+}
+
+// Valid reports whether a role is known
+func (role Role) Valid() bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can reports whether a user has a permission.
+// A request made with an API token is also limited to the scopes of the token.
+func (u *User) Can(permission Permission) bool {
+	if u.Scopes != nil && !containsPermission(u.Scopes, permission) {
+		return false
+	}
+	return containsPermission(rolePermissions[u.Role], permission)
+}
+
+func containsPermission(permissions []Permission, permission Permission) bool {
+	for _, granted := range permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessLanguage reports whether a user may change content of a language.
+// A user without languages may change all of them.
+func (u *User) CanAccessLanguage(lang string) bool {
+	if len(u.Languages) == 0 {
+		return true
+	}
+	for _, allowed := range u.Languages {
+		if strings.EqualFold(allowed, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions lists the permissions of a user
+func (u *User) Permissions() []Permission {
+	permissions := []Permission{}
+	for _, permission := range rolePermissions[u.Role] {
+		if u.Can(permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// authorize checks a permission for the user of a request, and the language when the permission is language scoped.
+// A user limited to languages needs a language for those permissions, an empty one is denied.
+// Without authentication every request is allowed.
+func (app *Application) authorize(r *http.Request, permission Permission, lang string) error {
+	user, err := app.authorizeRole(r, permission)
+	if err != nil || user == nil {
+		return err
+	}
+	if languageScopedPermissions[permission] && len(user.Languages) > 0 {
+		if lang == "" {
+			return NewForbiddenError(string(permission), "Users limited to languages can only change content of a known language")
+		}
+		if !user.CanAccessLanguage(lang) {
+			return NewForbiddenError(string(permission), fmt.Sprintf("Not allowed to change content in language '%s'", lang))
+		}
+	}
+	return nil
+}
+
+// authorizeRole checks a permission for the user of a request without looking at languages.
+// It returns the user, which is nil without authentication.
+func (app *Application) authorizeRole(r *http.Request, permission Permission) (*User, error) {
+	if !app.auth.Enabled() {
+		return nil, nil
+	}
+	user, ok := UserFromContext(r.Context())
+	if !ok {
+		return nil, NewAuthError("Authentication required", nil)
+	}
+	if !user.Can(permission) {
+		if user.TokenID != "" && containsPermission(rolePermissions[user.Role], permission) {
+			return nil, NewForbiddenError(string(permission), "The API token does not have this scope")
+		}
+		return nil, NewForbiddenError(string(permission), fmt.Sprintf("Role '%s' is not allowed to do this", user.Role))
+	}
+	return user, nil
+}
+
+// RequirePermission wraps a route so it is only reached by users with a permission.
+// The language is taken from a {lang} path value, a lang query parameter or the first segment of a file parameter.
+// Routes that carry it in the body only check the role here, their handler checks the language.
+func (app *Application) RequirePermission(permission Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if lang := requestLanguage(r); lang != "" {
+			err = app.authorize(r, permission, lang)
+		} else {
+			_, err = app.authorizeRole(r, permission)
+		}
+		if err != nil {
+			GetLoggerFromContext(r.Context()).Warn("RequirePermission: Request denied",
+				zap.String("permission", string(permission)),
+				zap.Error(err),
+			)
+			HandleError(w, r, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestLanguage returns the content language a request refers to, if any
+func requestLanguage(r *http.Request) string {
+	if lang := r.PathValue("lang"); lang != "" {
+		return lang
+	}
+	query := r.URL.Query()
+	if lang := query.Get("lang"); lang != "" {
+		return lang
+	}
+	return fileLanguage(query.Get("file"))
+}
+
+// fileLanguage returns the language of a "lang/section/file" name
+func fileLanguage(filename string) string {
+	lang, _, _ := strings.Cut(strings.ReplaceAll(filename, `\`, "/"), "/")
+	return lang
+}
+
+// authorizePostChange checks that the user of a request may replace a post with new content.
+// Authors may only change their own drafts, and only reviewers and admins may publish.
+// existing is nil for a new post.
+func (app *Application) authorizePostChange(r *http.Request, lang string, existing, updated []byte) error {
+	if err := app.authorize(r, PermPostsWrite, lang); err != nil {
+		return err
+	}
+	user, ok := UserFromContext(r.Context())
+	if !app.auth.Enabled() || !ok {
+		return nil
+	}
+
+	wasDraft := true
+	if existing != nil {
+		previous, err := ParsePost(existing)
+		if err != nil {
+			return NewValidationError("front_matter", "Could not parse front matter of the current version", err)
+		}
+		wasDraft = previous.Draft
+		if !user.Can(PermPostsEditAny) {
+			if !previous.Draft {
+				return NewForbiddenError(string(PermPostsEditAny), "Published posts can only be changed by reviewers and admins")
+			}
+			if owner, _ := previous.Params[postOwnerKey].(string); owner != user.Username {
+				return NewForbiddenError(string(PermPostsEditAny), "Drafts of other users can only be changed by reviewers and admins")
+			}
+		}
+	}
+
+	next, err := ParsePost(updated)
+	if err != nil {
+		// The content is validated by the handler, an unparsable post cannot publish anything
+		return nil
+	}
+	if wasDraft && !next.Draft {
+		return app.authorize(r, PermPostsPublish, lang)
+	}
+	return nil
+}
+
+// handleRegenerateTaxonomy rebuilds the tag and category data files from the posts on disk
+func (app *Application) handleRegenerateTaxonomy(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		logger.Warn("handleRegenerateTaxonomy: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	tagCounts := make(map[string]int)
+	categoryCounts := make(map[string]int)
+	for _, path := range app.postIndex.Paths() {
+		summary, ok := app.postIndex.Get(path)
+		if !ok {
+			continue
+		}
+		for _, tag := range summary.Tags {
+			tagCounts[tag]++
+		}
+		for _, category := range summary.Categories {
+			categoryCounts[category]++
+		}
+	}
+
+	tags := make([]Tag, 0, len(tagCounts))
+	for name, count := range tagCounts {
+		tags = append(tags, Tag{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	categories := make([]Category, 0, len(categoryCounts))
+	for name, count := range categoryCounts {
+		categories = append(categories, Category{Name: name, Count: count})
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Count != categories[j].Count {
+			return categories[i].Count > categories[j].Count
+		}
+		return categories[i].Name < categories[j].Name
+	})
+
+	if err := app.fileSystem.MkdirAll("data", 0755); err != nil {
+		return err
+	}
+	tagsBefore, categoriesBefore := app.fileHash("data/tags.json"), app.fileHash("data/categories.json")
+	tagsJSON, err := json.MarshalIndent(TagsData{Tags: tags}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := app.fileSystem.WriteFile("data/tags.json", tagsJSON, 0644); err != nil {
+		return err
+	}
+	categoriesJSON, err := json.MarshalIndent(CategoriesData{Categories: categories}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := app.fileSystem.WriteFile("data/categories.json", categoriesJSON, 0644); err != nil {
+		return err
+	}
+	app.recordAudit(r, AuditTaxonomyRegenerate, "data/tags.json", tagsBefore, contentHash(tagsJSON), nil)
+	app.recordAudit(r, AuditTaxonomyRegenerate, "data/categories.json", categoriesBefore, contentHash(categoriesJSON), nil)
+
+	logger.Info("handleRegenerateTaxonomy: Regenerated taxonomy data files",
+		zap.Int("tags", len(tags)),
+		zap.Int("categories", len(categories)),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]int{
+		"tags":       len(tags),
+		"categories": len(categories),
+	})
+}
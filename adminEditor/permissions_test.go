@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestAuthApp returns an application with authentication enabled and an empty user store
+func newTestAuthApp(t *testing.T) *Application {
+	t.Helper()
+	var config Config
+	config.Auth.Enabled = true
+	config.Auth.CookieName = "session"
+	logger := &Logger{Logger: zap.NewNop()}
+	provider := NewAppConfig(&config, nil, logger)
+	return &Application{
+		configProvider: provider,
+		auth: &Authenticator{
+			configProvider: provider,
+			users:          &FileUserStore{logger: logger, users: map[string]User{}},
+			sessions:       NewSessionStore(time.Hour),
+			logger:         logger,
+		},
+		logger: logger,
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	author := &User{Username: "alice", Role: RoleAuthor}
+	translator := &User{Username: "jonas", Role: RoleReviewer, Languages: []string{"de"}}
+	admin := &User{Username: "root", Role: RoleAdmin}
+
+	tests := []struct {
+		name       string
+		user       *User
+		permission Permission
+		lang       string
+		want       error
+	}{
+		{name: "anonymous", permission: PermPostsRead, want: &AuthError{}},
+		{name: "author reads", user: author, permission: PermPostsRead},
+		{name: "author writes", user: author, permission: PermPostsWrite, lang: "en"},
+		{name: "author publishes", user: author, permission: PermPostsPublish, lang: "en", want: &ForbiddenError{}},
+		{name: "author changes config", user: author, permission: PermConfigWrite, want: &ForbiddenError{}},
+		{name: "author deletes media", user: author, permission: PermMediaDelete, want: &ForbiddenError{}},
+		{name: "author writes without language", user: author, permission: PermPostsWrite},
+		{name: "translator writes own language", user: translator, permission: PermPostsWrite, lang: "de"},
+		{name: "translator language ignores case", user: translator, permission: PermPostsPublish, lang: "DE"},
+		{name: "translator writes other language", user: translator, permission: PermPostsWrite, lang: "en", want: &ForbiddenError{}},
+		{name: "translator writes without language", user: translator, permission: PermPostsWrite, want: &ForbiddenError{}},
+		{name: "translator publishes without language", user: translator, permission: PermPostsPublish, want: &ForbiddenError{}},
+		{name: "translator reads other language", user: translator, permission: PermPostsRead, lang: "en"},
+		{name: "translator uploads media", user: translator, permission: PermMediaWrite},
+		{name: "admin publishes any language", user: admin, permission: PermPostsPublish, lang: "en"},
+		{name: "admin reads the audit log", user: admin, permission: PermAuditRead},
+	}
+
+	app := newTestAuthApp(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.user != nil {
+				r = r.WithContext(WithUser(r.Context(), tt.user))
+			}
+			err := app.authorize(r, tt.permission, tt.lang)
+			if !sameErrorType(err, tt.want) {
+				t.Errorf("authorize(%s, %q) error = %v, want %T", tt.permission, tt.lang, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeWithoutAuthentication(t *testing.T) {
+	app := newTestAuthApp(t)
+	app.auth.users = nil
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := app.authorize(r, PermConfigWrite, ""); err != nil {
+		t.Errorf("authorize() error = %v, want every request allowed", err)
+	}
+}
+
+func TestRequirePermissionLeavesBodyLanguageToHandler(t *testing.T) {
+	app := newTestAuthApp(t)
+	translator := &User{Username: "jonas", Role: RoleReviewer, Languages: []string{"de"}}
+	handler := app.RequirePermission(PermPostsWrite, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{target: "/api/save", want: http.StatusNoContent},
+		{target: "/api/save?file=de/blog/post.md", want: http.StatusNoContent},
+		{target: "/api/save?file=en/blog/post.md", want: http.StatusForbidden},
+		{target: "/api/save?lang=en", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", tt.target, nil)
+		r = r.WithContext(WithUser(r.Context(), translator))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tt.want {
+			t.Errorf("POST %s status = %d, want %d", tt.target, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAuthorizePostChange(t *testing.T) {
+	author := &User{Username: "alice", Role: RoleAuthor}
+	reviewer := &User{Username: "rita", Role: RoleReviewer}
+	post := func(owner string, draft bool) []byte {
+		return []byte(fmt.Sprintf("---\ntitle: Post\nowner: %s\ndraft: %t\n---\nBody\n", owner, draft))
+	}
+
+	tests := []struct {
+		name     string
+		user     *User
+		existing []byte
+		updated  []byte
+		want     error
+	}{
+		{name: "author creates a draft", user: author, updated: post("alice", true)},
+		{name: "author creates a published post", user: author, updated: post("alice", false), want: &ForbiddenError{}},
+		{name: "author changes own draft", user: author, existing: post("alice", true), updated: post("alice", true)},
+		{name: "author publishes own draft", user: author, existing: post("alice", true), updated: post("alice", false), want: &ForbiddenError{}},
+		{name: "author changes draft of another user", user: author, existing: post("bob", true), updated: post("bob", true), want: &ForbiddenError{}},
+		{name: "author changes a published post", user: author, existing: post("alice", false), updated: post("alice", false), want: &ForbiddenError{}},
+		{name: "reviewer changes draft of another user", user: reviewer, existing: post("alice", true), updated: post("alice", true)},
+		{name: "reviewer publishes", user: reviewer, existing: post("alice", true), updated: post("alice", false)},
+		{name: "reviewer changes a published post", user: reviewer, existing: post("alice", false), updated: post("alice", false)},
+	}
+
+	app := newTestAuthApp(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/save", nil)
+			r = r.WithContext(WithUser(r.Context(), tt.user))
+			err := app.authorizePostChange(r, "en", tt.existing, tt.updated)
+			if !sameErrorType(err, tt.want) {
+				t.Errorf("authorizePostChange() error = %v, want %T", err, tt.want)
+			}
+		})
+	}
+}
+
+// sameErrorType reports whether err is nil exactly when want is, and otherwise of the same type
+func sameErrorType(err, want error) bool {
+	switch want.(type) {
+	case nil:
+		return err == nil
+	case *AuthError:
+		var target *AuthError
+		return errors.As(err, &target)
+	case *ForbiddenError:
+		var target *ForbiddenError
+		return errors.As(err, &target)
+	}
+	return false
+}
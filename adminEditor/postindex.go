@@ -0,0 +1,585 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultPostPageSize is the number of posts returned when no limit is requested
+	defaultPostPageSize = 50
+	// maxPostPageSize caps the limit a client can request
+	maxPostPageSize = 500
+)
+
+// PostSummary is the indexed view of a single post
+type PostSummary struct {
+	Path        string    `json:"path"`
+	Language    string    `json:"lang"`
+	Section     string    `json:"section"`
+	File        string    `json:"file"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Date        string    `json:"date,omitempty"`
+	Draft       bool      `json:"draft"`
+	Tags        []string  `json:"tags"`
+	Categories  []string  `json:"categories"`
+	WordCount   int       `json:"wordCount"`
+	ModTime     time.Time `json:"modTime"`
+
+	published time.Time
+	text      string
+}
+
+// PostQuery holds the filters, sorting and pagination of a post listing
+type PostQuery struct {
+	Language string
+	Section  string
+	Tag      string
+	Category string
+	Draft    *bool
+	From     time.Time
+	To       time.Time
+	Q        string
+	Sort     string
+	Desc     bool
+	Limit    int
+	Cursor   string
+}
+
+// PostPage is one page of a post listing
+type PostPage struct {
+	Items      []PostSummary `json:"items"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+	Total      int           `json:"total"`
+}
+
+// postCursor marks the last post of a page by its sort key and path
+type postCursor struct {
+	Key  string `json:"k"`
+	Path string `json:"p"`
+}
+
+// PostIndex keeps the front matter of all posts in memory and follows changes on disk
+type PostIndex struct {
+	configProvider ConfigProvider
+	fileSystem     FileSystem
+	logger         *Logger
+
+	mu          sync.RWMutex
+	posts       map[string]*PostSummary
+	watcher     *fsnotify.Watcher
+	subscribers []func(PostIndexEvent)
+}
+
+// PostIndexEvent tells subscribers that a post was added, changed or removed
+type PostIndexEvent struct {
+	Path     string
+	FullPath string
+	Removed  bool
+}
+
+// NewPostIndex creates an empty post index, call Build to fill it
+func NewPostIndex(configProvider ConfigProvider, fileSystem FileSystem, logger *Logger) *PostIndex {
+	return &PostIndex{
+		configProvider: configProvider,
+		fileSystem:     fileSystem,
+		logger:         logger,
+		posts:          make(map[string]*PostSummary),
+	}
+}
+
+// Build indexes every Markdown file in all configured language and section folders
+func (idx *PostIndex) Build() error {
+	config := idx.configProvider.GetConfig()
+	posts := make(map[string]*PostSummary)
+
+	for _, lang := range config.LanguageCodes() {
+		for _, section := range config.Sections {
+			folder, err := sectionFolder(lang, section.Name, config)
+			if err != nil {
+				return err
+			}
+			files, err := listFiles(folder, idx.fileSystem)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				summary, err := idx.summarize(filepath.Join(folder, file), lang, section.Name, filepath.ToSlash(file))
+				if err != nil {
+					idx.logger.Warn("PostIndex.Build: Skipping unreadable post", zap.String("file", file), zap.Error(err))
+					continue
+				}
+				posts[summary.Path] = summary
+			}
+		}
+	}
+
+	idx.mu.Lock()
+	var removed []string
+	for path := range idx.posts {
+		if _, ok := posts[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	idx.posts = posts
+	idx.mu.Unlock()
+
+	// Posts of a language or section that is no longer configured are gone for subscribers as well
+	for _, path := range removed {
+		idx.notify(PostIndexEvent{Path: path, Removed: true})
+	}
+
+	idx.logger.Info("PostIndex.Build: Indexed posts", zap.Int("count", len(posts)))
+	return nil
+}
+
+// Reload rebuilds the index after the configured languages or sections changed,
+// and follows the new content folders when the index was watching
+func (idx *PostIndex) Reload() error {
+	if err := idx.Build(); err != nil {
+		return err
+	}
+	idx.mu.RLock()
+	watching := idx.watcher != nil
+	idx.mu.RUnlock()
+	if !watching {
+		return nil
+	}
+	if err := idx.Close(); err != nil {
+		idx.logger.Warn("PostIndex.Reload: Could not stop watching the previous folders", zap.Error(err))
+	}
+	return idx.Watch()
+}
+
+// Update re-indexes a single file on disk, removing it from the index if it no longer exists
+func (idx *PostIndex) Update(fullPath string) {
+	lang, section, file, ok := idx.resolve(fullPath)
+	if !ok || !strings.HasSuffix(file, ".md") {
+		return
+	}
+	path := lang + "/" + section + "/" + file
+
+	summary, err := idx.summarize(fullPath, lang, section, file)
+	if errors.Is(err, os.ErrNotExist) {
+		idx.remove(path)
+		return
+	}
+	if err != nil {
+		idx.logger.Warn("PostIndex.Update: Could not index post", zap.String("path", fullPath), zap.Error(err))
+		return
+	}
+
+	idx.mu.Lock()
+	idx.posts[path] = summary
+	idx.mu.Unlock()
+
+	idx.notify(PostIndexEvent{Path: path, FullPath: fullPath})
+}
+
+// remove drops a post, or every post below a removed folder, from the index
+func (idx *PostIndex) remove(path string) {
+	var removed []string
+	idx.mu.Lock()
+	prefix := path + "/"
+	for key := range idx.posts {
+		if key == path || strings.HasPrefix(key, prefix) {
+			delete(idx.posts, key)
+			removed = append(removed, key)
+		}
+	}
+	idx.mu.Unlock()
+
+	for _, key := range removed {
+		idx.notify(PostIndexEvent{Path: key, Removed: true})
+	}
+}
+
+// Subscribe registers a function that is called after every change to a single post.
+// Subscribers are called synchronously and must not block.
+func (idx *PostIndex) Subscribe(fn func(PostIndexEvent)) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.subscribers = append(idx.subscribers, fn)
+}
+
+func (idx *PostIndex) notify(event PostIndexEvent) {
+	idx.mu.RLock()
+	subscribers := idx.subscribers
+	idx.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// Paths returns the "<lang>/<section>/<file>" paths of all indexed posts
+func (idx *PostIndex) Paths() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	paths := make([]string, 0, len(idx.posts))
+	for path := range idx.posts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Watch follows the content folders with fsnotify and keeps the index current until Close is called
+func (idx *PostIndex) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to create file watcher: %w", err)
+	}
+
+	config := idx.configProvider.GetConfig()
+	for _, lang := range config.LanguageCodes() {
+		folder, err := languageFolder(lang, config)
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+		if err := idx.watchTree(watcher, folder); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	idx.mu.Lock()
+	idx.watcher = watcher
+	idx.mu.Unlock()
+
+	go idx.watchLoop(watcher)
+	idx.logger.Info("PostIndex.Watch: Watching content folders for changes", zap.Int("folders", len(watcher.WatchList())))
+	return nil
+}
+
+// watchTree adds a folder and all its subfolders to the watcher.
+// fsnotify does not watch recursively, so every folder is registered on its own.
+func (idx *PostIndex) watchTree(watcher *fsnotify.Watcher, root string) error {
+	if _, err := idx.fileSystem.Stat(root); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return idx.fileSystem.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// watchLoop applies file system events to the index
+func (idx *PostIndex) watchLoop(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			idx.handleEvent(watcher, event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			idx.logger.Error("PostIndex.watchLoop: File watcher error", zap.Error(err))
+		}
+	}
+}
+
+// handleEvent updates the index for a single file system event
+func (idx *PostIndex) handleEvent(watcher *fsnotify.Watcher, event fsnotify.Event) {
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		if lang, section, file, ok := idx.resolve(event.Name); ok {
+			idx.remove(lang + "/" + section + "/" + file)
+		}
+		return
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	info, err := idx.fileSystem.Stat(event.Name)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		idx.Update(event.Name)
+		return
+	}
+
+	// A new folder may arrive with content already in it, e.g. when a page bundle is moved in
+	if err := idx.watchTree(watcher, event.Name); err != nil {
+		idx.logger.Warn("PostIndex.handleEvent: Could not watch new folder", zap.String("path", event.Name), zap.Error(err))
+	}
+	files, err := listFiles(event.Name, idx.fileSystem)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		idx.Update(filepath.Join(event.Name, file))
+	}
+}
+
+// Close stops watching the content folders
+func (idx *PostIndex) Close() error {
+	idx.mu.Lock()
+	watcher := idx.watcher
+	idx.watcher = nil
+	idx.mu.Unlock()
+
+	if watcher == nil {
+		return nil
+	}
+	return watcher.Close()
+}
+
+// resolve maps a path on disk to its language, section and section-relative file name
+func (idx *PostIndex) resolve(fullPath string) (string, string, string, bool) {
+	config := idx.configProvider.GetConfig()
+	absPath, err := filepath.Abs(fullPath)
+	if err != nil {
+		return "", "", "", false
+	}
+
+	for _, lang := range config.LanguageCodes() {
+		folder, err := filepath.Abs(config.Languages[lang].ContentFolder)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(folder, absPath)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		parts := strings.SplitN(filepath.ToSlash(rel), "/", 2)
+		if len(parts) != 2 {
+			return "", "", "", false
+		}
+		if _, ok := config.Section(parts[0]); !ok {
+			return "", "", "", false
+		}
+		return lang, parts[0], parts[1], true
+	}
+	return "", "", "", false
+}
+
+// summarize reads and parses a post into its index entry
+func (idx *PostIndex) summarize(fullPath, lang, section, file string) (*PostSummary, error) {
+	info, err := idx.fileSystem.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := idx.fileSystem.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	post, err := ParsePost(content)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &PostSummary{
+		Path:        lang + "/" + section + "/" + file,
+		Language:    lang,
+		Section:     section,
+		File:        file,
+		Title:       post.Title,
+		Description: post.Description,
+		Date:        post.Date,
+		Draft:       post.Draft,
+		Tags:        nonNilStrings(post.Tags),
+		Categories:  nonNilStrings(post.Categories),
+		WordCount:   len(strings.Fields(string(post.Body))),
+		ModTime:     info.ModTime(),
+	}
+	if published, ok := post.PublishedAt(); ok {
+		summary.published = published
+	}
+	summary.text = strings.ToLower(strings.Join([]string{
+		post.Title,
+		post.Description,
+		strings.Join(post.Tags, " "),
+		strings.Join(post.Categories, " "),
+		string(post.Body),
+	}, "\n"))
+	return summary, nil
+}
+
+// Get returns the index entry of a post by its "<lang>/<section>/<file>" path
+func (idx *PostIndex) Get(path string) (PostSummary, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	summary, ok := idx.posts[path]
+	if !ok {
+		return PostSummary{}, false
+	}
+	return *summary, true
+}
+
+// Query filters, sorts and paginates the indexed posts
+func (idx *PostIndex) Query(query PostQuery) (PostPage, error) {
+	var after *postCursor
+	if query.Cursor != "" {
+		cursor, err := decodePostCursor(query.Cursor)
+		if err != nil {
+			return PostPage{}, NewValidationError("cursor", "Invalid cursor", err)
+		}
+		after = cursor
+	}
+
+	sortKey, err := postSortKey(query.Sort)
+	if err != nil {
+		return PostPage{}, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultPostPageSize
+	}
+	if limit > maxPostPageSize {
+		limit = maxPostPageSize
+	}
+
+	terms := strings.Fields(strings.ToLower(query.Q))
+
+	idx.mu.RLock()
+	matches := make([]*PostSummary, 0, len(idx.posts))
+	for _, summary := range idx.posts {
+		if query.matches(summary, terms) {
+			matches = append(matches, summary)
+		}
+	}
+	idx.mu.RUnlock()
+
+	// before orders two posts by sort key, falling back to the path so the order is total
+	before := func(keyA, pathA, keyB, pathB string) bool {
+		if keyA != keyB {
+			return (keyA < keyB) != query.Desc
+		}
+		if pathA == pathB {
+			return false
+		}
+		return (pathA < pathB) != query.Desc
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return before(sortKey(matches[i]), matches[i].Path, sortKey(matches[j]), matches[j].Path)
+	})
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matches), func(i int) bool {
+			return before(after.Key, after.Path, sortKey(matches[i]), matches[i].Path)
+		})
+	}
+
+	page := PostPage{Items: []PostSummary{}, Total: len(matches)}
+	end := start + limit
+	if end > len(matches) {
+		end = len(matches)
+	}
+	for _, summary := range matches[start:end] {
+		page.Items = append(page.Items, *summary)
+	}
+	if end < len(matches) {
+		last := matches[end-1]
+		page.NextCursor = encodePostCursor(postCursor{Key: sortKey(last), Path: last.Path})
+	}
+	return page, nil
+}
+
+// matches reports whether a post passes all filters of the query
+func (query PostQuery) matches(summary *PostSummary, terms []string) bool {
+	if query.Language != "" && summary.Language != query.Language {
+		return false
+	}
+	if query.Section != "" && summary.Section != query.Section {
+		return false
+	}
+	if query.Draft != nil && summary.Draft != *query.Draft {
+		return false
+	}
+	if query.Tag != "" && !containsFold(summary.Tags, query.Tag) {
+		return false
+	}
+	if query.Category != "" && !containsFold(summary.Categories, query.Category) {
+		return false
+	}
+	if !query.From.IsZero() && (summary.published.IsZero() || summary.published.Before(query.From)) {
+		return false
+	}
+	if !query.To.IsZero() && (summary.published.IsZero() || summary.published.After(query.To)) {
+		return false
+	}
+	for _, term := range terms {
+		if !strings.Contains(summary.text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// postSortKey returns a function producing a string key that orders posts by the given field
+func postSortKey(field string) (func(*PostSummary) string, error) {
+	switch field {
+	case "", "date":
+		return func(s *PostSummary) string {
+			if s.published.IsZero() {
+				return ""
+			}
+			return s.published.UTC().Format(time.RFC3339Nano)
+		}, nil
+	case "title":
+		return func(s *PostSummary) string { return strings.ToLower(s.Title) }, nil
+	case "wordCount":
+		return func(s *PostSummary) string { return fmt.Sprintf("%010d", s.WordCount) }, nil
+	default:
+		return nil, NewValidationError("sort", fmt.Sprintf("Unsupported sort field '%s'", field), nil)
+	}
+}
+
+func encodePostCursor(cursor postCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePostCursor(value string) (*postCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor postCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// containsFold reports whether the list contains the value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// nonNilStrings returns an empty slice instead of nil so lists encode as [] in JSON
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// postMetaSuffix is the trailing path segment of the post metadata endpoint
+const postMetaSuffix = "/meta"
+
+// handlePostMeta reads or updates the front matter of a post without touching its body.
+// The route is /api/posts/{lang}/{path...}, where path is "<section>/<file>/meta".
+// GET returns the ETag of the file, which PUT requires as If-Match like a save.
+func (app *Application) handlePostMeta(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	lang := r.PathValue("lang")
+	path := r.PathValue("path")
+	if !strings.HasSuffix(path, postMetaSuffix) {
+		logger.Warn("handlePostMeta: Unsupported post resource", zap.String("path", path))
+		return NewValidationError("path", "Unsupported post resource, expected /meta", nil)
+	}
+	filename := lang + "/" + strings.TrimSuffix(path, postMetaSuffix)
+
+	fullPath, err := getFullPath(filename, app.configProvider.GetConfig())
+	if err != nil {
+		logger.Warn("handlePostMeta: Invalid filename", zap.String("filename", filename), zap.Error(err))
+		return err
+	}
+
+	// An update rewrites the whole file, so reading, checking and writing must not interleave with a save
+	if r.Method == http.MethodPut {
+		app.saveMu.Lock()
+		defer app.saveMu.Unlock()
+	}
+	content, etag, err := app.currentRevision(fullPath)
+	if err != nil {
+		return err
+	}
+	if etag == "" {
+		logger.Warn("handlePostMeta: File not found", zap.String("path", fullPath))
+		return NewFileSystemError("read", fullPath, "File not found", os.ErrNotExist)
+	}
+
+	post, err := ParsePost(content)
+	if err != nil {
+		logger.Warn("handlePostMeta: Could not parse front matter", zap.String("path", fullPath), zap.Error(err))
+		return NewValidationError("front_matter", "Could not parse front matter", err)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		logger.Info("handlePostMeta: Responding with post metadata", zap.String("path", fullPath))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
+		return json.NewEncoder(w).Encode(post)
+
+	case http.MethodPut:
+		// The update is based on the metadata the client read, a save since then must not be lost
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch == "" {
+			logger.Warn("handlePostMeta: If-Match header missing", zap.String("path", fullPath))
+			return NewValidationError("If-Match", "If-Match header with the ETag of the post is required", nil)
+		}
+		if !etagMatches(ifMatch, etag) {
+			logger.Warn("handlePostMeta: File changed since it was loaded",
+				zap.String("path", fullPath),
+				zap.String("ifMatch", ifMatch),
+				zap.String("etag", etag),
+			)
+			return NewConflictError(filename, "File was changed since it was loaded", etag, string(content))
+		}
+
+		var meta PostMeta
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			logger.Error("handlePostMeta: Invalid request body", zap.Error(err))
+			return NewValidationError("request_body", "Invalid request body", err)
+		}
+		if err := meta.Validate(); err != nil {
+			logger.Warn("handlePostMeta: Invalid metadata", zap.Error(err))
+			return err
+		}
+
+		// Omitted params keep the keys the editor does not know about
+		if meta.Params == nil {
+			meta.Params = post.Params
+		}
+		if meta.Thumbnail != nil && post.Thumbnail != nil {
+			meta.Thumbnail.plain = post.Thumbnail.plain
+		}
+		post.PostMeta = meta
+
+		updated, err := post.Bytes()
+		if err != nil {
+			return NewValidationError("front_matter", "Could not encode front matter", err)
+		}
+		if err := app.authorizePostChange(r, lang, content, updated); err != nil {
+			logger.Warn("handlePostMeta: Change not allowed", zap.String("path", fullPath), zap.Error(err))
+			return err
+		}
+		if err := app.fileSystem.WriteFile(fullPath, updated, 0644); err != nil {
+			return err
+		}
+		app.postIndex.Update(fullPath)
+		app.recordRevision(logger, fullPath, "Update front matter of "+filename)
+		app.recordAudit(r, AuditPostMeta, fullPath, contentHash(content), contentHash(updated), nil)
+
+		logger.Info("handlePostMeta: Successfully updated post metadata", zap.String("path", fullPath))
+		if info, err := app.fileSystem.Stat(fullPath); err == nil {
+			w.Header().Set("ETag", contentETag(updated, info.ModTime()))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(post)
+
+	default:
+		logger.Warn("handlePostMeta: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+}
+
+// handlePosts lists indexed posts with filters, sorting and cursor pagination.
+// Supported query parameters: lang, section, tag, category, draft, from, to, q, sort, order, limit and cursor.
+func (app *Application) handlePosts(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		logger.Warn("handlePosts: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	query, err := parsePostQuery(r.URL.Query())
+	if err != nil {
+		logger.Warn("handlePosts: Invalid query", zap.Error(err))
+		return err
+	}
+
+	page, err := app.postIndex.Query(query)
+	if err != nil {
+		logger.Warn("handlePosts: Could not query post index", zap.Error(err))
+		return err
+	}
+
+	logger.Info("handlePosts: Responding with posts", zap.Int("items", len(page.Items)), zap.Int("total", page.Total))
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(page)
+}
+
+// parsePostQuery reads a PostQuery from URL query parameters
+func parsePostQuery(values url.Values) (PostQuery, error) {
+	query := PostQuery{
+		Language: values.Get("lang"),
+		Section:  values.Get("section"),
+		Tag:      values.Get("tag"),
+		Category: values.Get("category"),
+		Q:        values.Get("q"),
+		Sort:     values.Get("sort"),
+		Cursor:   values.Get("cursor"),
+	}
+
+	switch values.Get("order") {
+	case "":
+		// Newest posts first unless another field is sorted on
+		query.Desc = query.Sort == "" || query.Sort == "date"
+	case "desc":
+		query.Desc = true
+	case "asc":
+		query.Desc = false
+	default:
+		return query, NewValidationError("order", "Order must be 'asc' or 'desc'", nil)
+	}
+
+	if draft := values.Get("draft"); draft != "" {
+		value, err := strconv.ParseBool(draft)
+		if err != nil {
+			return query, NewValidationError("draft", "Draft must be true or false", err)
+		}
+		query.Draft = &value
+	}
+
+	if from := values.Get("from"); from != "" {
+		date, err := parseFrontMatterDate(from)
+		if err != nil {
+			return query, NewValidationError("from", "Invalid from date", err)
+		}
+		query.From = date
+	}
+	if to := values.Get("to"); to != "" {
+		date, err := parseFrontMatterDate(to)
+		if err != nil {
+			return query, NewValidationError("to", "Invalid to date", err)
+		}
+		// A plain date includes the whole day
+		if len(to) == len("2006-01-02") {
+			date = date.Add(24*time.Hour - time.Nanosecond)
+		}
+		query.To = date
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 {
+			return query, NewValidationError("limit", "Limit must be a positive number", err)
+		}
+		query.Limit = value
+	}
+	return query, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Extensions the editor reads and writes below each kind of root
+var (
+	contentExtensions = []string{".md", ".markdown"}
+	imageExtensions   = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif", ".svg"}
+	dataExtensions    = []string{".json", ".yaml", ".yml", ".toml"}
+	// assetExtensions allow the manifests of image variants next to the processed images
+	assetExtensions = append(append([]string{}, imageExtensions...), ".json")
+)
+
+// SandboxRoot is a folder the editor may access, with the file extensions allowed below it
+type SandboxRoot struct {
+	Path       string
+	Extensions []string
+}
+
+// SandboxFileSystem decorates a FileSystem so that only files below the configured roots can be reached.
+// Paths that escape a root, directly, through ".." or through a symlink, are rejected with a ValidationError,
+// as are files whose extension is not allowed for their root.
+type SandboxFileSystem struct {
+	FileSystem
+	logger *Logger
+
+	mu    sync.RWMutex
+	roots []sandboxRoot
+}
+
+// sandboxRoot is a SandboxRoot with its path made absolute and its symlinks resolved
+type sandboxRoot struct {
+	path       string
+	resolved   string
+	extensions map[string]bool
+}
+
+// NewSandboxFileSystem wraps a FileSystem so it only reaches files below the given roots
+func NewSandboxFileSystem(fs FileSystem, roots []SandboxRoot, logger *Logger) (*SandboxFileSystem, error) {
+	sandbox := &SandboxFileSystem{
+		FileSystem: fs,
+		logger:     logger,
+	}
+	if err := sandbox.SetRoots(roots); err != nil {
+		return nil, err
+	}
+	return sandbox, nil
+}
+
+// SetRoots replaces the roots of the sandbox, e.g. after the configured folders changed.
+// The roots are only replaced when all of them can be resolved.
+func (fs *SandboxFileSystem) SetRoots(roots []SandboxRoot) error {
+	resolvedRoots := make([]sandboxRoot, 0, len(roots))
+	for _, root := range roots {
+		if root.Path == "" {
+			return fmt.Errorf("sandbox root cannot be empty")
+		}
+		absPath, err := filepath.Abs(root.Path)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for %s: %w", root.Path, err)
+		}
+		resolved, err := resolveExisting(absPath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve sandbox root %s: %w", root.Path, err)
+		}
+		extensions := make(map[string]bool)
+		for _, ext := range root.Extensions {
+			extensions[strings.ToLower(ext)] = true
+		}
+		resolvedRoots = append(resolvedRoots, sandboxRoot{
+			path:       absPath,
+			resolved:   resolved,
+			extensions: extensions,
+		})
+	}
+
+	fs.mu.Lock()
+	fs.roots = resolvedRoots
+	fs.mu.Unlock()
+	return nil
+}
+
+// sandboxRootsFromConfig lists the folders the editor works with: content, media, assets, archetypes and data
+func sandboxRootsFromConfig(config Config) []SandboxRoot {
+	roots := []SandboxRoot{
+		// The sidecars are listed before the media folder they are in, the first matching root applies
+		{Path: filepath.Join(config.Server.MediaFolder, mediaMetaFolder), Extensions: []string{".json"}},
+		{Path: config.Server.MediaFolder, Extensions: imageExtensions},
+		{Path: config.Server.AssetFolder, Extensions: assetExtensions},
+		{Path: "data", Extensions: dataExtensions},
+	}
+	if config.Server.ArchetypeFolder != "" {
+		roots = append(roots, SandboxRoot{Path: config.Server.ArchetypeFolder, Extensions: contentExtensions})
+	}
+	for _, code := range config.LanguageCodes() {
+		roots = append(roots, SandboxRoot{Path: config.Languages[code].ContentFolder, Extensions: contentExtensions})
+	}
+	return roots
+}
+
+// ReadFile reads a file inside the sandbox
+func (fs *SandboxFileSystem) ReadFile(filename string) ([]byte, error) {
+	if err := fs.checkFile(filename); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.ReadFile(filename)
+}
+
+// WriteFile writes a file inside the sandbox
+func (fs *SandboxFileSystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	if err := fs.checkFile(filename); err != nil {
+		return err
+	}
+	return fs.FileSystem.WriteFile(filename, data, perm)
+}
+
+// ReadDir reads a directory inside the sandbox
+func (fs *SandboxFileSystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	if _, err := fs.check(dirname); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.ReadDir(dirname)
+}
+
+// MkdirAll creates a directory inside the sandbox
+func (fs *SandboxFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	if _, err := fs.check(path); err != nil {
+		return err
+	}
+	return fs.FileSystem.MkdirAll(path, perm)
+}
+
+// Remove removes a file inside the sandbox
+func (fs *SandboxFileSystem) Remove(name string) error {
+	if err := fs.checkFile(name); err != nil {
+		return err
+	}
+	return fs.FileSystem.Remove(name)
+}
+
+// Rename moves a file within the sandbox
+func (fs *SandboxFileSystem) Rename(oldpath, newpath string) error {
+	if err := fs.checkFile(oldpath); err != nil {
+		return err
+	}
+	if err := fs.checkFile(newpath); err != nil {
+		return err
+	}
+	return fs.FileSystem.Rename(oldpath, newpath)
+}
+
+// Stat returns file info of a file or directory inside the sandbox
+func (fs *SandboxFileSystem) Stat(name string) (os.FileInfo, error) {
+	if _, err := fs.check(name); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.Stat(name)
+}
+
+// Create creates a file inside the sandbox
+func (fs *SandboxFileSystem) Create(name string) (*os.File, error) {
+	if err := fs.checkFile(name); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.Create(name)
+}
+
+// CreateTemp starts a new version of a file inside the sandbox
+func (fs *SandboxFileSystem) CreateTemp(name string, perm os.FileMode) (*PendingFile, error) {
+	if err := fs.checkFile(name); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.CreateTemp(name, perm)
+}
+
+// Walk walks a file tree inside the sandbox
+func (fs *SandboxFileSystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	if _, err := fs.check(root); err != nil {
+		return err
+	}
+	return fs.FileSystem.Walk(root, walkFn)
+}
+
+// checkFile checks that a path is inside the sandbox and has an extension allowed for its root
+func (fs *SandboxFileSystem) checkFile(path string) error {
+	root, err := fs.check(path)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if !root.extensions[ext] {
+		fs.logger.Warn("SandboxFileSystem: File extension not allowed", zap.String("path", path), zap.String("extension", ext))
+		return NewValidationError("path", fmt.Sprintf("File extension '%s' is not allowed", ext), nil)
+	}
+	return nil
+}
+
+// check returns the root a path belongs to.
+// The path must stay inside the root both lexically and after resolving symlinks.
+func (fs *SandboxFileSystem) check(path string) (*sandboxRoot, error) {
+	if path == "" || strings.ContainsRune(path, 0) {
+		return nil, NewValidationError("path", "Invalid path", nil)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, NewValidationError("path", "Invalid path", err)
+	}
+
+	fs.mu.RLock()
+	roots := fs.roots
+	fs.mu.RUnlock()
+
+	for i := range roots {
+		root := &roots[i]
+		if !isWithin(root.path, absPath) {
+			continue
+		}
+		resolved, err := resolveExisting(absPath)
+		if err != nil {
+			return nil, NewValidationError("path", "Invalid path", err)
+		}
+		if !isWithin(root.resolved, resolved) {
+			fs.logger.Warn("SandboxFileSystem: Symlink points outside of the sandbox", zap.String("path", path), zap.String("resolved", resolved))
+			return nil, NewValidationError("path", "Path leaves the allowed folders through a symlink", nil)
+		}
+		return root, nil
+	}
+
+	fs.logger.Warn("SandboxFileSystem: Path outside of the sandbox", zap.String("path", path))
+	return nil, NewValidationError("path", "Path is outside of the allowed folders", nil)
+}
+
+// isWithin reports whether path is root itself or below it
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// resolveExisting resolves the symlinks of the longest existing part of a path and appends the rest.
+// Files that are about to be created do not exist yet, but their parent folders may be symlinks.
+func resolveExisting(path string) (string, error) {
+	var missing []string
+	current := path
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path, nil
+		}
+		missing = append(missing, filepath.Base(current))
+		current = parent
+	}
+}
+
+// validateRelativePath checks a client supplied path that is meant to be joined to a known folder.
+// It rejects absolute paths, ".." segments and other ways to point outside of that folder.
+func validateRelativePath(field, path string) error {
+	switch {
+	case path == "":
+		return NewValidationError(field, "Path cannot be empty", nil)
+	case strings.ContainsRune(path, 0):
+		return NewValidationError(field, "Path contains invalid characters", nil)
+	case filepath.IsAbs(path) || strings.HasPrefix(path, "/") || strings.HasPrefix(path, `\`) || filepath.VolumeName(path) != "":
+		return NewValidationError(field, "Absolute paths are not allowed", nil)
+	}
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return NewValidationError(field, "Path cannot contain '..'", nil)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestValidateRelativePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "plain file", path: "post.md"},
+		{name: "nested file", path: "bundle/index.md"},
+		{name: "dots inside a name", path: "v1..2-notes.md"},
+		{name: "empty", path: "", wantErr: true},
+		{name: "parent segment", path: "../post.md", wantErr: true},
+		{name: "parent segment in the middle", path: "bundle/../../post.md", wantErr: true},
+		{name: "parent segment with backslashes", path: `bundle\..\..\post.md`, wantErr: true},
+		{name: "absolute path", path: "/etc/passwd", wantErr: true},
+		{name: "absolute path with backslash", path: `\windows\system.ini`, wantErr: true},
+		{name: "null byte", path: "post.md\x00.png", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRelativePath("filename", tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateRelativePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+			var validationErr *ValidationError
+			if err != nil && !errors.As(err, &validationErr) {
+				t.Fatalf("validateRelativePath(%q) returned %T, want *ValidationError", tt.path, err)
+			}
+		})
+	}
+}
+
+func TestGetFullPathRejectsTraversal(t *testing.T) {
+	config := Config{
+		Languages: map[string]LanguageConfig{"en": {ContentFolder: "content/en", Default: true}},
+		Sections:  []SectionConfig{{Name: "blog", Default: true}},
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		want     string
+		wantErr  bool
+	}{
+		{name: "post", filename: "en/blog/post.md", want: filepath.Join("content/en", "blog", "post.md")},
+		{name: "unknown language", filename: "fr/blog/post.md", wantErr: true},
+		{name: "unknown section", filename: "en/secret/post.md", wantErr: true},
+		{name: "missing section", filename: "post.md", wantErr: true},
+		{name: "traversal", filename: "en/blog/../../../etc/x", wantErr: true},
+		{name: "absolute file", filename: "en/blog//etc/x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getFullPath(tt.filename, config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getFullPath(%q) error = %v, wantErr %v", tt.filename, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("getFullPath(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSandboxFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content")
+	media := filepath.Join(dir, "media")
+	outside := filepath.Join(dir, "outside")
+	for _, folder := range []string{content, media, outside} {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite(t, filepath.Join(content, "post.md"), "---\ntitle: Post\n---\n")
+	mustWrite(t, filepath.Join(outside, "secret.md"), "secret")
+	mustWrite(t, filepath.Join(media, "photo.jpg"), "jpg")
+	if err := os.Symlink(filepath.Join(outside, "secret.md"), filepath.Join(content, "linked.md")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(content, "linked-folder")); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := &Logger{Logger: zap.NewNop()}
+	fs, err := NewSandboxFileSystem(NewOSFileSystem(logger), []SandboxRoot{
+		{Path: content, Extensions: contentExtensions},
+		{Path: media, Extensions: imageExtensions},
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		op      string
+		path    string
+		wantErr bool
+	}{
+		{name: "read post", op: "read", path: filepath.Join(content, "post.md")},
+		{name: "write new post", op: "write", path: filepath.Join(content, "new.md")},
+		{name: "write into new folder", op: "write", path: filepath.Join(content, "bundle", "index.md")},
+		{name: "read image", op: "read", path: filepath.Join(media, "photo.jpg")},
+		{name: "stat root", op: "stat", path: content},
+		{name: "traversal out of root", op: "read", path: filepath.Join(content, "..", "outside", "secret.md"), wantErr: true},
+		{name: "absolute path outside", op: "read", path: "/etc/passwd", wantErr: true},
+		{name: "relative path outside", op: "write", path: "../../x.md", wantErr: true},
+		{name: "symlinked file", op: "read", path: filepath.Join(content, "linked.md"), wantErr: true},
+		{name: "write through symlinked folder", op: "write", path: filepath.Join(content, "linked-folder", "x.md"), wantErr: true},
+		{name: "markdown in media folder", op: "write", path: filepath.Join(media, "page.md"), wantErr: true},
+		{name: "html in media folder", op: "create", path: filepath.Join(media, "page.html"), wantErr: true},
+		{name: "script in content folder", op: "write", path: filepath.Join(content, "run.sh"), wantErr: true},
+		{name: "remove outside", op: "remove", path: filepath.Join(outside, "secret.md"), wantErr: true},
+		{name: "rename out of root", op: "rename", path: filepath.Join(content, "post.md"), wantErr: true},
+		{name: "empty path", op: "stat", path: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			switch tt.op {
+			case "read":
+				_, err = fs.ReadFile(tt.path)
+			case "write":
+				err = fs.WriteFile(tt.path, []byte("data"), 0644)
+			case "create":
+				var file *os.File
+				file, err = fs.Create(tt.path)
+				if file != nil {
+					file.Close()
+				}
+			case "stat":
+				_, err = fs.Stat(tt.path)
+			case "remove":
+				err = fs.Remove(tt.path)
+			case "rename":
+				err = fs.Rename(tt.path, filepath.Join(outside, "moved.md"))
+			}
+
+			if tt.op == "write" && !tt.wantErr {
+				// Writing into a missing folder fails in the OS layer, the sandbox must let it through
+				var validationErr *ValidationError
+				if errors.As(err, &validationErr) {
+					t.Fatalf("%s %q rejected by sandbox: %v", tt.op, tt.path, err)
+				}
+				return
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("%s %q error = %v, wantErr %v", tt.op, tt.path, err, tt.wantErr)
+			}
+			var validationErr *ValidationError
+			if tt.wantErr && !errors.As(err, &validationErr) {
+				t.Fatalf("%s %q returned %T, want *ValidationError", tt.op, tt.path, err)
+			}
+		})
+	}
+
+	if data, err := os.ReadFile(filepath.Join(outside, "secret.md")); err != nil || string(data) != "secret" {
+		t.Fatalf("file outside of the sandbox was modified: %q, %v", data, err)
+	}
+}
+
+func mustWrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
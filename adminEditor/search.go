@@ -0,0 +1,504 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/blevesearch/snowballstem"
+	"github.com/blevesearch/snowballstem/english"
+	"github.com/blevesearch/snowballstem/german"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultSearchLimit is the number of results returned when no limit is requested
+	defaultSearchLimit = 20
+	// maxSearchLimit caps the limit a client can request
+	maxSearchLimit = 100
+	// searchSnippetLength is the approximate length of a snippet in characters
+	searchSnippetLength = 200
+
+	// BM25 tuning parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Field weights, a match in the title counts more than one in the body
+const (
+	searchWeightTitle       = 5
+	searchWeightHeading     = 3
+	searchWeightDescription = 2
+	searchWeightTaxonomy    = 2
+	searchWeightBody        = 1
+)
+
+// searchStemmers maps a language code to its Snowball stemmer
+var searchStemmers = map[string]func(*snowballstem.Env) bool{
+	"en": english.Stem,
+	"de": german.Stem,
+}
+
+// searchStopWords holds the words ignored when indexing and searching, per language
+var searchStopWords = map[string]map[string]bool{
+	"en": stopWordSet(`a about above after again against all am an and any are as at be because been before being
+		below between both but by can could did do does doing down during each few for from further had has have
+		having he her here hers herself him himself his how i if in into is it its itself just me more most my
+		myself no nor not now of off on once only or other our ours ourselves out over own same she should so some
+		such than that the their theirs them themselves then there these they this those through to too under until
+		up very was we were what when where which while who whom why will with would you your yours yourself`),
+	"de": stopWordSet(`aber alle allem allen aller alles als also am an ander andere anderem anderen anderer anderes
+		auch auf aus bei bin bis bist da damit dann das dass dein deine deinem deinen deiner dem den denn der des dich
+		die dies diese diesem diesen dieser dieses dir doch dort du durch ein eine einem einen einer eines einig er es
+		etwas euch euer eure für gegen gewesen hab habe haben hat hatte hatten hier hin hinter ich ihm ihn ihnen ihr
+		ihre ihrem ihren ihrer im in indem ins ist jede jedem jeden jeder jedes jetzt kann kein keine keinem keinen
+		man mich mir mit muss nach nicht nichts noch nun nur ob oder ohne sehr sein seine seinem seinen seiner sich
+		sie sind so solche soll sondern sonst über um und uns unser unsere unter viel vom von vor war waren warst was
+		weil weiter welche wenn werde werden wie wieder will wir wird wo wollen zu zum zur zwar zwischen`),
+}
+
+var (
+	// searchShortcodePattern matches Hugo shortcode tags, which carry markup rather than prose
+	searchShortcodePattern = regexp.MustCompile(`\{\{[<%].*?[%>]\}\}`)
+	// searchLinkPattern matches Markdown links and images so only their text remains
+	searchLinkPattern = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	// searchHTMLTagPattern matches inline HTML tags
+	searchHTMLTagPattern = regexp.MustCompile(`<[^>]+>`)
+	// searchMarkupPattern matches Markdown emphasis, code and heading markers
+	searchMarkupPattern = regexp.MustCompile("[*_`#>]+")
+	// searchSpacePattern collapses runs of whitespace
+	searchSpacePattern = regexp.MustCompile(`\s+`)
+)
+
+// SearchResult is a single ranked search hit
+type SearchResult struct {
+	Path    string  `json:"path"`
+	Lang    string  `json:"lang"`
+	Section string  `json:"section"`
+	File    string  `json:"file"`
+	Title   string  `json:"title"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchResponse is the response of the search endpoint
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Total   int            `json:"total"`
+	Results []SearchResult `json:"results"`
+}
+
+// searchDocument is the indexed form of a post
+type searchDocument struct {
+	path        string
+	lang        string
+	section     string
+	file        string
+	title       string
+	description string
+	text        string
+	length      float64
+	terms       []string
+}
+
+// searchToken is a word and its byte offsets in the text it was taken from
+type searchToken struct {
+	word       string
+	start, end int
+}
+
+// SearchIndex is an inverted index over the title, description, headings and body of every post.
+// It follows the PostIndex, so it stays current whenever a post changes.
+type SearchIndex struct {
+	postIndex      *PostIndex
+	configProvider ConfigProvider
+	fileSystem     FileSystem
+	logger         *Logger
+
+	mu       sync.RWMutex
+	docs     map[string]*searchDocument
+	postings map[string]map[string]float64
+}
+
+// NewSearchIndex creates a search index that subscribes to updates of the given post index
+func NewSearchIndex(postIndex *PostIndex, configProvider ConfigProvider, fileSystem FileSystem, logger *Logger) *SearchIndex {
+	idx := &SearchIndex{
+		postIndex:      postIndex,
+		configProvider: configProvider,
+		fileSystem:     fileSystem,
+		logger:         logger,
+		docs:           make(map[string]*searchDocument),
+		postings:       make(map[string]map[string]float64),
+	}
+	postIndex.Subscribe(idx.handlePostEvent)
+	return idx
+}
+
+// Build indexes all posts currently known to the post index
+func (idx *SearchIndex) Build() error {
+	config := idx.configProvider.GetConfig()
+	for _, path := range idx.postIndex.Paths() {
+		fullPath, err := getFullPath(path, config)
+		if err != nil {
+			return err
+		}
+		if err := idx.indexFile(path, fullPath); err != nil {
+			idx.logger.Warn("SearchIndex.Build: Skipping unreadable post", zap.String("path", path), zap.Error(err))
+		}
+	}
+
+	idx.mu.RLock()
+	idx.logger.Info("SearchIndex.Build: Indexed posts", zap.Int("documents", len(idx.docs)), zap.Int("terms", len(idx.postings)))
+	idx.mu.RUnlock()
+	return nil
+}
+
+// handlePostEvent keeps the search index in line with the post index
+func (idx *SearchIndex) handlePostEvent(event PostIndexEvent) {
+	if event.Removed {
+		idx.mu.Lock()
+		idx.removeLocked(event.Path)
+		idx.mu.Unlock()
+		return
+	}
+	if err := idx.indexFile(event.Path, event.FullPath); err != nil {
+		idx.logger.Warn("SearchIndex.handlePostEvent: Could not index post", zap.String("path", event.Path), zap.Error(err))
+	}
+}
+
+// indexFile reads a post from disk and (re)indexes it
+func (idx *SearchIndex) indexFile(path, fullPath string) error {
+	content, err := idx.fileSystem.ReadFile(fullPath)
+	if err != nil {
+		return err
+	}
+	post, err := ParsePost(content)
+	if err != nil {
+		return err
+	}
+
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) != 3 {
+		return NewValidationError("path", "Path must be prefixed with a language code and section", nil)
+	}
+	doc := &searchDocument{
+		path:        path,
+		lang:        parts[0],
+		section:     parts[1],
+		file:        parts[2],
+		title:       post.Title,
+		description: post.Description,
+	}
+
+	var headings []string
+	for _, line := range strings.Split(string(post.Body), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			headings = append(headings, line)
+		}
+	}
+	doc.text = plainSearchText(string(post.Body))
+
+	frequencies := make(map[string]float64)
+	add := func(text string, weight float64) {
+		for _, token := range tokenizeSearchText(text) {
+			term, ok := searchTerm(doc.lang, token.word)
+			if !ok {
+				continue
+			}
+			frequencies[term] += weight
+			doc.length += weight
+		}
+	}
+	add(post.Title, searchWeightTitle)
+	add(plainSearchText(strings.Join(headings, "\n")), searchWeightHeading)
+	add(post.Description, searchWeightDescription)
+	add(strings.Join(append(append([]string{}, post.Tags...), post.Categories...), " "), searchWeightTaxonomy)
+	add(doc.text, searchWeightBody)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(path)
+	for term, frequency := range frequencies {
+		key := doc.lang + ":" + term
+		if idx.postings[key] == nil {
+			idx.postings[key] = make(map[string]float64)
+		}
+		idx.postings[key][path] = frequency
+		doc.terms = append(doc.terms, key)
+	}
+	idx.docs[path] = doc
+	return nil
+}
+
+// removeLocked drops a document and its postings, the caller must hold the write lock
+func (idx *SearchIndex) removeLocked(path string) {
+	doc, ok := idx.docs[path]
+	if !ok {
+		return
+	}
+	for _, key := range doc.terms {
+		delete(idx.postings[key], path)
+		if len(idx.postings[key]) == 0 {
+			delete(idx.postings, key)
+		}
+	}
+	delete(idx.docs, path)
+}
+
+// Search ranks the documents matching the query with BM25.
+// Without a language every configured language is searched, each with its own stemmer.
+func (idx *SearchIndex) Search(query, lang string, limit int) SearchResponse {
+	response := SearchResponse{Query: query, Results: []SearchResult{}}
+	tokens := tokenizeSearchText(query)
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// Document counts and average lengths per language, for BM25 normalisation
+	counts := make(map[string]float64)
+	lengths := make(map[string]float64)
+	for _, doc := range idx.docs {
+		counts[doc.lang]++
+		lengths[doc.lang] += doc.length
+	}
+
+	scores := make(map[string]float64)
+	matched := make(map[string]int)
+	queryTerms := make(map[string]map[string]bool)
+	for docLang := range counts {
+		if lang != "" && docLang != lang {
+			continue
+		}
+		terms := make(map[string]bool)
+		for _, token := range tokens {
+			if term, ok := searchTerm(docLang, token.word); ok {
+				terms[term] = true
+			}
+		}
+		queryTerms[docLang] = terms
+
+		avgLength := lengths[docLang] / counts[docLang]
+		for term := range terms {
+			postings := idx.postings[docLang+":"+term]
+			if len(postings) == 0 {
+				continue
+			}
+			df := float64(len(postings))
+			idf := math.Log(1 + (counts[docLang]-df+0.5)/(df+0.5))
+			for path, tf := range postings {
+				norm := tf + bm25K1*(1-bm25B+bm25B*idx.docs[path].length/avgLength)
+				scores[path] += idf * tf * (bm25K1 + 1) / norm
+				matched[path]++
+			}
+		}
+	}
+
+	for path, score := range scores {
+		doc := idx.docs[path]
+		terms := queryTerms[doc.lang]
+		// Prefer documents that contain every query term
+		score *= float64(matched[path]) / float64(len(terms))
+		response.Results = append(response.Results, SearchResult{
+			Path:    doc.path,
+			Lang:    doc.lang,
+			Section: doc.section,
+			File:    doc.file,
+			Title:   doc.title,
+			Score:   math.Round(score*1000) / 1000,
+		})
+	}
+
+	sort.Slice(response.Results, func(i, j int) bool {
+		if response.Results[i].Score != response.Results[j].Score {
+			return response.Results[i].Score > response.Results[j].Score
+		}
+		return response.Results[i].Path < response.Results[j].Path
+	})
+
+	response.Total = len(response.Results)
+	if len(response.Results) > limit {
+		response.Results = response.Results[:limit]
+	}
+	for i := range response.Results {
+		doc := idx.docs[response.Results[i].Path]
+		response.Results[i].Snippet = searchSnippet(doc, queryTerms[doc.lang])
+	}
+	return response
+}
+
+// searchSnippet cuts the text around the first match and highlights matching words with <mark>.
+// The snippet is HTML escaped, so it can be inserted into the page as is.
+func searchSnippet(doc *searchDocument, terms map[string]bool) string {
+	text := doc.text
+	tokens := tokenizeSearchText(text)
+
+	first := -1
+	for i, token := range tokens {
+		if term, ok := searchTerm(doc.lang, token.word); ok && terms[term] {
+			first = i
+			break
+		}
+	}
+	if first == -1 {
+		// Matches only in the title or taxonomies, show the start of the post instead
+		if doc.description != "" {
+			return html.EscapeString(doc.description)
+		}
+		if len(tokens) == 0 {
+			return ""
+		}
+		first = 0
+	}
+
+	// Start the snippet at the first word within reach before the match
+	limit := tokens[first].start - searchSnippetLength/3
+	start := tokens[first].start
+	for i := first; i >= 0 && tokens[i].start >= limit; i-- {
+		start = tokens[i].start
+	}
+	end := start + searchSnippetLength
+	if end >= len(text) {
+		end = len(text)
+	} else {
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+	}
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString("…")
+	}
+	pos := start
+	for _, token := range tokens {
+		if token.start < start || token.end > end {
+			continue
+		}
+		term, ok := searchTerm(doc.lang, token.word)
+		if !ok || !terms[term] {
+			continue
+		}
+		snippet.WriteString(html.EscapeString(text[pos:token.start]))
+		snippet.WriteString("<mark>")
+		snippet.WriteString(html.EscapeString(text[token.start:token.end]))
+		snippet.WriteString("</mark>")
+		pos = token.end
+	}
+	snippet.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		snippet.WriteString("…")
+	}
+	return snippet.String()
+}
+
+// searchTerm lower-cases and stems a word for the given language.
+// It reports false for stop words, which are neither indexed nor searched.
+func searchTerm(lang, word string) (string, bool) {
+	word = strings.ToLower(word)
+	base := strings.SplitN(lang, "-", 2)[0]
+	if searchStopWords[base][word] {
+		return "", false
+	}
+	stem, ok := searchStemmers[base]
+	if !ok {
+		return word, true
+	}
+	env := snowballstem.NewEnv(word)
+	stem(env)
+	return env.Current(), true
+}
+
+// tokenizeSearchText splits text into words made of letters and digits
+func tokenizeSearchText(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+		if start != -1 {
+			tokens = append(tokens, searchToken{word: text[start:i], start: start, end: i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, searchToken{word: text[start:], start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// plainSearchText strips shortcodes, links and Markdown markup from a post body
+func plainSearchText(markdown string) string {
+	text := searchShortcodePattern.ReplaceAllString(markdown, " ")
+	text = searchLinkPattern.ReplaceAllString(text, "$1")
+	text = searchHTMLTagPattern.ReplaceAllString(text, " ")
+	text = searchMarkupPattern.ReplaceAllString(text, "")
+	text = searchSpacePattern.ReplaceAllString(text, " ")
+	return strings.TrimSpace(text)
+}
+
+// stopWordSet builds a lookup set from a whitespace separated word list
+func stopWordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// handleSearch runs a full-text search over all posts.
+// Query parameters: q (required), lang and limit.
+func (app *Application) handleSearch(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		logger.Warn("handleSearch: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		logger.Warn("handleSearch: Query is required")
+		return NewValidationError("q", "Query is required", nil)
+	}
+
+	lang := r.URL.Query().Get("lang")
+	if lang != "" {
+		if _, ok := app.configProvider.GetConfig().Language(lang); !ok {
+			logger.Warn("handleSearch: Unknown language", zap.String("lang", lang))
+			return NewValidationError("lang", "Unknown language '"+lang+"'", nil)
+		}
+	}
+
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return NewValidationError("limit", "Limit must be a positive number", err)
+		}
+		limit = parsed
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	response := app.searchIndex.Search(query, lang, limit)
+	logger.Info("handleSearch: Responding with search results",
+		zap.String("query", query),
+		zap.String("lang", lang),
+		zap.Int("total", response.Total),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validateSections checks that section names are usable as folder names and unique
+func validateSections(sections []SectionConfig) error {
+	if len(sections) == 0 {
+		return fmt.Errorf("at least one section must be configured")
+	}
+
+	seen := make(map[string]bool)
+	defaults := 0
+	for _, section := range sections {
+		if section.Name == "" {
+			return fmt.Errorf("section name cannot be empty")
+		}
+		if strings.ContainsAny(section.Name, `/\`) || section.Name == "." || section.Name == ".." {
+			return fmt.Errorf("section name '%s' must be a single folder name", section.Name)
+		}
+		if seen[section.Name] {
+			return fmt.Errorf("section '%s' is configured more than once", section.Name)
+		}
+		seen[section.Name] = true
+		if section.Default {
+			defaults++
+		}
+	}
+
+	if defaults > 1 {
+		return fmt.Errorf("at most one section can be marked as default, found %d", defaults)
+	}
+	return nil
+}
+
+// Section looks up a configured section by its name
+func (c Config) Section(name string) (SectionConfig, bool) {
+	for _, section := range c.Sections {
+		if section.Name == name {
+			return section, true
+		}
+	}
+	return SectionConfig{}, false
+}
+
+// DefaultSection returns the name of the section new posts are created in when none is given
+func (c Config) DefaultSection() string {
+	for _, section := range c.Sections {
+		if section.Default {
+			return section.Name
+		}
+	}
+	if len(c.Sections) > 0 {
+		return c.Sections[0].Name
+	}
+	return defaultSection
+}
+
+// sectionFolder returns the folder holding the given section for the given language code
+func sectionFolder(lang, section string, config Config) (string, error) {
+	folder, err := languageFolder(lang, config)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := config.Section(section); !ok {
+		return "", NewValidationError("section", fmt.Sprintf("Unknown section '%s'", section), nil)
+	}
+	return filepath.Join(folder, section), nil
+}
+
+// loadArchetypeDefaults reads the front matter defaults for a section from the Hugo archetypes folder.
+// Like Hugo, it uses "<section>.md" if present and falls back to "default.md".
+// Values that are Go template expressions are skipped, since the editor fills those in itself.
+func loadArchetypeDefaults(section SectionConfig, config Config, fs FileSystem) (map[string]interface{}, error) {
+	folder := config.Server.ArchetypeFolder
+	if folder == "" {
+		return map[string]interface{}{}, nil
+	}
+
+	candidates := []string{section.Name + ".md", "default.md"}
+	if section.Archetype != "" {
+		candidates = append([]string{section.Archetype}, candidates...)
+	}
+
+	for _, candidate := range candidates {
+		path := filepath.Join(folder, candidate)
+		if _, err := fs.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		content, err := fs.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		defaults, err := parseArchetypeFrontMatter(content)
+		if err != nil {
+			return nil, NewValidationError("archetype", fmt.Sprintf("Invalid front matter in archetype %s", path), err)
+		}
+		return defaults, nil
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+// parseArchetypeFrontMatter extracts the static YAML front matter values of an archetype
+func parseArchetypeFrontMatter(content []byte) (map[string]interface{}, error) {
+	var frontMatter bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	delimiters := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "---" {
+			delimiters++
+			if delimiters == 2 {
+				break
+			}
+			continue
+		}
+		if delimiters == 1 && !strings.Contains(line, "{{") {
+			frontMatter.WriteString(line)
+			frontMatter.WriteString("\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	defaults := make(map[string]interface{})
+	if err := yaml.Unmarshal(frontMatter.Bytes(), &defaults); err != nil {
+		return nil, err
+	}
+	return defaults, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// OperationTracker counts long running operations, such as saves and image processing,
+// so a shutdown can wait for them instead of cutting them off halfway.
+type OperationTracker struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	active map[string]int
+	closed bool
+}
+
+// NewOperationTracker creates an OperationTracker that accepts operations
+func NewOperationTracker() *OperationTracker {
+	return &OperationTracker{active: make(map[string]int)}
+}
+
+// Begin registers an operation and returns the function that ends it.
+// Once the tracker is closed no new operations are accepted.
+func (t *OperationTracker) Begin(name string) (func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, NewAPIError("server", name, "Server is shutting down", http.StatusServiceUnavailable, nil)
+	}
+	t.active[name]++
+	t.wg.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			t.active[name]--
+			if t.active[name] == 0 {
+				delete(t.active, name)
+			}
+			t.mu.Unlock()
+			t.wg.Done()
+		})
+	}, nil
+}
+
+// Close stops accepting new operations
+func (t *OperationTracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+}
+
+// Wait blocks until all operations have ended or the context is done
+func (t *OperationTracker) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("operations still running: %s", t.describe())
+	}
+}
+
+// describe lists the running operations, such as "save=1, process-media=2"
+func (t *OperationTracker) describe() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.active))
+	for name, count := range t.active {
+		names = append(names, fmt.Sprintf("%s=%d", name, count))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Track wraps a handler so its requests are counted as an operation
+func (t *OperationTracker) Track(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		done, err := t.Begin(name)
+		if err != nil {
+			HandleError(w, r, err)
+			return
+		}
+		defer done()
+		next(w, r)
+	}
+}
+
+// managedServer is an http.Server with the way it is started and the listener it serves on
+type managedServer struct {
+	name     string
+	server   *http.Server
+	certFile string
+	keyFile  string
+	listener net.Listener
+}
+
+// ServerManager owns the HTTP, HTTPS and redirect servers.
+// It starts them together, and stops them together when the context ends or one of them fails.
+type ServerManager struct {
+	logger       *Logger
+	operations   *OperationTracker
+	drainTimeout time.Duration
+
+	mu          sync.Mutex
+	servers     []*managedServer
+	serveErrors chan error
+}
+
+// NewServerManager creates a ServerManager that waits up to drainTimeout for requests and operations on shutdown
+func NewServerManager(operations *OperationTracker, drainTimeout time.Duration, logger *Logger) *ServerManager {
+	return &ServerManager{
+		logger:       logger,
+		operations:   operations,
+		drainTimeout: drainTimeout,
+	}
+}
+
+// Add registers a plain HTTP server
+func (m *ServerManager) Add(name string, server *http.Server) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers = append(m.servers, &managedServer{name: name, server: server})
+}
+
+// AddTLS registers an HTTPS server with its certificate
+func (m *ServerManager) AddTLS(name string, server *http.Server, certFile, keyFile string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers = append(m.servers, &managedServer{name: name, server: server, certFile: certFile, keyFile: keyFile})
+}
+
+// Run starts all servers and blocks until the context is done or a server fails, then shuts them down.
+// An address that cannot be bound is returned as an error before any server serves requests.
+func (m *ServerManager) Run(ctx context.Context) error {
+	m.mu.Lock()
+	if len(m.servers) == 0 {
+		m.mu.Unlock()
+		return errors.New("no servers configured")
+	}
+
+	// Bind all addresses first, so a port in use stops the start instead of leaving a partial set of servers
+	listeners := make([]net.Listener, 0, len(m.servers))
+	for _, s := range m.servers {
+		listener, err := net.Listen("tcp", s.server.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			m.mu.Unlock()
+			return fmt.Errorf("%s server could not listen on %s: %w", s.name, s.server.Addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	m.serveErrors = make(chan error, len(m.servers))
+	for i, s := range m.servers {
+		s.listener = listeners[i]
+		go m.serve(s, listeners[i])
+	}
+	serveErrors := m.serveErrors
+	m.mu.Unlock()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		m.logger.Info("ServerManager.Run: Shutdown requested", zap.Error(context.Cause(ctx)))
+	case runErr = <-serveErrors:
+		m.logger.Error("ServerManager.Run: Server failed, shutting down", zap.Error(runErr))
+	}
+
+	return errors.Join(runErr, m.shutdown())
+}
+
+// serve runs a server on a listener until the server is shut down or the listener is replaced by Rebind
+func (m *ServerManager) serve(s *managedServer, listener net.Listener) {
+	m.logger.Info("ServerManager.serve: Starting server", zap.String("server", s.name), zap.String("address", listener.Addr().String()))
+	var err error
+	if s.certFile != "" {
+		err = s.server.ServeTLS(listener, s.certFile, s.keyFile)
+	} else {
+		err = s.server.Serve(listener)
+	}
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		return
+	}
+
+	m.mu.Lock()
+	replaced := s.listener != listener
+	m.mu.Unlock()
+	if replaced && errors.Is(err, net.ErrClosed) {
+		return
+	}
+	select {
+	case m.serveErrors <- fmt.Errorf("%s server failed: %w", s.name, err):
+	default:
+		// A failure is already pending, Run shuts everything down
+	}
+}
+
+// Rebind moves a running server to a new address. The new address is bound before the old listener is closed,
+// so a failure leaves the server where it was. Requests already accepted on the old address are served to the end.
+// Names that are not registered are ignored.
+func (m *ServerManager) Rebind(name, addr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.servers {
+		if s.name != name {
+			continue
+		}
+		if s.listener == nil {
+			// Not started yet, Run binds the new address
+			s.server.Addr = addr
+			return nil
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("%s server could not listen on %s: %w", s.name, addr, err)
+		}
+		previous := s.listener
+		s.listener = listener
+		s.server.Addr = addr
+		go m.serve(s, listener)
+		previous.Close()
+		m.logger.Info("ServerManager.Rebind: Moved server", zap.String("server", name), zap.String("from", previous.Addr().String()), zap.String("to", addr))
+		return nil
+	}
+	return nil
+}
+
+// shutdown stops accepting requests and operations, then waits for running ones until the drain timeout
+func (m *ServerManager) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.drainTimeout)
+	defer cancel()
+
+	m.operations.Close()
+
+	m.mu.Lock()
+	servers := m.servers
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	shutdownErrors := make([]error, len(servers))
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s *managedServer) {
+			defer wg.Done()
+			if err := s.server.Shutdown(ctx); err != nil {
+				shutdownErrors[i] = fmt.Errorf("%s server did not drain: %w", s.name, err)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	if err := m.operations.Wait(ctx); err != nil {
+		shutdownErrors = append(shutdownErrors, err)
+	}
+
+	err := errors.Join(shutdownErrors...)
+	if err != nil {
+		m.logger.Warn("ServerManager.shutdown: Shutdown did not complete within the drain timeout",
+			zap.Duration("drainTimeout", m.drainTimeout),
+			zap.Error(err),
+		)
+		return err
+	}
+	m.logger.Info("ServerManager.shutdown: All servers stopped")
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// shortcodePlaceholder matches a ${name} placeholder in the code of a shortcode
+var shortcodePlaceholder = regexp.MustCompile(`\$\{([A-Za-z][A-Za-z0-9_]*)\}`)
+
+// paramNamePattern keeps parameter names usable in placeholders
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// shortcodeValueEscaper escapes a value for a quoted Hugo shortcode argument
+var shortcodeValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// validateShortcodeParams checks the parameters of a shortcode and that the placeholders of its code refer to them.
+// The code of a shortcode without parameters is inserted as is and may contain anything.
+func validateShortcodeParams(result *ConfigValidation, field string, shortcode Shortcode) {
+	if len(shortcode.Params) == 0 {
+		return
+	}
+
+	declared := make(map[string]bool, len(shortcode.Params))
+	for i, param := range shortcode.Params {
+		paramField := field + ".params." + param.Name
+		switch {
+		case !paramNamePattern.MatchString(param.Name):
+			paramField = fmt.Sprintf("%s.params[%d]", field, i)
+			result.errorf(paramField+".name", "invalid parameter name: %q. Must start with a letter and contain only letters, digits and '_'", param.Name)
+		case declared[param.Name]:
+			result.errorf(paramField+".name", "duplicate parameter name: %q", param.Name)
+		}
+		declared[param.Name] = true
+
+		switch param.Type {
+		case ParamString, ParamBool, ParamFile, ParamMedia:
+		case ParamEnum:
+			if len(param.Options) == 0 {
+				result.errorf(paramField+".options", "enum parameter needs at least one option")
+			}
+		default:
+			result.errorf(paramField+".type", "invalid parameter type: %q. Must be 'string', 'bool', 'enum', 'file' or 'media'", param.Type)
+			continue
+		}
+		if param.Pattern != "" {
+			if param.Type != ParamString {
+				result.errorf(paramField+".pattern", "pattern is only supported for string parameters")
+			} else if _, err := regexp.Compile(param.Pattern); err != nil {
+				result.errorf(paramField+".pattern", "invalid pattern: %v", err)
+			}
+		}
+		if param.Default != "" {
+			if err := checkParamValue(param, param.Default); err != nil {
+				result.errorf(paramField+".default", "invalid default: %v", err)
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, match := range shortcodePlaceholder.FindAllStringSubmatch(shortcode.Code, -1) {
+		used[match[1]] = true
+		if !declared[match[1]] {
+			result.errorf(field+".code", "placeholder ${%s} has no parameter", match[1])
+		}
+	}
+	for _, param := range shortcode.Params {
+		if paramNamePattern.MatchString(param.Name) && !used[param.Name] {
+			result.warnf(field+".params."+param.Name, "parameter is not used in code, add ${%s} where its value belongs", param.Name)
+		}
+	}
+}
+
+// checkParamValue checks a value against the type of its parameter.
+// Whether a media file exists is only checked when the shortcode is rendered.
+func checkParamValue(param ShortcodeParam, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("must be a single line")
+	}
+	switch param.Type {
+	case ParamBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%q must be 'true' or 'false'", value)
+		}
+	case ParamEnum:
+		for _, option := range param.Options {
+			if value == option {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q must be one of: %s", value, strings.Join(param.Options, ", "))
+	case ParamString:
+		if param.Pattern == "" {
+			return nil
+		}
+		pattern, err := regexp.Compile("^(?:" + param.Pattern + ")$")
+		if err != nil {
+			return err
+		}
+		if !pattern.MatchString(value) {
+			return fmt.Errorf("%q does not match %s", value, param.Pattern)
+		}
+	case ParamFile:
+		cleaned := path.Clean(strings.ReplaceAll(value, `\`, "/"))
+		if path.IsAbs(cleaned) || strings.Contains(cleaned, ":") || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return fmt.Errorf("%q must be a relative path inside the site", value)
+		}
+	case ParamMedia:
+		if strings.ContainsAny(value, `/\:`) || value == "." || value == ".." {
+			return fmt.Errorf("%q must be the name of a file in the media folder", value)
+		}
+	}
+	return nil
+}
+
+// renderShortcode fills the values of the parameters into the code of a shortcode.
+// Missing values take the default of their parameter, and every value is escaped for a quoted shortcode argument.
+func renderShortcode(shortcode Shortcode, values map[string]string, mediaExists func(name string) bool) (string, error) {
+	declared := make(map[string]bool, len(shortcode.Params))
+	for _, param := range shortcode.Params {
+		declared[param.Name] = true
+	}
+	for name := range values {
+		if !declared[name] {
+			return "", NewValidationError("values."+name, fmt.Sprintf("Shortcode '%s' has no parameter '%s'", shortcode.ID, name), nil)
+		}
+	}
+
+	filled := make(map[string]string, len(shortcode.Params))
+	for _, param := range shortcode.Params {
+		value := values[param.Name]
+		if value == "" {
+			value = param.Default
+		}
+		if value == "" {
+			if param.Required {
+				return "", NewValidationError("values."+param.Name, fmt.Sprintf("Parameter '%s' is required", param.Name), nil)
+			}
+			filled[param.Name] = ""
+			continue
+		}
+		if err := checkParamValue(param, value); err != nil {
+			return "", NewValidationError("values."+param.Name, fmt.Sprintf("Invalid value for parameter '%s': %v", param.Name, err), nil)
+		}
+		if param.Type == ParamMedia && !mediaExists(value) {
+			return "", NewValidationError("values."+param.Name, fmt.Sprintf("Media file '%s' not found", value), nil)
+		}
+		filled[param.Name] = shortcodeValueEscaper.Replace(value)
+	}
+
+	return shortcodePlaceholder.ReplaceAllStringFunc(shortcode.Code, func(placeholder string) string {
+		if value, ok := filled[shortcodePlaceholder.FindStringSubmatch(placeholder)[1]]; ok {
+			return value
+		}
+		return placeholder
+	}), nil
+}
+
+// paramValues converts the values of a render request to strings. Form fields send strings,
+// API clients may also send booleans and numbers.
+func paramValues(raw map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			values[name] = ""
+		case string:
+			values[name] = v
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		case float64:
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, NewValidationError("values."+name, "Value must be a string, a boolean or a number", nil)
+		}
+	}
+	return values, nil
+}
+
+// handleRenderShortcode fills in the parameters of a shortcode and returns the code to insert
+func (app *Application) handleRenderShortcode(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		logger.Warn("handleRenderShortcode: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	var request struct {
+		Values map[string]interface{} `json:"values"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.Error("handleRenderShortcode: Invalid request body", zap.Error(err))
+		return NewValidationError("request_body", "Invalid request body", err)
+	}
+	values, err := paramValues(request.Values)
+	if err != nil {
+		return err
+	}
+
+	id := r.PathValue("id")
+	config := app.configProvider.GetConfig()
+	for _, shortcode := range config.Shortcodes {
+		if shortcode.ID != id {
+			continue
+		}
+		code, err := renderShortcode(shortcode, values, func(name string) bool {
+			info, err := app.fileSystem.Stat(filepath.Join(config.Server.MediaFolder, name))
+			return err == nil && !info.IsDir()
+		})
+		if err != nil {
+			logger.Warn("handleRenderShortcode: Invalid parameters", zap.String("id", id), zap.Error(err))
+			return err
+		}
+
+		logger.Info("handleRenderShortcode: Rendered shortcode", zap.String("id", id))
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(map[string]string{
+			"id":   id,
+			"code": code,
+		})
+	}
+	return NewValidationError("id", "Shortcode not found", nil)
+}
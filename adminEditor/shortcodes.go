@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// The toolbar shows shortcode icons with the solid Font Awesome style ("fas fa-<icon>")
+const (
+	fontAwesomeSolidFont = "static/webfonts/fa-solid-900.ttf"
+	fontAwesomeCSS       = "static/css/bundle.min.css"
+)
+
+// shortcodeIDPattern keeps shortcode IDs usable as element IDs and in URLs
+var shortcodeIDPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// fontAwesomeIconRule matches a stylesheet rule like `.fa-tasks::before{content:"\f0ae"}`
+var fontAwesomeIconRule = regexp.MustCompile(`\.fa-([a-z0-9-]+)::?before\s*\{\s*content:\s*"\\([0-9a-fA-F]+)"`)
+
+// fontAwesomeIcons returns the icon names that render with the solid style: the glyphs of the solid font,
+// and the older names the stylesheet maps to one of them, such as "tasks" for "list-check".
+// The files do not change while the editor runs, so they are read once.
+var fontAwesomeIcons = sync.OnceValues(func() (map[string]bool, error) {
+	font, err := os.ReadFile(fontAwesomeSolidFont)
+	if err != nil {
+		return nil, err
+	}
+	glyphs, err := ttfGlyphNames(font)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fontAwesomeSolidFont, err)
+	}
+	icons := make(map[string]bool, len(glyphs))
+	for _, name := range glyphs {
+		icons[name] = true
+	}
+
+	css, err := os.ReadFile(fontAwesomeCSS)
+	if err != nil {
+		return nil, err
+	}
+	rules := fontAwesomeIconRule.FindAllStringSubmatch(string(css), -1)
+	solidCodepoints := make(map[string]bool)
+	for _, rule := range rules {
+		if icons[rule[1]] {
+			solidCodepoints[strings.ToLower(rule[2])] = true
+		}
+	}
+	for _, rule := range rules {
+		if solidCodepoints[strings.ToLower(rule[2])] {
+			icons[rule[1]] = true
+		}
+	}
+	return icons, nil
+})
+
+// ttfGlyphNames reads the glyph names of a TrueType font from its "post" table.
+// Font Awesome names each glyph after its icon.
+func ttfGlyphNames(font []byte) ([]string, error) {
+	if len(font) < 12 {
+		return nil, errors.New("not a TrueType font")
+	}
+	numTables := int(binary.BigEndian.Uint16(font[4:6]))
+	for i := 0; i < numTables; i++ {
+		record := 12 + 16*i
+		if record+16 > len(font) {
+			break
+		}
+		if string(font[record:record+4]) != "post" {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(font[record+8 : record+12]))
+		length := int(binary.BigEndian.Uint32(font[record+12 : record+16]))
+		if length < 34 || offset+length > len(font) {
+			return nil, errors.New("post table is truncated")
+		}
+		return postGlyphNames(font[offset : offset+length])
+	}
+	return nil, errors.New("font has no post table")
+}
+
+// postGlyphNames reads the names of a version 2 "post" table. The table lists the names
+// that are not one of the standard Macintosh glyph names as Pascal strings after the glyph index.
+func postGlyphNames(post []byte) ([]string, error) {
+	if binary.BigEndian.Uint32(post[0:4]) != 0x00020000 {
+		return nil, errors.New("post table has no glyph names")
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(post[32:34]))
+	pos := 34 + 2*numGlyphs
+	if pos > len(post) {
+		return nil, errors.New("post table is truncated")
+	}
+	names := []string{}
+	for pos < len(post) {
+		length := int(post[pos])
+		if pos+1+length > len(post) {
+			break
+		}
+		names = append(names, string(post[pos+1:pos+1+length]))
+		pos += 1 + length
+	}
+	return names, nil
+}
+
+// validateShortcodes checks that every shortcode has a unique ID, a code template, a known icon and valid parameters.
+// Icons are not checked when the Font Awesome files cannot be read, for example when the editor is started outside its folder.
+func validateShortcodes(result *ConfigValidation, shortcodes []Shortcode) {
+	icons, err := fontAwesomeIcons()
+	if err != nil {
+		result.warnf("shortcodes", "icons are not checked, the Font Awesome icons cannot be read: %v", err)
+	}
+
+	seen := make(map[string]bool, len(shortcodes))
+	for i, shortcode := range shortcodes {
+		field := "shortcodes." + shortcode.ID
+		switch {
+		case shortcode.ID == "":
+			field = fmt.Sprintf("shortcodes[%d]", i)
+			result.errorf(field+".id", "id cannot be empty")
+		case !shortcodeIDPattern.MatchString(shortcode.ID):
+			result.errorf(field+".id", "invalid id: %q. Must start with a letter and contain only letters, digits, '-' and '_'", shortcode.ID)
+		case seen[shortcode.ID]:
+			result.errorf(field+".id", "duplicate id: %q", shortcode.ID)
+		}
+		seen[shortcode.ID] = true
+
+		if shortcode.Code == "" {
+			result.errorf(field+".code", "code cannot be empty")
+		}
+		if shortcode.Order < 0 {
+			result.errorf(field+".order", "invalid order: %d. Must not be negative", shortcode.Order)
+		}
+		if shortcode.Icon == "" {
+			result.errorf(field+".icon", "icon cannot be empty")
+		} else if icons != nil && !icons[shortcode.Icon] {
+			result.errorf(field+".icon", "unknown Font Awesome icon: %q. Must be a solid icon name such as 'bold' or 'list-ol'", shortcode.Icon)
+		}
+		validateShortcodeParams(result, field, shortcode)
+	}
+}
+
+// sortedShortcodes returns the shortcodes in toolbar order, shortcodes with the same order keep their order in the file
+func sortedShortcodes(shortcodes []Shortcode) []Shortcode {
+	sorted := append([]Shortcode{}, shortcodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Order < sorted[j].Order
+	})
+	return sorted
+}
+
+// rewriteShortcodes applies an edit to the shortcodes list of a YAML configuration file.
+// Only the lines of the shortcodes block are replaced, so the comments and layout of all other settings are kept.
+// It returns the new file content and the shortcodes it contains.
+func rewriteShortcodes(data []byte, edit func(list *yaml.Node) error) ([]byte, []Shortcode, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse configuration file: %w", err)
+	}
+	var root *yaml.Node
+	if len(doc.Content) > 0 {
+		root = doc.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return nil, nil, errors.New("configuration file does not contain a mapping")
+		}
+		if root.Style&yaml.FlowStyle != 0 {
+			return nil, nil, errors.New("shortcodes can only be edited in a YAML configuration file, not a JSON one")
+		}
+	}
+
+	lines := strings.Split(string(data), "\n")
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "shortcodes"}
+	list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+
+	// Without a shortcodes block, the new block is appended after the last setting
+	end := len(lines)
+	for end > 0 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	start, found := end, false
+	if root != nil {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value != "shortcodes" {
+				continue
+			}
+			value := root.Content[i+1]
+			switch {
+			case value.Kind == yaml.SequenceNode && value.Style&yaml.FlowStyle != 0 && len(value.Content) > 0:
+				return nil, nil, errors.New("shortcodes can only be edited when they are written as a block list")
+			case value.Kind == yaml.SequenceNode:
+				list = value
+				list.Style = 0
+			case value.Tag != "!!null":
+				return nil, nil, errors.New("shortcodes must be a list")
+			}
+
+			// The comment above the key stays in place, it is not part of the replaced lines
+			replacedKey := *root.Content[i]
+			replacedKey.HeadComment = ""
+			key = &replacedKey
+
+			start, found = root.Content[i].Line-1, true
+			end = len(lines)
+			if i+2 < len(root.Content) {
+				end = root.Content[i+2].Line - 1
+			}
+			// Blank lines and top level comments before the next setting belong to that setting
+			for end > start+1 && (strings.TrimSpace(lines[end-1]) == "" || strings.HasPrefix(lines[end-1], "#")) {
+				end--
+			}
+			break
+		}
+	}
+
+	if err := edit(list); err != nil {
+		return nil, nil, err
+	}
+	shortcodes := []Shortcode{}
+	if err := list.Decode(&shortcodes); err != nil {
+		return nil, nil, fmt.Errorf("unable to decode shortcodes: %w", err)
+	}
+
+	// An empty list is written as "shortcodes: []" and carries the comment of the key line
+	if len(list.Content) == 0 {
+		list.Style = yaml.FlowStyle
+		list.LineComment, key.LineComment = key.LineComment, ""
+	} else if key.LineComment == "" {
+		key.LineComment, list.LineComment = list.LineComment, ""
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{key, list}}); err != nil {
+		return nil, nil, fmt.Errorf("unable to encode shortcodes: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, fmt.Errorf("unable to encode shortcodes: %w", err)
+	}
+
+	block := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	// The new lines get the line endings of the file, the kept lines still carry their "\r"
+	lineEnd := ""
+	if bytes.Contains(data, []byte("\r\n")) {
+		lineEnd = "\r"
+		for i := range block {
+			block[i] += lineEnd
+		}
+	}
+
+	out := append([]string{}, lines[:start]...)
+	if !found && start > 0 {
+		// The last setting of a file without a final newline gets one
+		if !strings.HasSuffix(out[start-1], lineEnd) {
+			out[start-1] += lineEnd
+		}
+		out = append(out, lineEnd)
+	}
+	out = append(out, block...)
+	out = append(out, lines[end:]...)
+	if !found && end == len(lines) {
+		out = append(out, "")
+	}
+	return []byte(strings.Join(out, "\n")), shortcodes, nil
+}
+
+// yamlString returns a string value, quoted like the values of the configuration files
+func yamlString(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.DoubleQuotedStyle}
+}
+
+func yamlInt(value int) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(value)}
+}
+
+// shortcodeNode returns a shortcode as a YAML mapping, with its fields in the order of the configuration files
+func shortcodeNode(shortcode Shortcode) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setMappingValue(node, "id", yamlString(shortcode.ID))
+	setMappingValue(node, "code", yamlString(shortcode.Code))
+	setMappingValue(node, "icon", yamlString(shortcode.Icon))
+	setMappingValue(node, "order", yamlInt(shortcode.Order))
+	setMappingValue(node, "tooltip", yamlString(shortcode.Tooltip))
+	if len(shortcode.Params) > 0 {
+		setMappingValue(node, "params", paramsNode(shortcode.Params))
+	}
+	return node
+}
+
+// paramsNode returns shortcode parameters as a YAML list, leaving out empty fields
+func paramsNode(params []ShortcodeParam) *yaml.Node {
+	list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, param := range params {
+		item := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(item, "name", yamlString(param.Name))
+		setMappingValue(item, "type", yamlString(string(param.Type)))
+		if param.Label != "" {
+			setMappingValue(item, "label", yamlString(param.Label))
+		}
+		if param.Default != "" {
+			setMappingValue(item, "default", yamlString(param.Default))
+		}
+		if param.Required {
+			setMappingValue(item, "required", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+		}
+		if len(param.Options) > 0 {
+			options := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+			for _, option := range param.Options {
+				options.Content = append(options.Content, yamlString(option))
+			}
+			setMappingValue(item, "options", options)
+		}
+		if param.Pattern != "" {
+			setMappingValue(item, "pattern", yamlString(param.Pattern))
+		}
+		list.Content = append(list.Content, item)
+	}
+	return list
+}
+
+// setMappingValue sets a key of a YAML mapping. An existing value is changed in place, so its comments are kept.
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			current := node.Content[i+1]
+			current.Kind, current.Tag, current.Value, current.Style, current.Content = value.Kind, value.Tag, value.Value, value.Style, value.Content
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// removeMappingKey removes a key and its value from a YAML mapping
+func removeMappingKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// findShortcode returns the position of a shortcode in the shortcodes list, or -1
+func findShortcode(list *yaml.Node, id string) int {
+	for i, item := range list.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(item.Content); j += 2 {
+			if item.Content[j].Value == "id" && item.Content[j+1].Value == id {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// editShortcodes applies an edit to the shortcodes in the configuration file, then reloads the configuration.
+// The file is only written when the resulting shortcodes are valid, and restored when the reload rejects it.
+func (app *Application) editShortcodes(r *http.Request, details map[string]string, edit func(list *yaml.Node) error) ([]Shortcode, error) {
+	logger := GetLoggerFromContext(r.Context())
+
+	app.configMu.Lock()
+	defer app.configMu.Unlock()
+
+	file := app.configProvider.ConfigFile()
+	if file == "" {
+		return nil, NewValidationError("config", "No configuration file in use, shortcodes cannot be changed", nil)
+	}
+	// The configuration file is outside the content folders, so it is not written through the sandbox
+	fileSystem := NewAtomicFileSystem(NewOSFileSystem(app.logger), app.configProvider.GetConfig().Server.KeepBackups, app.logger)
+	info, err := fileSystem.Stat(file)
+	if err != nil {
+		return nil, NewFileSystemError("stat", file, "Configuration file cannot be read", err)
+	}
+	previous, err := fileSystem.ReadFile(file)
+	if err != nil {
+		return nil, NewFileSystemError("read", file, "Configuration file cannot be read", err)
+	}
+
+	updated, shortcodes, err := rewriteShortcodes(previous, edit)
+	if err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			return nil, err
+		}
+		return nil, NewValidationError("shortcodes", "Shortcodes cannot be changed in this configuration file", err)
+	}
+	result := ConfigValidation{}
+	validateShortcodes(&result, shortcodes)
+	if !result.Valid() {
+		var others error
+		if len(result.Errors) > 1 {
+			others = result.Err()
+		}
+		return nil, NewValidationError(result.Errors[0].Field, result.Errors[0].Message, others)
+	}
+
+	if err := fileSystem.WriteFile(file, updated, info.Mode().Perm()); err != nil {
+		return nil, NewFileSystemError("write", file, "Configuration file cannot be written", err)
+	}
+	if _, err := app.configProvider.Reload(); err != nil {
+		logger.Error("editShortcodes: Configuration rejected after the change, restoring the previous file", zap.Error(err))
+		if restoreErr := fileSystem.WriteFile(file, previous, info.Mode().Perm()); restoreErr != nil {
+			logger.Error("editShortcodes: Could not restore the previous configuration file", zap.Error(restoreErr))
+		}
+		return nil, NewValidationError("config", "Configuration is not valid, the shortcodes were not changed", err)
+	}
+	app.recordAudit(r, AuditConfigChange, file, contentHash(previous), contentHash(updated), details)
+	return shortcodes, nil
+}
+
+// handleShortcodes lists the toolbar shortcodes (GET), adds one (POST) or sets their order (PUT)
+func (app *Application) handleShortcodes(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		shortcodes := sortedShortcodes(app.configProvider.GetConfig().Shortcodes)
+		logger.Info("handleShortcodes: Responding with shortcodes", zap.Int("count", len(shortcodes)))
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(shortcodes)
+
+	case http.MethodPost:
+		var shortcode Shortcode
+		if err := json.NewDecoder(r.Body).Decode(&shortcode); err != nil {
+			logger.Error("handleShortcodes: Invalid request body", zap.Error(err))
+			return NewValidationError("request_body", "Invalid request body", err)
+		}
+		shortcode.ID = strings.TrimSpace(shortcode.ID)
+
+		_, err := app.editShortcodes(r, map[string]string{"shortcode": shortcode.ID, "change": "create"}, func(list *yaml.Node) error {
+			if findShortcode(list, shortcode.ID) >= 0 {
+				return NewValidationError("id", fmt.Sprintf("Shortcode '%s' already exists", shortcode.ID), nil)
+			}
+			// Without an order the shortcode is added at the end of the toolbar
+			if shortcode.Order == 0 {
+				shortcode.Order = 1
+				for _, existing := range app.configProvider.GetConfig().Shortcodes {
+					if existing.Order >= shortcode.Order {
+						shortcode.Order = existing.Order + 1
+					}
+				}
+			}
+			list.Content = append(list.Content, shortcodeNode(shortcode))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		logger.Info("handleShortcodes: Created shortcode", zap.String("id", shortcode.ID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(shortcode)
+
+	case http.MethodPut:
+		var request struct {
+			IDs []string `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			logger.Error("handleShortcodes: Invalid request body", zap.Error(err))
+			return NewValidationError("request_body", "Invalid request body", err)
+		}
+
+		shortcodes, err := app.editShortcodes(r, map[string]string{"change": "reorder"}, func(list *yaml.Node) error {
+			if len(request.IDs) != len(list.Content) {
+				return NewValidationError("ids", fmt.Sprintf("Order must list all %d shortcodes exactly once", len(list.Content)), nil)
+			}
+			ordered := make([]*yaml.Node, 0, len(list.Content))
+			for i, id := range request.IDs {
+				index := findShortcode(list, id)
+				if index < 0 {
+					return NewValidationError("ids", fmt.Sprintf("Shortcode '%s' not found", id), nil)
+				}
+				item := list.Content[index]
+				for _, placed := range ordered {
+					if placed == item {
+						return NewValidationError("ids", fmt.Sprintf("Shortcode '%s' is listed twice", id), nil)
+					}
+				}
+				setMappingValue(item, "order", yamlInt(i+1))
+				ordered = append(ordered, item)
+			}
+			list.Content = ordered
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		logger.Info("handleShortcodes: Reordered shortcodes", zap.Strings("ids", request.IDs))
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(sortedShortcodes(shortcodes))
+
+	default:
+		logger.Warn("handleShortcodes: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+}
+
+// handleShortcode changes the code, icon, tooltip, order or parameters of a shortcode (PUT) or removes it (DELETE)
+func (app *Application) handleShortcode(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodPut:
+		var request struct {
+			Code    *string           `json:"code"`
+			Icon    *string           `json:"icon"`
+			Order   *int              `json:"order"`
+			Tooltip *string           `json:"tooltip"`
+			Params  *[]ShortcodeParam `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			logger.Error("handleShortcode: Invalid request body", zap.Error(err))
+			return NewValidationError("request_body", "Invalid request body", err)
+		}
+
+		changed := []string{}
+		shortcodes, err := app.editShortcodes(r, map[string]string{"shortcode": id, "change": "update"}, func(list *yaml.Node) error {
+			index := findShortcode(list, id)
+			if index < 0 {
+				return NewValidationError("id", "Shortcode not found", nil)
+			}
+			item := list.Content[index]
+			if request.Code != nil {
+				setMappingValue(item, "code", yamlString(*request.Code))
+				changed = append(changed, "code")
+			}
+			if request.Icon != nil {
+				setMappingValue(item, "icon", yamlString(*request.Icon))
+				changed = append(changed, "icon")
+			}
+			if request.Order != nil {
+				setMappingValue(item, "order", yamlInt(*request.Order))
+				changed = append(changed, "order")
+			}
+			if request.Tooltip != nil {
+				setMappingValue(item, "tooltip", yamlString(*request.Tooltip))
+				changed = append(changed, "tooltip")
+			}
+			if request.Params != nil {
+				if len(*request.Params) == 0 {
+					removeMappingKey(item, "params")
+				} else {
+					setMappingValue(item, "params", paramsNode(*request.Params))
+				}
+				changed = append(changed, "params")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		logger.Info("handleShortcode: Updated shortcode", zap.String("id", id), zap.Strings("fields", changed))
+		for _, shortcode := range shortcodes {
+			if shortcode.ID == id {
+				w.Header().Set("Content-Type", "application/json")
+				return json.NewEncoder(w).Encode(shortcode)
+			}
+		}
+		return NewValidationError("id", "Shortcode not found", nil)
+
+	case http.MethodDelete:
+		_, err := app.editShortcodes(r, map[string]string{"shortcode": id, "change": "delete"}, func(list *yaml.Node) error {
+			index := findShortcode(list, id)
+			if index < 0 {
+				return NewValidationError("id", "Shortcode not found", nil)
+			}
+			list.Content = append(list.Content[:index], list.Content[index+1:]...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		logger.Info("handleShortcode: Deleted shortcode", zap.String("id", id))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+
+	default:
+		logger.Warn("handleShortcode: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+}
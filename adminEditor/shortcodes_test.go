@@ -0,0 +1,235 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const shortcodesConfig = `# Server settings
+server:
+  port: 8080 # the port
+
+# Toolbar buttons
+shortcodes: # toolbar
+  - id: "bold"
+    code: "**{{text}}**"
+    icon: "bold"
+    order: 1
+    tooltip: "Bold" # shown on hover
+  - id: "mark"
+    code: "=={{text}}=="
+    icon: "highlighter"
+    order: 2
+    tooltip: "Mark"
+
+# Tags shown in the wizard
+tags:
+  - go
+`
+
+// renameBold changes the tooltip of the "bold" shortcode
+func renameBold(list *yaml.Node) error {
+	setMappingValue(list.Content[findShortcode(list, "bold")], "tooltip", yamlString("Strong"))
+	return nil
+}
+
+// appendShortcode adds a "new" shortcode at the end of the list
+func appendShortcode(list *yaml.Node) error {
+	list.Content = append(list.Content, shortcodeNode(Shortcode{ID: "new", Code: "{{text}}", Icon: "bold", Order: 3, Tooltip: "New"}))
+	return nil
+}
+
+const newShortcodeYAML = `  - id: "new"
+    code: "{{text}}"
+    icon: "bold"
+    order: 3
+    tooltip: "New"
+`
+
+func TestRewriteShortcodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		edit    func(list *yaml.Node) error
+		want    string
+		wantIDs []string
+	}{
+		{
+			name:    "edit a block in the middle of the file",
+			config:  shortcodesConfig,
+			edit:    renameBold,
+			want:    strings.Replace(shortcodesConfig, `tooltip: "Bold"`, `tooltip: "Strong"`, 1),
+			wantIDs: []string{"bold", "mark"},
+		},
+		{
+			name:    "add to a block in the middle of the file",
+			config:  shortcodesConfig,
+			edit:    appendShortcode,
+			want:    strings.Replace(shortcodesConfig, "    tooltip: \"Mark\"\n", "    tooltip: \"Mark\"\n"+newShortcodeYAML, 1),
+			wantIDs: []string{"bold", "mark", "new"},
+		},
+		{
+			name: "block at the end of the file",
+			config: `server:
+  port: 8080
+shortcodes:
+  - id: "bold"
+    code: "**{{text}}**"
+    icon: "bold"
+    order: 1
+    tooltip: "Bold"
+`,
+			edit: appendShortcode,
+			want: `server:
+  port: 8080
+shortcodes:
+  - id: "bold"
+    code: "**{{text}}**"
+    icon: "bold"
+    order: 1
+    tooltip: "Bold"
+` + newShortcodeYAML,
+			wantIDs: []string{"bold", "new"},
+		},
+		{
+			name:    "missing shortcodes key",
+			config:  "server:\n  port: 8080\n\n",
+			edit:    appendShortcode,
+			want:    "server:\n  port: 8080\n\nshortcodes:\n" + newShortcodeYAML + "\n",
+			wantIDs: []string{"new"},
+		},
+		{
+			name:    "missing shortcodes key and final newline",
+			config:  "server:\n  port: 8080",
+			edit:    appendShortcode,
+			want:    "server:\n  port: 8080\n\nshortcodes:\n" + newShortcodeYAML,
+			wantIDs: []string{"new"},
+		},
+		{
+			name:    "empty file",
+			config:  "",
+			edit:    appendShortcode,
+			want:    "shortcodes:\n" + newShortcodeYAML,
+			wantIDs: []string{"new"},
+		},
+		{
+			name:    "empty flow list",
+			config:  "server:\n  port: 8080\nshortcodes: [] # none yet\ntags: []\n",
+			edit:    appendShortcode,
+			want:    "server:\n  port: 8080\nshortcodes: # none yet\n" + newShortcodeYAML + "tags: []\n",
+			wantIDs: []string{"new"},
+		},
+		{
+			name:    "removing the last shortcode writes an empty flow list",
+			config:  shortcodesConfig,
+			edit:    func(list *yaml.Node) error { list.Content = nil; return nil },
+			want:    "# Server settings\nserver:\n  port: 8080 # the port\n\n# Toolbar buttons\nshortcodes: [] # toolbar\n\n# Tags shown in the wizard\ntags:\n  - go\n",
+			wantIDs: []string{},
+		},
+		{
+			name: "comment before the next key stays with it",
+			config: `shortcodes:
+  - id: "bold"
+    code: "**{{text}}**"
+    icon: "bold"
+    order: 1
+    tooltip: "Bold"
+# Tags shown in the wizard
+# (one per line)
+tags:
+  - go
+`,
+			edit: renameBold,
+			want: `shortcodes:
+  - id: "bold"
+    code: "**{{text}}**"
+    icon: "bold"
+    order: 1
+    tooltip: "Strong"
+# Tags shown in the wizard
+# (one per line)
+tags:
+  - go
+`,
+			wantIDs: []string{"bold"},
+		},
+		{
+			name:    "windows line endings",
+			config:  strings.ReplaceAll(shortcodesConfig, "\n", "\r\n"),
+			edit:    appendShortcode,
+			want:    strings.ReplaceAll(strings.Replace(shortcodesConfig, "    tooltip: \"Mark\"\n", "    tooltip: \"Mark\"\n"+newShortcodeYAML, 1), "\n", "\r\n"),
+			wantIDs: []string{"bold", "mark", "new"},
+		},
+		{
+			name:    "windows line endings without shortcodes key",
+			config:  "server:\r\n  port: 8080\r\n",
+			edit:    appendShortcode,
+			want:    strings.ReplaceAll("server:\n  port: 8080\n\nshortcodes:\n"+newShortcodeYAML, "\n", "\r\n"),
+			wantIDs: []string{"new"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, shortcodes, err := rewriteShortcodes([]byte(tt.config), tt.edit)
+			if err != nil {
+				t.Fatalf("rewriteShortcodes() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("rewriteShortcodes() =\n%q\nwant\n%q", out, tt.want)
+			}
+			ids := []string{}
+			for _, shortcode := range shortcodes {
+				ids = append(ids, shortcode.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("rewriteShortcodes() shortcodes = %v, want %v", ids, tt.wantIDs)
+			}
+
+			// The result is still a valid configuration with all other settings
+			var before, after map[string]interface{}
+			if err := yaml.Unmarshal([]byte(tt.config), &before); err != nil {
+				t.Fatalf("yaml.Unmarshal(config) error = %v", err)
+			}
+			if err := yaml.Unmarshal(out, &after); err != nil {
+				t.Fatalf("yaml.Unmarshal(result) error = %v", err)
+			}
+			for key, value := range before {
+				if key != "shortcodes" && !sameValue(after[key], value) {
+					t.Errorf("setting %q = %v, want %v", key, after[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestRewriteShortcodesRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "json configuration", config: "{\"server\": {\"port\": 8080}, \"shortcodes\": []}\n"},
+		{name: "flow list with shortcodes", config: "shortcodes: [{id: a, code: x, icon: bold}]\n"},
+		{name: "shortcodes that are not a list", config: "shortcodes: bold\n"},
+		{name: "document that is not a mapping", config: "- a\n- b\n"},
+		{name: "invalid yaml", config: "shortcodes: [\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			_, _, err := rewriteShortcodes([]byte(tt.config), func(list *yaml.Node) error {
+				called = true
+				return nil
+			})
+			if err == nil {
+				t.Fatal("rewriteShortcodes() error = nil, want an error")
+			}
+			if called {
+				t.Error("rewriteShortcodes() applied the edit to a configuration it rejects")
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@
 
 import { initializeModals, showModal, hideModal } from './modals.js'
 import { searchModalDialog } from './search-modal.js'
+import { showShortcodeForm } from './shortcodeFormModal.js'
 
 const editor = CodeMirror.fromTextArea(document.getElementById('editor'), {
   mode: 'markdown',
@@ -27,6 +28,9 @@ const viewportHeight = window.innerHeight
 editor.setSize(null, viewportHeight + 'px')
 
 let isDirty = false
+// Version of the loaded file, sent back on save so concurrent edits are detected
+let loadedETag = null
+let loadedContent = ''
 
 editor.on('change', function () {
   isDirty = true
@@ -74,6 +78,7 @@ document.addEventListener('DOMContentLoaded', function () {
     .then((response) => response.json())
     .then((data) => {
       config = data
+      initializeLanguageSelect()
       initializeToolbar()
       initializeFileSelector()
       initializeButtons()
@@ -118,29 +123,69 @@ document.addEventListener('DOMContentLoaded', function () {
         button.innerHTML = `<i class="fas fa-${shortcode.icon}"></i>`
         button.title = shortcode.tooltip
         button.id = shortcode.id
-        button.onclick = () => insertShortcode(shortcode.code, shortcode.id)
+        // Shortcodes with parameters are filled in through a form
+        button.onclick = () => shortcode.params && shortcode.params.length > 0
+          ? showShortcodeForm(shortcode)
+          : insertShortcode(shortcode.code, shortcode.id)
         buttonGroup.appendChild(button)
       })
   }
 
+  function initializeLanguageSelect () {
+    const languages = config.languages || {}
+    const codes = Object.keys(languages).sort()
+    if (codes.length === 0) {
+      return
+    }
+    languageSelect.innerHTML = ''
+    codes.forEach((code) => {
+      const option = document.createElement('option')
+      option.value = code
+      option.textContent = languages[code].name || code
+      option.selected = languages[code].default === true
+      languageSelect.appendChild(option)
+    })
+  }
+
   function initializeFileSelector () {
     languageSelect.addEventListener('change', updateFileList)
     fileSelect.addEventListener('change', loadSelectedFile)
     updateFileList()
   }
 
+  function fetchPosts (params, items = []) {
+    return fetch(`/api/posts?${new URLSearchParams(params)}`)
+      .then((response) => response.json())
+      .then((page) => {
+        items.push(...(page.items || []))
+        if (page.nextCursor) {
+          return fetchPosts({ ...params, cursor: page.nextCursor }, items)
+        }
+        return items
+      })
+  }
+
   function updateFileList () {
     const language = languageSelect.value
-    fetch(`/api/list?lang=${language}`)
-      .then((response) => response.json())
-      .then((data) => {
+    fetchPosts({ lang: language, sort: 'title', order: 'asc', limit: 500 })
+      .then((posts) => {
+        const sections = {}
+        posts.forEach((post) => {
+          sections[post.section] = sections[post.section] || []
+          sections[post.section].push(post)
+        })
         fileSelect.innerHTML = ''
-        const files = Array.isArray(data) ? data : data[language] || []
-        files.forEach((file) => {
-          const option = document.createElement('option')
-          option.value = file
-          option.textContent = file
-          fileSelect.appendChild(option)
+        Object.keys(sections).sort().forEach((section) => {
+          const group = document.createElement('optgroup')
+          group.label = section
+          sections[section].forEach((post) => {
+            const option = document.createElement('option')
+            option.value = `${section}/${post.file}`
+            option.textContent = post.title || post.file
+            option.title = post.file
+            group.appendChild(option)
+          })
+          fileSelect.appendChild(group)
         })
         loadSelectedFile()
       })
@@ -155,8 +200,12 @@ document.addEventListener('DOMContentLoaded', function () {
     const file = fileSelect.value
     if (file) {
       fetch(`/api/load?file=${language}/${file}`)
-        .then((response) => response.text())
+        .then((response) => {
+          loadedETag = response.headers.get('ETag')
+          return response.text()
+        })
         .then((content) => {
+          loadedContent = content
           editor.setValue(content)
           isDirty = false
         })
@@ -250,6 +299,7 @@ document.addEventListener('DOMContentLoaded', function () {
 
     const menuActions = {
       save: () => saveFile(),
+      restoreRevision: () => restoreRevision(),
       undo: () => editor.undo(),
       redo: () => editor.redo(),
       cut: () => {
@@ -366,11 +416,26 @@ document.addEventListener('DOMContentLoaded', function () {
     const language = languageSelect.value
     const file = fileSelect.value
     const content = editor.getValue()
+    const headers = {}
+    if (loadedETag) {
+      headers['If-Match'] = loadedETag
+    }
     fetch(`/api/save?file=${language}/${file}`, {
       method: 'POST',
+      headers,
       body: content,
     })
-      .then(() => {
+      .then((response) => {
+        if (response.status === 409) {
+          return response.json().then((conflict) => mergeWithServer(`${language}/${file}`, content, conflict.server))
+        }
+        if (!response.ok) {
+          return response.json().then((error) => {
+            throw new Error(error.error)
+          })
+        }
+        loadedETag = response.headers.get('ETag')
+        loadedContent = content
         window.alert('File saved successfully')
         isDirty = false
       })
@@ -379,6 +444,85 @@ document.addEventListener('DOMContentLoaded', function () {
       })
   }
 
+  // mergeWithServer combines the local edits with a newer server copy and puts the result into the editor for review
+  function mergeWithServer (path, content, server) {
+    return fetch(`/api/merge?file=${path}`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ base: loadedContent, content }),
+    })
+      .then((response) => response.json())
+      .then((merge) => {
+        loadedETag = merge.etag
+        loadedContent = server.content
+        editor.setValue(merge.content)
+        isDirty = true
+        if (merge.clean) {
+          window.alert('The file was changed by someone else. Your edits were merged with theirs, please review and save again.')
+        } else {
+          window.alert(`The file was changed by someone else. ${merge.conflicts} conflicting edit(s) are marked in the editor, please resolve them and save again.`)
+        }
+      })
+  }
+
+  // restoreRevision rolls the loaded file back to a revision picked from its history.
+  // The version it was loaded in is sent along, so a restore never replaces a newer save unseen.
+  function restoreRevision () {
+    const path = `${languageSelect.value}/${fileSelect.value}`
+    if (isDirty && !window.confirm('Restoring a revision discards your unsaved changes. Continue?')) {
+      return
+    }
+    fetch(`/api/history?file=${path}&limit=20`)
+      .then((response) => response.json())
+      .then((revisions) => {
+        if (!Array.isArray(revisions) || revisions.length === 0) {
+          window.alert('This file has no revisions to restore')
+          return
+        }
+        const list = revisions
+          .map((revision, index) => `${index + 1}: ${revision.date} ${revision.author} - ${revision.message}`)
+          .join('\n')
+        const revision = revisions[parseInt(window.prompt(`Restore which revision?\n${list}`, '1'), 10) - 1]
+        if (!revision) {
+          return
+        }
+        const headers = { 'Content-Type': 'application/json' }
+        if (loadedETag) {
+          headers['If-Match'] = loadedETag
+        }
+        return fetch('/api/history/restore', {
+          method: 'POST',
+          headers,
+          body: JSON.stringify({ file: path, revision: revision.hash }),
+        }).then((response) => {
+          if (response.status === 409) {
+            return response.json().then((conflict) => {
+              loadedETag = conflict.server.etag
+              loadedContent = conflict.server.content
+              editor.setValue(conflict.server.content)
+              isDirty = false
+              window.alert('The file was changed by someone else since it was loaded. The editor now shows their version, restore the revision again to replace it.')
+            })
+          }
+          if (!response.ok) {
+            return response.json().then((error) => {
+              throw new Error(error.error)
+            })
+          }
+          loadedETag = response.headers.get('ETag')
+          return response.text().then((content) => {
+            loadedContent = content
+            editor.setValue(content)
+            isDirty = false
+            window.alert('Revision restored')
+          })
+        })
+      })
+      .catch((error) => {
+        window.alert('Error restoring revision: ' + error)
+      })
+  }
+
   function checkSpelling () {
     const content = editor.getValue()
     const lang = languageSelect.value
@@ -467,6 +611,9 @@ function createHamburgerMenu () {
         <i class="fas fa-save me-2"></i>Save
         <span class="shortcut">Ctrl+S</span>
       </a></li>
+      <li><a class="dropdown-item" href="#" data-action="restoreRevision">
+        <i class="fas fa-history me-2"></i>Restore revision
+      </a></li>
       
       <!-- Edit operations -->
       <li><h6 class="dropdown-header">Edit</h6></li>
@@ -0,0 +1,49 @@
+// Sends the browser back to the login page when the session has expired and adds a logout button
+(function () {
+    const originalFetch = window.fetch.bind(window);
+
+    function redirectToLogin() {
+        const next = window.location.pathname + window.location.search;
+        window.location.href = '/login.html?next=' + encodeURIComponent(next);
+    }
+
+    window.fetch = async (...args) => {
+        const response = await originalFetch(...args);
+        const url = args[0] instanceof Request ? args[0].url : String(args[0]);
+        if (response.status === 401 && !url.includes('/api/auth/login')) {
+            redirectToLogin();
+        }
+        return response;
+    };
+
+    async function logout() {
+        try {
+            await originalFetch('/api/auth/logout', { method: 'POST' });
+        } finally {
+            redirectToLogin();
+        }
+    }
+
+    document.addEventListener('DOMContentLoaded', async () => {
+        const response = await originalFetch('/api/auth/me');
+        if (!response.ok) {
+            return;
+        }
+        const me = await response.json();
+        if (!me.authEnabled) {
+            return;
+        }
+
+        const button = document.createElement('button');
+        button.type = 'button';
+        button.className = 'btn btn-outline-secondary ms-2';
+        button.title = `Logged in as ${me.username}`;
+        button.textContent = `Logout ${me.username}`;
+        button.addEventListener('click', logout);
+
+        const container = document.getElementById('newPostButtons') || document.querySelector('.container');
+        if (container) {
+            container.appendChild(button);
+        }
+    });
+})();
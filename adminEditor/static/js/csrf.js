@@ -0,0 +1,41 @@
+// Adds the CSRF token of the session to every state changing request to the editor API
+(function () {
+    const baseFetch = window.fetch.bind(window);
+    const safeMethods = ['GET', 'HEAD', 'OPTIONS'];
+    let tokenPromise = null;
+
+    function loadToken(refresh) {
+        if (!tokenPromise || refresh) {
+            tokenPromise = baseFetch('/api/auth/csrf', { credentials: 'same-origin' })
+                .then(response => (response.ok ? response.json() : { token: '' }))
+                .then(data => data.token || '')
+                .catch(() => '');
+        }
+        return tokenPromise;
+    }
+
+    async function fetchWithToken(input, init, refresh) {
+        const token = await loadToken(refresh);
+        const headers = new Headers(init.headers || (input instanceof Request ? input.headers : undefined));
+        if (token) {
+            headers.set('X-CSRF-Token', token);
+        }
+        return baseFetch(input, { ...init, headers });
+    }
+
+    window.fetch = async (input, init = {}) => {
+        const method = (init.method || (input instanceof Request ? input.method : 'GET')).toUpperCase();
+        const url = new URL(input instanceof Request ? input.url : String(input), window.location.href);
+        if (url.origin !== window.location.origin || safeMethods.includes(method)) {
+            return baseFetch(input, init);
+        }
+
+        const response = await fetchWithToken(input, init, false);
+        if (response.status !== 403) {
+            return response;
+        }
+        // The session may have changed since the token was loaded, retry once with a fresh token
+        const error = await response.clone().json().catch(() => ({}));
+        return error.details === 'csrf' ? fetchWithToken(input, init, true) : response;
+    };
+})();
@@ -51,7 +51,11 @@ export function initImageSelector (containerId) {
       return response.json()
     })
     .then((data) => {
-      images = data
+      images = data.map((record) => ({
+        ...record,
+        id: record.name,
+        url: `/media-data/${encodeURIComponent(record.name)}`
+      }))
       renderImages(images)
     })
     .catch((error) => {
@@ -66,7 +70,7 @@ export function initImageSelector (containerId) {
       col.className = 'col'
       col.innerHTML = `
               <div class="card h-100">
-                <img src="${image.url}" class="card-img-top" alt="${image.name}">
+                <img src="${image.url}" class="card-img-top" alt="${image.alt || image.name}">
                 <div class="card-body">
                   <h5 class="card-title">${image.name}</h5>
                   <div class="form-check">
@@ -6,26 +6,59 @@ export function initializeInternalLinksModal () {
   const langSelect = document.createElement('select')
   langSelect.id = 'languageSelect'
   langSelect.className = 'form-select mb-3'
-  langSelect.innerHTML = `<option value="-">Select language</option>
-        <option value="de">German</option>
-        <option value="en">English</option>
-    `
+  langSelect.innerHTML = '<option value="-">Select language</option>'
   modalBody.appendChild(langSelect)
 
+  fetch('/api/config')
+    .then(response => response.json())
+    .then(config => {
+      const languages = config.languages || {}
+      Object.keys(languages).sort().forEach(code => {
+        const option = document.createElement('option')
+        option.value = code
+        option.text = languages[code].name || code
+        langSelect.appendChild(option)
+      })
+    })
+
+  const searchInput = document.createElement('input')
+  searchInput.type = 'search'
+  searchInput.id = 'internalLinkSearch'
+  searchInput.className = 'form-control mb-3'
+  searchInput.placeholder = 'Search posts'
+  modalBody.appendChild(searchInput)
+
   const fileSelect = document.createElement('select')
   fileSelect.id = 'internalLinkSelect'
   fileSelect.className = 'form-select mb-3 caret'
   modalBody.appendChild(fileSelect)
 
-  langSelect.addEventListener('change', () => {
+  let searchTimer
+  const updatePosts = () => {
     const selectedLang = langSelect.value
-    fetch(`/api/list?lang=${selectedLang}`)
-      .then(response => response.json())
-      .then(files => {
-        fileSelect.innerHTML = ''
-        addDefaultOption(fileSelect)
-        files.forEach(file => addLinkOption(fileSelect, file, selectedLang))
-      })
+    if (selectedLang === '-') {
+      return
+    }
+    const query = searchInput.value.trim()
+    // Ranked full-text search while typing, otherwise the posts sorted by title
+    const request = query !== ''
+      ? fetch(`/api/search?${new URLSearchParams({ q: query, lang: selectedLang, limit: 50 })}`)
+        .then(response => response.json())
+        .then(data => data.results || [])
+      : fetch(`/api/posts?${new URLSearchParams({ lang: selectedLang, sort: 'title', order: 'asc', limit: 50 })}`)
+        .then(response => response.json())
+        .then(page => page.items || [])
+    request.then(posts => {
+      fileSelect.innerHTML = ''
+      addDefaultOption(fileSelect)
+      posts.forEach(post => addLinkOption(fileSelect, post, selectedLang))
+    })
+  }
+
+  langSelect.addEventListener('change', updatePosts)
+  searchInput.addEventListener('input', () => {
+    clearTimeout(searchTimer)
+    searchTimer = setTimeout(updatePosts, 250)
   })
 
   const linkTextInput = document.createElement('input')
@@ -47,10 +80,10 @@ function addDefaultOption (selectElement) {
   selectElement.appendChild(defaultOption)
 }
 
-function addLinkOption (selectElement, file, lang) {
+function addLinkOption (selectElement, post, lang) {
   const option = document.createElement('option')
-  option.value = file
-  option.text = file
+  option.value = `${post.section}/${post.file}`
+  option.text = post.title ? `${post.title} (${post.section}/${post.file})` : `${post.section}/${post.file}`
   option.setAttribute('data-language', lang)
   selectElement.appendChild(option)
 }
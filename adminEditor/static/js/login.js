@@ -0,0 +1,37 @@
+// Login page: starts a session and returns to the page that required it
+(function () {
+    const form = document.getElementById('login-form');
+    const errorBox = document.getElementById('login-error');
+
+    // Only follow local paths, so the login page cannot be used to redirect elsewhere
+    function nextLocation() {
+        const next = new URLSearchParams(window.location.search).get('next');
+        if (next && next.startsWith('/') && !next.startsWith('//') && !next.startsWith('/\\')) {
+            return next;
+        }
+        return '/';
+    }
+
+    form.addEventListener('submit', async (event) => {
+        event.preventDefault();
+        errorBox.classList.add('d-none');
+
+        try {
+            const response = await fetch('/api/auth/login', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    username: document.getElementById('username').value,
+                    password: document.getElementById('password').value
+                })
+            });
+            if (!response.ok) {
+                throw new Error(response.status === 401 ? 'Invalid username or password' : `Login failed (${response.status})`);
+            }
+            window.location.href = nextLocation();
+        } catch (error) {
+            errorBox.textContent = error.message;
+            errorBox.classList.remove('d-none');
+        }
+    });
+})();
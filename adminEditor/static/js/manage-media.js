@@ -108,10 +108,46 @@ class ImageEditor {
                     </div>
                     <div class="col-auto">
                         <button id="editSelectedBtn" class="btn btn-primary">Edit Selected</button>
+                        <button id="focusSelectedBtn" class="btn btn-secondary">Set Focal Point</button>
+                        <button id="resetCropBtn" class="btn btn-secondary">Reset Crop</button>
+                        <button id="detailsSelectedBtn" class="btn btn-secondary">Edit Details</button>
                         <button id="deleteSelectedBtn" class="btn btn-danger">Delete Selected</button>
                     </div>
                 </div>
                 <div id="messageContainer" class="alert d-none" role="alert"></div>
+                <form id="mediaDetailsForm" class="card card-body mb-4 d-none">
+                    <h5 id="mediaDetailsName" class="card-title"></h5>
+                    <div class="row g-3">
+                        <div class="col-md-6">
+                            <label class="form-label" for="mediaAlt">Alt text</label>
+                            <input type="text" class="form-control" id="mediaAlt" name="alt">
+                        </div>
+                        <div class="col-md-6">
+                            <label class="form-label" for="mediaCaption">Caption</label>
+                            <input type="text" class="form-control" id="mediaCaption" name="caption">
+                        </div>
+                        <div class="col-md-6">
+                            <label class="form-label" for="mediaAuthor">Author</label>
+                            <input type="text" class="form-control" id="mediaAuthor" name="author">
+                        </div>
+                        <div class="col-md-6">
+                            <label class="form-label" for="mediaAuthorUrl">Author URL</label>
+                            <input type="url" class="form-control" id="mediaAuthorUrl" name="authorUrl">
+                        </div>
+                        <div class="col-md-6">
+                            <label class="form-label" for="mediaOrigin">Origin</label>
+                            <input type="text" class="form-control" id="mediaOrigin" name="origin">
+                        </div>
+                        <div class="col-md-6">
+                            <label class="form-label" for="mediaLicense">License</label>
+                            <input type="text" class="form-control" id="mediaLicense" name="license">
+                        </div>
+                    </div>
+                    <div class="mt-3">
+                        <button type="submit" class="btn btn-primary">Save Details</button>
+                        <button type="button" id="mediaDetailsCancel" class="btn btn-secondary">Close</button>
+                    </div>
+                </form>
                 <div id="imageContainer" class="row row-cols-1 row-cols-md-3 g-4"></div>
             </div>
         `;
@@ -125,8 +161,8 @@ class ImageEditor {
       if (!response.ok) {
         throw new Error('Failed to fetch images');
       }
-      const images = await response.json();
-      this.renderImages(images);
+      this.images = await response.json();
+      this.renderImages(this.images);
     } catch (error) {
       this.showMessage(error.message, 'danger');
     }
@@ -139,14 +175,16 @@ class ImageEditor {
     images.forEach(image => {
       const col = document.createElement('div');
       col.className = 'col';
+      const size = image.width ? `${image.width}×${image.height}, ` : '';
       col.innerHTML = `
                 <div class="card h-100">
-                    <img src="../media-data/${image}" class="card-img-top" alt="${image}">
+                    <img src="../media-data/${image.name}" class="card-img-top" alt="${image.alt || image.name}" data-name="${image.name}">
                     <div class="card-body">
-                        <h5 class="card-title">${image}</h5>
+                        <h5 class="card-title">${image.name}</h5>
+                        <p class="card-text text-muted small">${size}${Math.ceil(image.size / 1024)} KB</p>
                         <div class="form-check">
-                            <input class="form-check-input" type="checkbox" value="${image}" id="check-${image}">
-                            <label class="form-check-label" for="check-${image}">
+                            <input class="form-check-input" type="checkbox" value="${image.name}" id="check-${image.name}">
+                            <label class="form-check-label" for="check-${image.name}">
                                 Select
                             </label>
                         </div>
@@ -163,6 +201,11 @@ class ImageEditor {
     const cancelBtn = document.getElementById('cancelBtn');
     const editSelectedBtn = document.getElementById('editSelectedBtn');
     const deleteSelectedBtn = document.getElementById('deleteSelectedBtn');
+    const focusSelectedBtn = document.getElementById('focusSelectedBtn');
+    const resetCropBtn = document.getElementById('resetCropBtn');
+    const detailsSelectedBtn = document.getElementById('detailsSelectedBtn');
+    const detailsForm = document.getElementById('mediaDetailsForm');
+    const imageContainer = document.getElementById('imageContainer');
     const searchInput = document.getElementById('searchInput');
 
     uploadInput.addEventListener('change', (e) => this.handleImageUpload(e));
@@ -170,6 +213,15 @@ class ImageEditor {
     cancelBtn.addEventListener('click', () => this.cancelEdit());
     editSelectedBtn.addEventListener('click', () => this.editSelected());
     deleteSelectedBtn.addEventListener('click', () => this.deleteSelected());
+    focusSelectedBtn.addEventListener('click', () => this.startFocusSelection());
+    resetCropBtn.addEventListener('click', () => this.resetCrop());
+    detailsSelectedBtn.addEventListener('click', () => this.editDetails());
+    detailsForm.addEventListener('submit', (e) => {
+      e.preventDefault();
+      this.saveDetails();
+    });
+    document.getElementById('mediaDetailsCancel').addEventListener('click', () => detailsForm.classList.add('d-none'));
+    imageContainer.addEventListener('click', (e) => this.handleFocusClick(e));
     searchInput.addEventListener('input', (e) => this.handleSearch(e));
 
     const uploadSection = document.querySelector('.upload-section');
@@ -288,6 +340,98 @@ class ImageEditor {
     }
   }
 
+  startFocusSelection() {
+    const selectedCheckbox = document.querySelector('.form-check-input:checked');
+    if (!selectedCheckbox) {
+      this.showMessage('Please select an image to set its focal point', 'warning');
+      return;
+    }
+    this.focusImage = selectedCheckbox.value;
+    this.showMessage('Click on the subject of the selected image', 'info');
+  }
+
+  // Thumbnails and "fill" crops of the image are centered on the clicked point
+  async handleFocusClick(event) {
+    const img = event.target.closest('.card-img-top');
+    if (!this.focusImage || !img || img.dataset.name !== this.focusImage) {
+      return;
+    }
+    const rect = img.getBoundingClientRect();
+    const focus = {
+      x: Math.min(Math.max((event.clientX - rect.left) / rect.width, 0), 1),
+      y: Math.min(Math.max((event.clientY - rect.top) / rect.height, 0), 1)
+    };
+    const imageName = this.focusImage;
+    this.focusImage = null;
+    await this.saveCrop(imageName, { focus });
+  }
+
+  async resetCrop() {
+    const selectedCheckbox = document.querySelector('.form-check-input:checked');
+    if (!selectedCheckbox) {
+      this.showMessage('Please select an image to reset its crop', 'warning');
+      return;
+    }
+    await this.saveCrop(selectedCheckbox.value, {});
+  }
+
+  async saveCrop(imageName, crop) {
+    try {
+      const response = await fetch(`/api/media/${encodeURIComponent(imageName)}/crop`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(crop)
+      });
+      const result = await response.json();
+      if (!response.ok) {
+        throw new Error(result.error || 'Failed to save crop');
+      }
+      this.showMessage(`Crop saved, ${result.rendered.length} processed image(s) updated`, 'success');
+    } catch (error) {
+      this.showMessage(error.message, 'danger');
+    }
+  }
+
+  editDetails() {
+    const selectedCheckbox = document.querySelector('.form-check-input:checked');
+    const image = selectedCheckbox && this.images.find(record => record.name === selectedCheckbox.value);
+    if (!image) {
+      this.showMessage('Please select an image to edit its details', 'warning');
+      return;
+    }
+    const form = document.getElementById('mediaDetailsForm');
+    this.detailsImage = image.name;
+    document.getElementById('mediaDetailsName').textContent = image.name;
+    ['alt', 'caption', 'author', 'authorUrl', 'origin', 'license'].forEach(field => {
+      form.elements[field].value = image[field] || '';
+    });
+    form.classList.remove('d-none');
+  }
+
+  async saveDetails() {
+    const form = document.getElementById('mediaDetailsForm');
+    const details = {};
+    ['alt', 'caption', 'author', 'authorUrl', 'origin', 'license'].forEach(field => {
+      details[field] = form.elements[field].value;
+    });
+    try {
+      const response = await fetch(`/api/media/${encodeURIComponent(this.detailsImage)}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(details)
+      });
+      const result = await response.json();
+      if (!response.ok) {
+        throw new Error(result.error || 'Failed to save details');
+      }
+      form.classList.add('d-none');
+      this.showMessage('Details saved', 'success');
+      this.loadImages();
+    } catch (error) {
+      this.showMessage(error.message, 'danger');
+    }
+  }
+
   handleSearch(event) {
     const searchTerm = event.target.value.toLowerCase();
     const images = document.querySelectorAll('.card');
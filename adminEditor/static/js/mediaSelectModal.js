@@ -6,6 +6,7 @@ export function initializeMediaSelectModal () {
   const modal = new bootstrap.Modal(document.getElementById('mediaSelectModal'))
   const modalBody = document.querySelector('#mediaSelectModal .modal-body')
   const insertBtn = document.getElementById('insert-media-btn')
+  const mediaByName = new Map()
 
   fetch('/api/media-list')
     .then(response => response.json())
@@ -23,9 +24,10 @@ export function initializeMediaSelectModal () {
       select.appendChild(defaultOption)
 
       mediaFiles.forEach(file => {
+        mediaByName.set(file.name, file)
         const option = document.createElement('option')
-        option.value = file
-        option.textContent = file
+        option.value = file.name
+        option.textContent = file.width ? `${file.name} (${file.width}×${file.height})` : file.name
         select.appendChild(option)
       })
 
@@ -38,7 +40,8 @@ export function initializeMediaSelectModal () {
   insertBtn.addEventListener('click', () => {
     const selectedFile = document.getElementById('mediaFileSelect').value
     if (selectedFile) {
-      window.addEditorText(`![${selectedFile}](/img/blog/${selectedFile})`)
+      const alt = mediaByName.get(selectedFile)?.alt || selectedFile
+      window.addEditorText(`![${alt}](/img/blog/${selectedFile})`)
     }
     hideModal('mediaSelectModal')
   })
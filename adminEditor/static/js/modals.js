@@ -3,6 +3,7 @@ import { initializeMediaSelectModal } from './mediaSelectModal.js'
 import { initializeBlockquoteModal } from './blockquoteModal.js'
 import { initializeColoredCodeModal } from './coloredCodeModal.js'
 import { initializeInternalLinksModal } from './internalLinksModal.js'
+import { initializeShortcodeFormModal } from './shortcodeFormModal.js'
 
 
 export function initializeModals () {
@@ -10,6 +11,7 @@ export function initializeModals () {
   initializeBlockquoteModal()
   initializeColoredCodeModal()
   initializeInternalLinksModal()
+  initializeShortcodeFormModal()
 }
 
 export function showModal (modalId) {
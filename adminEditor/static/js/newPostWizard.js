@@ -9,12 +9,15 @@ class NewPostWizard {
   async init() {
     // Fetch existing tags and categories
     try {
-      const [tagsResponse, categoriesResponse] = await Promise.all([
+      const [tagsResponse, categoriesResponse, configResponse] = await Promise.all([
         fetch('/api/tags'),
         fetch('/api/categories'),
+        fetch('/api/config'),
       ]);
       this.existingTags = await tagsResponse.json();
       this.existingCategories = await categoriesResponse.json();
+      const config = await configResponse.json();
+      this.sections = config.sections || [];
     } catch (error) {
       console.error('Error fetching tags and categories:', error?.message);
     }
@@ -64,6 +67,21 @@ class NewPostWizard {
                         }>German</option>
                     </select>
                 </div>
+                <div class="mb-3">
+                    <label class="form-label">Section</label>
+                    <select class="form-select" id="section">
+                        ${(this.sections || [])
+                          .map(
+                            (section) => `<option value="${section.name}" ${
+                              this.formData.section === section.name ||
+                              (!this.formData.section && section.default)
+                                ? 'selected'
+                                : ''
+                            }>${section.name}</option>`,
+                          )
+                          .join('')}
+                    </select>
+                </div>
                 <div class="mb-3">
                     <label class="form-label required">Title</label>
                     <input type="text" class="form-control" id="title" 
@@ -310,6 +328,12 @@ class NewPostWizard {
       (e) => (this.formData.language = e.target.value),
     );
 
+    const sectionSelect = document.getElementById('section');
+    sectionSelect?.addEventListener(
+      'change',
+      (e) => (this.formData.section = e.target.value),
+    );
+
     // Tags and Categories
     this.container.querySelectorAll('.toggle-tag').forEach((tagButton) => {
       tagButton.addEventListener('click', (e) => {
@@ -332,11 +356,24 @@ class NewPostWizard {
           const file = event.target.files[0]
           if (file) {
             this.formData.thumbnail.localFile = file.name
+            this.fillThumbnailAuthor(file.name)
           }
         }.bind(this)
       )
     }
 
+    const thumbnailCreditInputs = {
+      thumbnailAuthor: 'author',
+      thumbnailAuthorUrl: 'authorUrl',
+      thumbnailOrigin: 'origin',
+    };
+    Object.entries(thumbnailCreditInputs).forEach(([id, field]) => {
+      document.getElementById(id)?.addEventListener(
+        'input',
+        (e) => (this.formData.thumbnail[field] = e.target.value),
+      );
+    });
+
     const newTagInput = document.getElementById('newTag');
     newTagInput?.addEventListener('keypress', (e) => {
       if (e.key === 'Enter') {
@@ -438,6 +475,40 @@ class NewPostWizard {
     this.render();
   }
 
+  // Fills the credits of the thumbnail from the media library, or the artist from the EXIF data
+  // of the selected file. Fields that are set already are kept.
+  async fillThumbnailAuthor(name) {
+    try {
+      const credits = {};
+      const response = await fetch(`/api/media/${encodeURIComponent(name)}`);
+      if (response.ok) {
+        const record = await response.json();
+        credits.author = record.author;
+        credits.authorUrl = record.authorUrl;
+        credits.origin = record.origin;
+      }
+      if (!credits.author) {
+        const exifResponse = await fetch(`/api/media/${encodeURIComponent(name)}/exif`);
+        if (exifResponse.ok) {
+          const metadata = await exifResponse.json();
+          credits.author = metadata.artist || metadata.copyright;
+        }
+      }
+      const inputs = { author: 'thumbnailAuthor', authorUrl: 'thumbnailAuthorUrl', origin: 'thumbnailOrigin' };
+      Object.entries(inputs).forEach(([field, id]) => {
+        if (credits[field] && !this.formData.thumbnail[field]) {
+          this.formData.thumbnail[field] = credits[field];
+          const input = document.getElementById(id);
+          if (input) {
+            input.value = credits[field];
+          }
+        }
+      });
+    } catch (error) {
+      console.warn('Could not read photo metadata:', error);
+    }
+  }
+
   async submit() {
     if (this.formData.title.trim() === '') {
       window.alert('Title is required');
@@ -495,6 +566,7 @@ class NewPostWizard {
         origin: '',
       },
       language: 'en',
+      section: '',
     };
   }
 }
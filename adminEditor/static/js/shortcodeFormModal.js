@@ -0,0 +1,118 @@
+import { showModal, hideModal } from './modals.js'
+
+// shortcodeFormModal.js
+// Shows a form for the parameters of a shortcode, the server fills the values into its code
+let currentShortcode = null
+
+export function initializeShortcodeFormModal () {
+  const insertBtn = document.getElementById('insert-shortcode-btn')
+  insertBtn.addEventListener('click', () => {
+    insertShortcodeFromForm()
+  })
+}
+
+export function showShortcodeForm (shortcode) {
+  currentShortcode = shortcode
+  document.getElementById('shortcodeFormModalLabel').textContent = shortcode.tooltip || shortcode.id
+
+  const formArea = document.getElementById('shortcodeFormArea')
+  formArea.innerHTML = ''
+  shortcode.params.forEach(param => {
+    formArea.appendChild(createField(param))
+  })
+  showModal('shortcodeFormModal')
+}
+
+function createField (param) {
+  const group = document.createElement('div')
+  group.className = 'mb-3'
+  const id = `shortcodeParam-${param.name}`
+
+  const label = document.createElement('label')
+  label.htmlFor = id
+  label.textContent = param.label || param.name
+
+  if (param.type === 'bool') {
+    group.className = 'form-check form-switch mb-3'
+    const input = document.createElement('input')
+    input.type = 'checkbox'
+    input.id = id
+    input.className = 'form-check-input'
+    input.setAttribute('role', 'switch')
+    input.checked = param.default === 'true'
+    label.className = 'form-check-label'
+    group.appendChild(input)
+    group.appendChild(label)
+    return group
+  }
+
+  label.className = 'form-label'
+  group.appendChild(label)
+
+  let input
+  if (param.type === 'enum' || param.type === 'media') {
+    input = document.createElement('select')
+    input.className = 'form-select caret'
+    if (!param.required) {
+      addOption(input, '', '-')
+    }
+    if (param.type === 'enum') {
+      param.options.forEach(option => addOption(input, option, option))
+      input.value = param.default || input.value
+    } else {
+      fetch('/api/media-list')
+        .then(response => response.json())
+        .then(mediaFiles => {
+          mediaFiles.forEach(file => addOption(input, file.name, file.name))
+          input.value = param.default || input.value
+        })
+    }
+  } else {
+    input = document.createElement('input')
+    input.type = 'text'
+    input.className = 'form-control'
+    input.value = param.default || ''
+    if (param.type === 'file') {
+      input.placeholder = './path/in/site'
+    }
+  }
+  input.id = id
+  input.required = param.required === true
+  group.appendChild(input)
+  return group
+}
+
+function addOption (select, value, text) {
+  const option = document.createElement('option')
+  option.value = value
+  option.textContent = text
+  select.appendChild(option)
+}
+
+function insertShortcodeFromForm () {
+  if (!currentShortcode) {
+    return
+  }
+  const values = {}
+  currentShortcode.params.forEach(param => {
+    const input = document.getElementById(`shortcodeParam-${param.name}`)
+    values[param.name] = param.type === 'bool' ? String(input.checked) : input.value
+  })
+
+  fetch(`/api/shortcodes/${encodeURIComponent(currentShortcode.id)}/render`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ values })
+  })
+    .then(async response => {
+      const data = await response.json()
+      if (!response.ok) {
+        throw new Error(data.error || response.statusText)
+      }
+      window.addEditorText(data.code)
+      hideModal('shortcodeFormModal')
+    })
+    .catch(err => {
+      window.alert('Could not insert shortcode: ' + err?.message)
+    })
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// apiTokenPrefix marks personal access tokens, so they are easy to recognize in scripts and secret scanners
+	apiTokenPrefix = "aet_"
+	// defaultTokenLifetimeDays is used when a token is created without an expiry
+	defaultTokenLifetimeDays = 30
+	// maxTokenLifetimeDays is the longest a token can be valid
+	maxTokenLifetimeDays = 365
+)
+
+// APIToken is a personal access token as shown to its owner. The secret itself is never stored.
+type APIToken struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name"`
+	Owner      string       `json:"owner"`
+	Scopes     []Permission `json:"scopes"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	ExpiresAt  time.Time    `json:"expiresAt"`
+	LastUsedAt *time.Time   `json:"lastUsedAt,omitempty"`
+}
+
+// storedToken is an APIToken with the hash of its secret, as kept in the tokens file
+type storedToken struct {
+	APIToken
+	Hash string `json:"hash"`
+}
+
+// tokensFile is the layout of the file referenced by auth.tokensFile
+type tokensFile struct {
+	Tokens []storedToken `json:"tokens"`
+}
+
+// TokenStore keeps personal access tokens in a JSON file, indexed by the SHA-256 hash of their secret
+type TokenStore struct {
+	path   string
+	fs     FileSystem
+	logger *Logger
+	mu     sync.Mutex
+	tokens map[string]*storedToken
+}
+
+// NewTokenStore loads the tokens file, a missing file is an empty store
+func NewTokenStore(path string, fs FileSystem, logger *Logger) (*TokenStore, error) {
+	store := &TokenStore{
+		path:   path,
+		fs:     fs,
+		logger: logger,
+		tokens: make(map[string]*storedToken),
+	}
+
+	data, err := fs.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file %s: %w", path, err)
+	}
+	var file tokensFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file %s: %w", path, err)
+	}
+	for i := range file.Tokens {
+		token := file.Tokens[i]
+		store.tokens[token.Hash] = &token
+	}
+	logger.Info("NewTokenStore: Loaded API tokens", zap.String("path", path), zap.Int("count", len(store.tokens)))
+	return store, nil
+}
+
+// Create issues a new token and returns its secret, which is only shown once
+func (s *TokenStore) Create(owner, name string, scopes []Permission, expiresAt time.Time) (string, APIToken, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", APIToken{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "", APIToken{}, fmt.Errorf("failed to generate token id: %w", err)
+	}
+	secret := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	token := &storedToken{
+		APIToken: APIToken{
+			ID:        hex.EncodeToString(id),
+			Name:      name,
+			Owner:     owner,
+			Scopes:    scopes,
+			CreatedAt: time.Now().UTC(),
+			ExpiresAt: expiresAt.UTC(),
+		},
+		Hash: hashAPIToken(secret),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token.Hash] = token
+	if err := s.saveLocked(); err != nil {
+		delete(s.tokens, token.Hash)
+		return "", APIToken{}, err
+	}
+	return secret, token.APIToken, nil
+}
+
+// List returns the tokens of an owner, or all tokens for an empty owner, newest first
+func (s *TokenStore) List(owner string) []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := []APIToken{}
+	for _, token := range s.tokens {
+		if owner == "" || token.Owner == owner {
+			tokens = append(tokens, token.APIToken)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// Get returns a token by its ID
+func (s *TokenStore) Get(id string) (APIToken, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.tokens {
+		if token.ID == id {
+			return token.APIToken, true
+		}
+	}
+	return APIToken{}, false
+}
+
+// Revoke deletes a token by its ID
+func (s *TokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, token := range s.tokens {
+		if token.ID == id {
+			delete(s.tokens, hash)
+			if err := s.saveLocked(); err != nil {
+				s.tokens[hash] = token
+				return err
+			}
+			return nil
+		}
+	}
+	return NewValidationError("id", "Token not found", nil)
+}
+
+// Authenticate returns the token of a secret that has not expired.
+// The last use is only kept in memory and written with the next change of the file.
+func (s *TokenStore) Authenticate(secret string) (APIToken, bool) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) {
+		return APIToken{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[hashAPIToken(secret)]
+	if !ok || time.Now().After(token.ExpiresAt) {
+		return APIToken{}, false
+	}
+	now := time.Now().UTC()
+	token.LastUsedAt = &now
+	return token.APIToken, true
+}
+
+// saveLocked writes all tokens to the tokens file, readable only by the owner of the process
+func (s *TokenStore) saveLocked() error {
+	file := tokensFile{Tokens: make([]storedToken, 0, len(s.tokens))}
+	for _, token := range s.tokens {
+		file.Tokens = append(file.Tokens, *token)
+	}
+	sort.Slice(file.Tokens, func(i, j int) bool {
+		return file.Tokens[i].CreatedAt.Before(file.Tokens[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.fs.WriteFile(s.path, data, 0600)
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// userFromBearer returns the owner of a bearer token, limited to the scopes of the token
+func (a *Authenticator) userFromBearer(header string) (*User, error) {
+	scheme, secret, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, NewAuthError("Unsupported authorization scheme", nil)
+	}
+	token, ok := a.tokens.Authenticate(strings.TrimSpace(secret))
+	if !ok {
+		return nil, NewAuthError("Invalid or expired token", nil)
+	}
+	owner, ok := a.users.Lookup(token.Owner)
+	if !ok {
+		return nil, NewAuthError("Owner of the token no longer exists", nil)
+	}
+	user := *owner
+	user.Scopes = token.Scopes
+	user.TokenID = token.ID
+	return &user, nil
+}
+
+// handleTokens lists the tokens of the current user, admins see all tokens, and creates new ones
+func (app *Application) handleTokens(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	user, err := app.tokenManager(r)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		owner := user.Username
+		if user.Role == RoleAdmin {
+			owner = ""
+		}
+		tokens := app.auth.tokens.List(owner)
+		logger.Info("handleTokens: Responding with tokens", zap.Int("count", len(tokens)))
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(tokens)
+
+	case http.MethodPost:
+		var request struct {
+			Name          string       `json:"name"`
+			Scopes        []Permission `json:"scopes"`
+			ExpiresInDays int          `json:"expiresInDays"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			logger.Error("handleTokens: Invalid request body", zap.Error(err))
+			return NewValidationError("request_body", "Invalid request body", err)
+		}
+		request.Name = strings.TrimSpace(request.Name)
+		if request.Name == "" {
+			return NewValidationError("name", "Name is required", nil)
+		}
+		if len(request.Scopes) == 0 {
+			return NewValidationError("scopes", "At least one scope is required", nil)
+		}
+		for _, scope := range request.Scopes {
+			if !user.Can(scope) {
+				return NewValidationError("scopes", fmt.Sprintf("Scope '%s' is unknown or not granted to role '%s'", scope, user.Role), nil)
+			}
+		}
+		if request.ExpiresInDays == 0 {
+			request.ExpiresInDays = defaultTokenLifetimeDays
+		}
+		if request.ExpiresInDays < 1 || request.ExpiresInDays > maxTokenLifetimeDays {
+			return NewValidationError("expiresInDays", fmt.Sprintf("Expiry must be between 1 and %d days", maxTokenLifetimeDays), nil)
+		}
+
+		expiresAt := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		secret, token, err := app.auth.tokens.Create(user.Username, request.Name, request.Scopes, expiresAt)
+		if err != nil {
+			logger.Error("handleTokens: Failed to create token", zap.Error(err))
+			return err
+		}
+
+		logger.Info("handleTokens: Created token", zap.String("token_id", token.ID), zap.String("name", token.Name))
+		app.recordAudit(r, AuditTokenCreate, "", "", "", map[string]string{"tokenId": token.ID, "name": token.Name})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(struct {
+			APIToken
+			Token string `json:"token"`
+		}{token, secret})
+
+	default:
+		logger.Warn("handleTokens: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+}
+
+// handleRevokeToken revokes a token of the current user, admins can revoke any token
+func (app *Application) handleRevokeToken(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodDelete {
+		logger.Warn("handleRevokeToken: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	user, err := app.tokenManager(r)
+	if err != nil {
+		return err
+	}
+
+	id := r.PathValue("id")
+	token, ok := app.auth.tokens.Get(id)
+	if !ok || (token.Owner != user.Username && user.Role != RoleAdmin) {
+		return NewValidationError("id", "Token not found", nil)
+	}
+	if err := app.auth.tokens.Revoke(id); err != nil {
+		return err
+	}
+
+	logger.Info("handleRevokeToken: Revoked token", zap.String("token_id", id), zap.String("owner", token.Owner))
+	app.recordAudit(r, AuditTokenRevoke, "", "", "", map[string]string{"tokenId": id, "owner": token.Owner})
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// tokenManager returns the user managing tokens. Tokens are only managed from a browser session,
+// so a leaked token cannot be used to issue new ones.
+func (app *Application) tokenManager(r *http.Request) (*User, error) {
+	if !app.auth.Enabled() {
+		return nil, NewValidationError("auth", "Authentication is disabled", nil)
+	}
+	user, ok := UserFromContext(r.Context())
+	if !ok {
+		return nil, NewAuthError("Authentication required", nil)
+	}
+	if user.TokenID != "" {
+		return nil, NewForbiddenError("tokens", "Tokens can only be managed from a login session")
+	}
+	return user, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestTokenStore returns a token store with an expired and a valid token of alice
+func newTestTokenStore(t *testing.T) (store *TokenStore, valid, expired string) {
+	t.Helper()
+	logger := &Logger{Logger: zap.NewNop()}
+	store, err := NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"), NewOSFileSystem(logger), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, _, err = store.Create("alice", "deploy", []Permission{PermPostsRead}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err = store.Create("alice", "old", []Permission{PermPostsRead}, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, valid, expired
+}
+
+func TestTokenStoreAuthenticate(t *testing.T) {
+	store, valid, expired := newTestTokenStore(t)
+	if !strings.HasPrefix(valid, apiTokenPrefix) {
+		t.Fatalf("secret %q does not start with %q", valid, apiTokenPrefix)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		want   bool
+	}{
+		{name: "valid token", secret: valid, want: true},
+		{name: "expired token", secret: expired},
+		{name: "missing prefix", secret: strings.TrimPrefix(valid, apiTokenPrefix)},
+		{name: "other prefix", secret: "ghp_" + strings.TrimPrefix(valid, apiTokenPrefix)},
+		{name: "unknown token", secret: valid + "x"},
+		{name: "empty", secret: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, ok := store.Authenticate(tt.secret)
+			if ok != tt.want {
+				t.Fatalf("Authenticate() = %v, want %v", ok, tt.want)
+			}
+			if ok && (token.Owner != "alice" || token.LastUsedAt == nil) {
+				t.Errorf("Authenticate() token = %+v, want alice's token with its last use", token)
+			}
+		})
+	}
+}
+
+func TestTokenStoreKeepsTokensAcrossLoads(t *testing.T) {
+	store, valid, _ := newTestTokenStore(t)
+	reloaded, err := NewTokenStore(store.path, store.fs, store.logger)
+	if err != nil {
+		t.Fatalf("NewTokenStore() error = %v", err)
+	}
+	token, ok := reloaded.Authenticate(valid)
+	if !ok || token.Name != "deploy" {
+		t.Errorf("Authenticate() after reload = %+v, %v, want the deploy token", token, ok)
+	}
+
+	if err := reloaded.Revoke(token.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if _, ok := reloaded.Authenticate(valid); ok {
+		t.Error("Authenticate() of a revoked token = true")
+	}
+}
+
+func TestUserCanIntersectsTokenScopes(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       User
+		permission Permission
+		want       bool
+	}{
+		{name: "session uses the role", user: User{Role: RoleAuthor}, permission: PermPostsWrite, want: true},
+		{name: "session outside the role", user: User{Role: RoleAuthor}, permission: PermPostsPublish},
+		{name: "scope within the role", user: User{Role: RoleAuthor, Scopes: []Permission{PermPostsWrite}}, permission: PermPostsWrite, want: true},
+		{name: "role without the scope", user: User{Role: RoleAdmin, Scopes: []Permission{PermPostsRead}}, permission: PermPostsWrite},
+		{name: "scope beyond the role", user: User{Role: RoleAuthor, Scopes: []Permission{PermConfigWrite}}, permission: PermConfigWrite},
+		{name: "token without scopes", user: User{Role: RoleAdmin, Scopes: []Permission{}}, permission: PermPostsRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.Can(tt.permission); got != tt.want {
+				t.Errorf("Can(%s) = %v, want %v", tt.permission, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserFromBearer(t *testing.T) {
+	app := newTestAuthApp(t)
+	app.auth.users.(*FileUserStore).users["alice"] = User{Username: "alice", Role: RoleAdmin}
+	store, valid, expired := newTestTokenStore(t)
+	app.auth.tokens = store
+	orphan, _, err := store.Create("bob", "left", nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := app.auth.userFromBearer("Bearer " + valid)
+	if err != nil {
+		t.Fatalf("userFromBearer() error = %v", err)
+	}
+	if user.Username != "alice" || user.TokenID == "" || user.Can(PermConfigWrite) || !user.Can(PermPostsRead) {
+		t.Errorf("userFromBearer() = %+v, want alice limited to reading posts", user)
+	}
+	if stored := app.auth.users.(*FileUserStore).users["alice"]; stored.Scopes != nil {
+		t.Errorf("stored user got the scopes of the token: %+v", stored)
+	}
+
+	for _, header := range []string{"Bearer " + expired, "Bearer " + orphan, "Basic " + valid, valid} {
+		var authErr *AuthError
+		if _, err := app.auth.userFromBearer(header); !errors.As(err, &authErr) {
+			t.Errorf("userFromBearer(%q) error = %v, want an AuthError", header, err)
+		}
+	}
+}
@@ -1,12 +1,44 @@
 package main
 
-// Shortcode represents a custom shortcode configuration
+import "time"
+
+// Shortcode represents a custom shortcode configuration.
+// Code is inserted as is, or with the values of its Params filled into their ${name} placeholders.
 type Shortcode struct {
-	ID      string `json:"id"`
-	Code    string `json:"code"`
-	Icon    string `json:"icon"`
-	Order   int    `json:"order"`
-	Tooltip string `json:"tooltip"`
+	ID      string           `json:"id" yaml:"id"`
+	Code    string           `json:"code" yaml:"code"`
+	Icon    string           `json:"icon" yaml:"icon"`
+	Order   int              `json:"order" yaml:"order"`
+	Tooltip string           `json:"tooltip" yaml:"tooltip"`
+	Params  []ShortcodeParam `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// ParamType is the kind of value a shortcode parameter takes
+type ParamType string
+
+// Types of shortcode parameters
+const (
+	ParamString ParamType = "string"
+	ParamBool   ParamType = "bool"
+	// ParamEnum takes one of the Options of the parameter
+	ParamEnum ParamType = "enum"
+	// ParamFile is a relative path below the site, such as "./config/_default/languages.toml"
+	ParamFile ParamType = "file"
+	// ParamMedia is the name of a file in the media folder
+	ParamMedia ParamType = "media"
+)
+
+// ShortcodeParam is a typed parameter of a shortcode, shown as a form field in the editor.
+// Default is a string for every type, such as "false" for a bool.
+type ShortcodeParam struct {
+	Name     string    `json:"name" yaml:"name"`
+	Type     ParamType `json:"type" yaml:"type"`
+	Label    string    `json:"label,omitempty" yaml:"label,omitempty"`
+	Default  string    `json:"default,omitempty" yaml:"default,omitempty"`
+	Required bool      `json:"required,omitempty" yaml:"required,omitempty"`
+	Options  []string  `json:"options,omitempty" yaml:"options,omitempty"`
+	// Pattern is a regular expression a string value must match as a whole
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
 }
 
 // Tag represents a blog post tag
@@ -37,6 +69,12 @@ type NewPostRequest struct {
 		Origin    string `json:"origin,omitempty"`
 	} `json:"thumbnail"`
 	Language string `json:"language"`
+	Section  string `json:"section"`
+
+	// Owner is the user creating the post, recorded in the front matter
+	Owner string `json:"-"`
+	// ForceDraft keeps the post a draft even if the archetype says otherwise
+	ForceDraft bool `json:"-"`
 }
 
 // ServerConfig represents server-specific configuration
@@ -44,21 +82,63 @@ type ServerConfig struct {
 	Port                int    `json:"port" mapstructure:"port"`
 	HTTPSPort           int    `json:"httpsPort" mapstructure:"httpsPort"`
 	ShowURLOnStart      bool   `json:"showURLOnStart" mapstructure:"showURLOnStart"`
-	GermanFolder        string `json:"germanFolder" mapstructure:"germanFolder"`
-	EnglishFolder       string `json:"englishFolder" mapstructure:"englishFolder"`
 	MediaFolder         string `json:"mediaFolder" mapstructure:"mediaFolder"`
 	AssetFolder         string `json:"assetFolder" mapstructure:"assetFolder"`
+	ArchetypeFolder     string `json:"archetypeFolder" mapstructure:"archetypeFolder"`
 	CertFile            string `json:"certFile" mapstructure:"certFile"`
 	KeyFile             string `json:"keyFile" mapstructure:"keyFile"`
 	RedirectHTTPToHTTPS bool   `json:"redirectHTTPToHTTPS" mapstructure:"redirectHTTPToHTTPS"`
+	KeepBackups         bool   `json:"keepBackups" mapstructure:"keepBackups"`
 	ImageResize         struct {
 		Method   string `json:"method" mapstructure:"method"`
 		MaxWidth int    `json:"maxWidth" mapstructure:"maxWidth"`
+		// Variants are the additional sizes each processed image is saved in
+		Variants []ImageVariant `json:"variants" mapstructure:"variants"`
+		// Metadata decides which EXIF data of a photo processed JPEG images keep. Artist, copyright
+		// and capture date are always kept, location and camera are dropped unless enabled here.
+		Metadata struct {
+			KeepLocation bool `json:"keepLocation" mapstructure:"keepLocation"`
+			KeepDevice   bool `json:"keepDevice" mapstructure:"keepDevice"`
+		} `json:"metadata" mapstructure:"metadata"`
 	} `json:"imageResize" mapstructure:"imageResize"`
 	ThumbnailResize struct {
 		Method   string `json:"method" mapstructure:"method"`
 		MaxWidth int    `json:"maxWidth" mapstructure:"maxWidth"`
 	} `json:"thumbnailResize" mapstructure:"thumbnailResize"`
+	// ShutdownTimeout is how long a shutdown waits for running requests, saves and image processing
+	ShutdownTimeout time.Duration `json:"shutdownTimeout" mapstructure:"shutdownTimeout"`
+}
+
+// ImageVariant is a named size processed images are saved in, such as one width of a srcset
+type ImageVariant struct {
+	Name  string `json:"name" mapstructure:"name"`
+	Width int    `json:"width" mapstructure:"width"`
+	// Height is the size "fill" crops to. "fit" uses it as an upper bound, "resize" as an exact height.
+	// Without it the height follows the aspect ratio of the image.
+	Height int    `json:"height,omitempty" mapstructure:"height"`
+	Method string `json:"method" mapstructure:"method"`
+	// Format is the output format: "jpeg", "png", "webp" or "avif". Empty keeps the format of the source image.
+	Format string `json:"format,omitempty" mapstructure:"format"`
+	// Quality from 1 to 100, 0 uses the default of 85. It is the JPEG quality and for PNG the compression level.
+	// Lossless WebP has no quality, there it is the quality of the source format onlyIfSmaller falls back to.
+	Quality int `json:"quality,omitempty" mapstructure:"quality"`
+	// OnlyIfSmaller keeps the format only when the file is smaller than in the format of the source image,
+	// otherwise the variant is saved in the source format at the variant quality
+	OnlyIfSmaller bool `json:"onlyIfSmaller,omitempty" mapstructure:"onlyIfSmaller"`
+}
+
+// LanguageConfig represents a content language of the site
+type LanguageConfig struct {
+	ContentFolder string `json:"contentFolder" mapstructure:"contentFolder"`
+	Name          string `json:"name" mapstructure:"name"`
+	Default       bool   `json:"default" mapstructure:"default"`
+}
+
+// SectionConfig represents a Hugo content section, a folder below each language's content folder
+type SectionConfig struct {
+	Name      string `json:"name" mapstructure:"name"`
+	Archetype string `json:"archetype,omitempty" mapstructure:"archetype"`
+	Default   bool   `json:"default" mapstructure:"default"`
 }
 
 // SecretsConfig holds secret configuration values
@@ -67,10 +147,54 @@ type SecretsConfig struct {
 }
 
 // Config represents the main application configuration
+// HistoryConfig controls the git commits recorded for every save
+type HistoryConfig struct {
+	Enabled     bool   `json:"enabled" mapstructure:"enabled"`
+	AuthorName  string `json:"authorName" mapstructure:"authorName"`
+	AuthorEmail string `json:"authorEmail" mapstructure:"authorEmail"`
+}
+
+// AuthConfig controls the login required for the admin API
+type AuthConfig struct {
+	Enabled      bool          `json:"enabled" mapstructure:"enabled"`
+	UsersFile    string        `json:"usersFile" mapstructure:"usersFile"`
+	TokensFile   string        `json:"tokensFile" mapstructure:"tokensFile"`
+	CookieName   string        `json:"cookieName" mapstructure:"cookieName"`
+	SecureCookie bool          `json:"secureCookie" mapstructure:"secureCookie"`
+	SessionTTL   time.Duration `json:"sessionTTL" mapstructure:"sessionTTL"`
+}
+
+// AuditConfig controls the audit log of content and media changes
+type AuditConfig struct {
+	Enabled    bool   `json:"enabled" mapstructure:"enabled"`
+	File       string `json:"file" mapstructure:"file"`
+	MaxSizeMB  int    `json:"maxSizeMB" mapstructure:"maxSizeMB"`
+	MaxBackups int    `json:"maxBackups" mapstructure:"maxBackups"`
+}
+
 type Config struct {
-	Shortcodes []Shortcode   `json:"shortcodes" mapstructure:"shortcodes"`
-	Server     ServerConfig  `json:"server" mapstructure:"server"`
-	Secrets    SecretsConfig `json:"secrets" mapstructure:"secrets"`
+	Shortcodes []Shortcode               `json:"shortcodes" mapstructure:"shortcodes"`
+	Languages  map[string]LanguageConfig `json:"languages" mapstructure:"languages"`
+	Sections   []SectionConfig           `json:"sections" mapstructure:"sections"`
+	Server     ServerConfig              `json:"server" mapstructure:"server"`
+	History    HistoryConfig             `json:"history" mapstructure:"history"`
+	Auth       AuthConfig                `json:"auth" mapstructure:"auth"`
+	Audit      AuditConfig               `json:"audit" mapstructure:"audit"`
+	Secrets    SecretsConfig             `json:"secrets" mapstructure:"secrets"`
+}
+
+// ClientConfig is the part of the configuration the editor in the browser needs.
+// Folders, authentication, audit settings and secrets stay on the server.
+type ClientConfig struct {
+	Shortcodes []Shortcode               `json:"shortcodes"`
+	Languages  map[string]ClientLanguage `json:"languages"`
+	Sections   []SectionConfig           `json:"sections"`
+}
+
+// ClientLanguage is a content language as shown in the editor
+type ClientLanguage struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
 }
 
 // TagsData represents the structure for storing tags
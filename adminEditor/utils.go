@@ -1,13 +1,22 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+// defaultSection is the section new posts are created in when no sections are configured
+const defaultSection = "blog"
+
 func listFiles(dir string, fs FileSystem) ([]string, error) {
 	var files []string
+	if _, err := fs.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		// A newly configured language may not have any content yet
+		return []string{}, nil
+	}
 	err := fs.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -27,19 +36,29 @@ func listFiles(dir string, fs FileSystem) ([]string, error) {
 	return files, nil
 }
 
-func getFullPath(filename string, config Config) string {
-	parts := strings.SplitN(filename, "/", 2)
-	if len(parts) != 2 {
-		return filename
+// languageFolder returns the content folder of the given language code
+func languageFolder(lang string, config Config) (string, error) {
+	language, ok := config.Language(lang)
+	if !ok {
+		return "", NewValidationError("language", fmt.Sprintf("Unknown language '%s'", lang), nil)
 	}
+	return language.ContentFolder, nil
+}
 
-	lang, file := parts[0], parts[1]
-	switch lang {
-	case "de":
-		return filepath.Join(config.Server.GermanFolder, file)
-	case "en":
-		return filepath.Join(config.Server.EnglishFolder, file)
-	default:
-		return filename
+// getFullPath resolves a "<lang>/<section>/<file>" reference to a path on disk
+func getFullPath(filename string, config Config) (string, error) {
+	parts := strings.SplitN(filename, "/", 3)
+	if len(parts) != 3 {
+		return "", NewValidationError("filename", "Filename must be prefixed with a language code and section", nil)
+	}
+
+	lang, section, file := parts[0], parts[1], parts[2]
+	if err := validateRelativePath("filename", file); err != nil {
+		return "", err
+	}
+	folder, err := sectionFolder(lang, section, config)
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(folder, file), nil
 }
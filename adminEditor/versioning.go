@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// contentETag builds a strong ETag from the content hash and modification time of a file
+func contentETag(content []byte, modTime time.Time) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf(`"%s-%x"`, hex.EncodeToString(sum[:16]), modTime.UnixNano())
+}
+
+// etagMatches reports whether an If-Match header accepts the current ETag.
+// An empty current ETag means the file does not exist, which only "*" does not accept.
+func etagMatches(header, current string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return current != ""
+		}
+		if current != "" && strings.TrimPrefix(candidate, "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+// currentRevision reads a file together with its ETag.
+// A missing file is not an error, it is reported with an empty ETag.
+func (app *Application) currentRevision(fullPath string) ([]byte, string, error) {
+	info, err := app.fileSystem.Stat(fullPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := app.fileSystem.ReadFile(fullPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, contentETag(content, info.ModTime()), nil
+}
+
+// handleMerge merges the editor's changes with the current server copy of a post.
+// The client sends the version it originally loaded as base, so edits to different lines can be combined.
+// The merged content is returned, not saved, together with the ETag to save it against.
+func (app *Application) handleMerge(w http.ResponseWriter, r *http.Request) error {
+	logger := GetLoggerFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		logger.Warn("handleMerge: Method not allowed", zap.String("method", r.Method))
+		return NewValidationError("method", "Method not allowed", nil)
+	}
+
+	filename := r.URL.Query().Get("file")
+	if filename == "" {
+		logger.Warn("handleMerge: Filename is required")
+		return NewValidationError("filename", "Filename is required", nil)
+	}
+
+	var request struct {
+		Base    string `json:"base"`
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.Error("handleMerge: Invalid request body", zap.Error(err))
+		return NewValidationError("request_body", "Invalid request body", err)
+	}
+
+	fullPath, err := getFullPath(filename, app.configProvider.GetConfig())
+	if err != nil {
+		logger.Warn("handleMerge: Invalid filename", zap.String("filename", filename), zap.Error(err))
+		return err
+	}
+	server, etag, err := app.currentRevision(fullPath)
+	if err != nil {
+		return err
+	}
+
+	response := struct {
+		MergeResult
+		ETag string `json:"etag"`
+	}{
+		MergeResult: mergeThreeWay(request.Base, request.Content, string(server)),
+		ETag:        etag,
+	}
+
+	logger.Info("handleMerge: Merged changes with server copy",
+		zap.String("path", fullPath),
+		zap.Int("conflicts", response.Conflicts),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
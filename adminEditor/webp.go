@@ -0,0 +1,592 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// The lossless WebP (VP8L) bitstream is described in
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification.
+// The encoder below uses the subtract green and predictor transforms, LZ77 backward references
+// and one set of prefix codes for the whole image. That covers screenshots and graphics well;
+// photos are usually smaller as JPEG, which is what the "onlyIfSmaller" rule of a variant is for.
+
+const (
+	vp8lSignature       = 0x2f
+	vp8lMaxSize         = 1 << 14
+	vp8lPredictorBits   = 4
+	vp8lMaxLength       = 4096
+	vp8lMinLength       = 3
+	vp8lMaxCodeLength   = 15
+	vp8lMaxLengthLength = 7
+	vp8lHashBits        = 16
+	// vp8lWindow is the largest distance a backward reference can encode
+	vp8lWindow = 1<<20 - 120
+)
+
+// vp8lAlphabetSizes are the sizes of the green, red, blue, alpha and distance alphabets
+var vp8lAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+// vp8lCodeLengthOrder is the order the lengths of the code length code are written in
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lDistanceMap are the short distance codes for the pixels around the current one, as yOffset<<4 | (8-xOffset)
+var vp8lDistanceMap = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// encodeWebP writes an image as a lossless WebP file. Effort from 1 to 100 sets how long
+// the encoder searches for repeated pixels, more effort gives smaller files.
+func encodeWebP(w io.Writer, img image.Image, effort int) error {
+	src := imaging.Clone(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return fmt.Errorf("webp: image size %dx%d is not between 1x1 and %dx%d", width, height, vp8lMaxSize, vp8lMaxSize)
+	}
+
+	argb := make([]uint32, width*height)
+	alphaUsed := false
+	for i := range argb {
+		p := src.Pix[4*i : 4*i+4]
+		argb[i] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+		if p[3] != 0xff {
+			alphaUsed = true
+		}
+	}
+
+	e := &vp8lEncoder{chainLength: 4 + effort/2}
+	e.write(vp8lSignature, 8)
+	e.write(uint32(width-1), 14)
+	e.write(uint32(height-1), 14)
+	if alphaUsed {
+		e.write(1, 1)
+	} else {
+		e.write(0, 1)
+	}
+	e.write(0, 3)
+
+	// Subtract green, then predict each pixel from its neighbours. The decoder undoes them in reverse order.
+	subtractGreen(argb)
+	e.write(1, 1)
+	e.write(2, 2)
+	modes := predict(argb, width, height)
+	e.write(1, 1)
+	e.write(0, 2)
+	e.write(vp8lPredictorBits-2, 3)
+	e.writeImage(modes, vp8lTiles(width), false)
+	e.write(0, 1)
+
+	e.writeImage(argb, width, true)
+	data := e.bytes()
+
+	chunkSize := len(data)
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunkSize))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// vp8lEncoder writes the VP8L bitstream, least significant bit first
+type vp8lEncoder struct {
+	buf         []byte
+	bits        uint64
+	nBits       uint
+	chainLength int
+}
+
+func (e *vp8lEncoder) write(value uint32, n uint) {
+	e.bits |= uint64(value) << e.nBits
+	e.nBits += n
+	for e.nBits >= 8 {
+		e.buf = append(e.buf, byte(e.bits))
+		e.bits >>= 8
+		e.nBits -= 8
+	}
+}
+
+func (e *vp8lEncoder) bytes() []byte {
+	if e.nBits > 0 {
+		e.buf = append(e.buf, byte(e.bits))
+		e.bits, e.nBits = 0, 0
+	}
+	return e.buf
+}
+
+// vp8lTiles returns the number of predictor tiles that cover size pixels
+func vp8lTiles(size int) int {
+	return (size + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits
+}
+
+// subtractGreen subtracts the green channel from red and blue, which are often correlated with it
+func subtractGreen(argb []uint32) {
+	for i, p := range argb {
+		green := (p >> 8) & 0xff
+		red := ((p >> 16) - green) & 0xff
+		blue := (p - green) & 0xff
+		argb[i] = p&0xff00ff00 | red<<16 | blue
+	}
+}
+
+// predict replaces the pixels by their difference to a prediction from the pixels left and above.
+// Each tile uses the predictor with the smallest differences, the modes are returned as a sub-image.
+func predict(argb []uint32, width, height int) []uint32 {
+	tilesX, tilesY := vp8lTiles(width), vp8lTiles(height)
+	modes := make([]uint32, tilesX*tilesY)
+	tileModes := make([]int, tilesX*tilesY)
+	// Select (11) and ClampAddSubtractHalf (13) rarely win and are left out
+	candidates := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 12}
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			bestMode, bestCost := 0, -1
+			for _, mode := range candidates {
+				cost := 0
+				for y := ty << vp8lPredictorBits; y < min(height, (ty+1)<<vp8lPredictorBits); y++ {
+					for x := tx << vp8lPredictorBits; x < min(width, (tx+1)<<vp8lPredictorBits); x++ {
+						i := y*width + x
+						cost += residualCost(subPixels(argb[i], predictPixel(argb, width, x, y, mode)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+			tileModes[ty*tilesX+tx] = bestMode
+			modes[ty*tilesX+tx] = 0xff000000 | uint32(bestMode)<<8
+		}
+	}
+
+	// Going backwards keeps the neighbours of each pixel unchanged until it is predicted
+	for y := height - 1; y >= 0; y-- {
+		for x := width - 1; x >= 0; x-- {
+			i := y*width + x
+			mode := tileModes[(y>>vp8lPredictorBits)*tilesX+x>>vp8lPredictorBits]
+			argb[i] = subPixels(argb[i], predictPixel(argb, width, x, y, mode))
+		}
+	}
+	return modes
+}
+
+// predictPixel predicts a pixel with a predictor mode. The top row and the left column always
+// use the pixel left and above, and the first pixel is predicted as opaque black.
+func predictPixel(argb []uint32, width, x, y, mode int) uint32 {
+	i := y*width + x
+	switch {
+	case y == 0 && x == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[i-1]
+	case x == 0:
+		return argb[i-width]
+	}
+	left, top, topLeft := argb[i-1], argb[i-width], argb[i-width-1]
+	// On the last column the pixel after the top one is the first of the current row
+	topRight := argb[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return left
+	case 2:
+		return top
+	case 3:
+		return topRight
+	case 4:
+		return topLeft
+	case 5:
+		return average2(average2(left, topRight), top)
+	case 6:
+		return average2(left, topLeft)
+	case 7:
+		return average2(left, top)
+	case 8:
+		return average2(topLeft, top)
+	case 9:
+		return average2(top, topRight)
+	case 10:
+		return average2(average2(left, topLeft), average2(top, topRight))
+	default:
+		return clampAddSubtractFull(left, top, topLeft)
+	}
+}
+
+func average2(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var result uint32
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(a>>shift&0xff) + int(b>>shift&0xff) - int(c>>shift&0xff)
+		result |= uint32(min(max(v, 0), 255)) << shift
+	}
+	return result
+}
+
+// subPixels subtracts two pixels channel by channel
+func subPixels(a, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	redBlue := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// residualCost estimates how expensive a difference is to encode, small differences either way are cheap
+func residualCost(p uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(int8(p >> shift))
+		if v < 0 {
+			v = -v
+		}
+		cost += v
+	}
+	return cost
+}
+
+// vp8lToken is a literal pixel or a backward reference
+type vp8lToken struct {
+	argb     uint32
+	length   int
+	distance int
+}
+
+// writeImage entropy codes pixels with a single set of prefix codes and no color cache.
+// Only the main image has the bit for a meta prefix code image.
+func (e *vp8lEncoder) writeImage(argb []uint32, width int, topLevel bool) {
+	e.write(0, 1)
+	if topLevel {
+		e.write(0, 1)
+	}
+
+	tokens := e.backwardReferences(argb, width)
+	var histograms [5][]uint32
+	for i, size := range vp8lAlphabetSizes {
+		histograms[i] = make([]uint32, size)
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			histograms[0][t.argb>>8&0xff]++
+			histograms[1][t.argb>>16&0xff]++
+			histograms[2][t.argb&0xff]++
+			histograms[3][t.argb>>24]++
+			continue
+		}
+		symbol, _, _ := vp8lPrefix(t.length)
+		histograms[0][256+symbol]++
+		symbol, _, _ = vp8lPrefix(t.distance)
+		histograms[4][symbol]++
+	}
+
+	var codes [5]prefixCode
+	for i := range codes {
+		codes[i] = newPrefixCode(histograms[i], vp8lMaxCodeLength)
+		e.writePrefixCode(codes[i])
+	}
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].writeSymbol(e, int(t.argb>>8&0xff))
+			codes[1].writeSymbol(e, int(t.argb>>16&0xff))
+			codes[2].writeSymbol(e, int(t.argb&0xff))
+			codes[3].writeSymbol(e, int(t.argb>>24))
+			continue
+		}
+		symbol, n, extra := vp8lPrefix(t.length)
+		codes[0].writeSymbol(e, 256+symbol)
+		e.write(extra, n)
+		symbol, n, extra = vp8lPrefix(t.distance)
+		codes[4].writeSymbol(e, symbol)
+		e.write(extra, n)
+	}
+}
+
+// backwardReferences finds repeated runs of pixels with a hash chain and returns literals and references.
+// Distances are returned as distance codes, which are short for the pixels around the current one.
+func (e *vp8lEncoder) backwardReferences(argb []uint32, width int) []vp8lToken {
+	distanceCodes := make(map[int]int, len(vp8lDistanceMap))
+	for i := len(vp8lDistanceMap) - 1; i >= 0; i-- {
+		yOffset, xOffset := int(vp8lDistanceMap[i]>>4), 8-int(vp8lDistanceMap[i]&0xf)
+		distanceCodes[max(yOffset*width+xOffset, 1)] = i + 1
+	}
+	distanceCode := func(distance int) int {
+		if code, ok := distanceCodes[distance]; ok {
+			return code
+		}
+		return distance + len(vp8lDistanceMap)
+	}
+
+	n := len(argb)
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, n)
+	hash := func(i int) uint32 {
+		return ((argb[i]*0x9e3779b1 + argb[i+1]) * 0x85ebca77) >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < n {
+			h := hash(i)
+			chain[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	matchLength := func(i, j, limit int) int {
+		length := 0
+		for length < limit && argb[i+length] == argb[j+length] {
+			length++
+		}
+		return length
+	}
+
+	tokens := make([]vp8lToken, 0, n/2)
+	for i := 0; i < n; {
+		limit := min(vp8lMaxLength, n-i)
+		bestLength, bestDistance := 0, 0
+		if limit >= vp8lMinLength {
+			// The pixels left and above have the shortest distance codes, so they are tried first
+			for _, distance := range []int{1, width} {
+				if distance <= i {
+					if length := matchLength(i, i-distance, limit); length > bestLength {
+						bestLength, bestDistance = length, distance
+					}
+				}
+			}
+			if i+1 < n {
+				candidate := head[hash(i)]
+				for steps := 0; candidate >= 0 && steps < e.chainLength && i-int(candidate) <= vp8lWindow; steps++ {
+					if length := matchLength(i, int(candidate), limit); length > bestLength {
+						bestLength, bestDistance = length, i-int(candidate)
+					}
+					candidate = chain[candidate]
+				}
+			}
+		}
+
+		if bestLength < vp8lMinLength {
+			tokens = append(tokens, vp8lToken{argb: argb[i]})
+			insert(i)
+			i++
+			continue
+		}
+		tokens = append(tokens, vp8lToken{length: bestLength, distance: distanceCode(bestDistance)})
+		for j := i; j < i+bestLength; j++ {
+			insert(j)
+		}
+		i += bestLength
+	}
+	return tokens
+}
+
+// vp8lPrefix splits a length or distance code into a prefix symbol and its extra bits
+func vp8lPrefix(value int) (symbol int, n uint, extra uint32) {
+	d := value - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	high := bits.Len(uint(d)) - 1
+	n = uint(high - 1)
+	return 2*high + (d>>n)&1, n, uint32(d) & (1<<n - 1)
+}
+
+// prefixCode is a canonical prefix code. Codes are stored bit reversed, as the bitstream is least significant bit first.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+	// single is set when only one symbol is used, which the decoder reads without any bits
+	single bool
+}
+
+// newPrefixCode builds a prefix code for a histogram, with no code longer than maxLength.
+// An alphabet without any used symbol still gets a code, the decoder needs at least one length.
+func newPrefixCode(histogram []uint32, maxLength int) prefixCode {
+	code := prefixCode{lengths: make([]uint8, len(histogram)), codes: make([]uint16, len(histogram))}
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	switch len(used) {
+	case 0:
+		code.lengths[0] = 1
+		code.single = true
+		return code
+	case 1:
+		code.lengths[used[0]] = 1
+		code.single = true
+		return code
+	}
+
+	// Raising rare counts flattens the tree until it fits into maxLength
+	for floor := uint32(0); ; floor = floor*2 + 1 {
+		if huffmanLengths(histogram, used, floor, code.lengths) <= maxLength {
+			break
+		}
+	}
+
+	var lengthCount [vp8lMaxCodeLength + 1]int
+	for _, length := range code.lengths {
+		if length > 0 {
+			lengthCount[length]++
+		}
+	}
+	var next [vp8lMaxCodeLength + 1]int
+	for length, c := 1, 0; length <= vp8lMaxCodeLength; length++ {
+		c = (c + lengthCount[length-1]) << 1
+		next[length] = c
+	}
+	for symbol, length := range code.lengths {
+		if length > 0 {
+			code.codes[symbol] = uint16(bits.Reverse16(uint16(next[length])) >> (16 - length))
+			next[length]++
+		}
+	}
+	return code
+}
+
+// huffmanLengths sets the Huffman code lengths of the used symbols, counting each at least floor,
+// and returns the longest length
+func huffmanLengths(histogram []uint32, used []int, floor uint32, lengths []uint8) int {
+	type node struct {
+		weight uint64
+		parent int
+	}
+	leaves := append([]int(nil), used...)
+	weight := func(symbol int) uint64 {
+		return uint64(max(histogram[symbol], floor))
+	}
+	sort.SliceStable(leaves, func(a, b int) bool {
+		return weight(leaves[a]) < weight(leaves[b])
+	})
+
+	// Two queues: the sorted leaves and the merged nodes, which are created in order of weight
+	nodes := make([]node, len(leaves), 2*len(leaves)-1)
+	for i, symbol := range leaves {
+		nodes[i] = node{weight: weight(symbol)}
+	}
+	nextLeaf, nextMerged := 0, len(leaves)
+	take := func() int {
+		if nextLeaf < len(leaves) && (nextMerged >= len(nodes) || nodes[nextLeaf].weight <= nodes[nextMerged].weight) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextMerged++
+		return nextMerged - 1
+	}
+	for len(nodes) < cap(nodes) {
+		a, b := take(), take()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight})
+		nodes[a].parent, nodes[b].parent = len(nodes)-1, len(nodes)-1
+	}
+
+	depth := make([]int, len(nodes))
+	longest := 0
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depth[i] = depth[nodes[i].parent] + 1
+		if i < len(leaves) {
+			longest = max(longest, depth[i])
+		}
+	}
+	for i, symbol := range leaves {
+		lengths[symbol] = uint8(min(depth[i], 255))
+	}
+	return longest
+}
+
+func (code prefixCode) writeSymbol(e *vp8lEncoder, symbol int) {
+	if !code.single {
+		e.write(uint32(code.codes[symbol]), uint(code.lengths[symbol]))
+	}
+}
+
+// writePrefixCode writes the code lengths of a prefix code, run length encoded and with a prefix code of their own
+func (e *vp8lEncoder) writePrefixCode(code prefixCode) {
+	type lengthToken struct {
+		symbol int
+		n      uint
+		extra  uint32
+	}
+	var tokens []lengthToken
+	lengths := code.lengths
+	for i := 0; i < len(lengths); {
+		length := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == length {
+			run++
+		}
+		i += run
+		if length == 0 {
+			for run >= 11 {
+				r := min(run, 138)
+				tokens = append(tokens, lengthToken{18, 7, uint32(r - 11)})
+				run -= r
+			}
+			if run >= 3 {
+				tokens = append(tokens, lengthToken{17, 3, uint32(run - 3)})
+				run = 0
+			}
+		} else {
+			// 16 repeats the previous length, so the length itself is written first
+			tokens = append(tokens, lengthToken{symbol: int(length)})
+			run--
+			for run >= 3 {
+				r := min(run, 6)
+				tokens = append(tokens, lengthToken{16, 2, uint32(r - 3)})
+				run -= r
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, lengthToken{symbol: int(length)})
+		}
+	}
+
+	histogram := make([]uint32, len(vp8lCodeLengthOrder))
+	for _, t := range tokens {
+		histogram[t.symbol]++
+	}
+	lengthCode := newPrefixCode(histogram, vp8lMaxLengthLength)
+
+	count := 4
+	for i, symbol := range vp8lCodeLengthOrder {
+		if lengthCode.lengths[symbol] > 0 {
+			count = max(count, i+1)
+		}
+	}
+	e.write(0, 1)
+	e.write(uint32(count-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:count] {
+		e.write(uint32(lengthCode.lengths[symbol]), 3)
+	}
+	// All lengths are written, so there is no max_symbol
+	e.write(0, 1)
+	for _, t := range tokens {
+		lengthCode.writeSymbol(e, t.symbol)
+		e.write(t.extra, t.n)
+	}
+}
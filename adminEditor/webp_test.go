@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testImage fills an NRGBA image with the colors a function returns for each pixel
+func testImage(width, height int, pixel func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, pixel(x, y))
+		}
+	}
+	return img
+}
+
+func gradient(x, y int) color.NRGBA {
+	return color.NRGBA{R: uint8(x * 7), G: uint8(y * 5), B: uint8(x + y), A: 255}
+}
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	noise := func(x, y int) color.NRGBA {
+		return color.NRGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: uint8(random.Intn(256))}
+	}
+	stripes := func(x, y int) color.NRGBA {
+		if (x/4+y/3)%2 == 0 {
+			return color.NRGBA{R: 200, G: 30, B: 30, A: 255}
+		}
+		return color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{name: "opaque", img: testImage(64, 48, gradient)},
+		{name: "alpha", img: testImage(64, 48, func(x, y int) color.NRGBA {
+			c := gradient(x, y)
+			c.A = uint8(x * 4)
+			return c
+		})},
+		{name: "1x1", img: testImage(1, 1, func(x, y int) color.NRGBA { return color.NRGBA{R: 10, G: 20, B: 30, A: 40} })},
+		{name: "single row", img: testImage(50, 1, gradient)},
+		{name: "single column", img: testImage(1, 50, gradient)},
+		{name: "noise", img: testImage(97, 61, noise)},
+		{name: "repeated pattern", img: testImage(200, 120, stripes)},
+		{name: "sub-image", img: testImage(64, 48, gradient).SubImage(image.Rect(10, 5, 40, 30))},
+		{name: "gray", img: func() image.Image {
+			img := image.NewGray(image.Rect(0, 0, 33, 17))
+			for i := range img.Pix {
+				img.Pix[i] = uint8(i * 3)
+			}
+			return img
+		}()},
+	}
+
+	for _, tt := range tests {
+		for _, effort := range []int{1, webpEffort, 100} {
+			t.Run(fmt.Sprintf("%s effort %d", tt.name, effort), func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := encodeWebP(&buf, tt.img, effort); err != nil {
+					t.Fatalf("encodeWebP() error = %v", err)
+				}
+				decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("webp.Decode() error = %v", err)
+				}
+				compareImages(t, tt.img, decoded)
+			})
+		}
+	}
+}
+
+// compareImages reports the first pixel that differs between two images, compared as non-premultiplied colors
+func compareImages(t *testing.T, want, got image.Image) {
+	t.Helper()
+	wantBounds, gotBounds := want.Bounds(), got.Bounds()
+	if wantBounds.Dx() != gotBounds.Dx() || wantBounds.Dy() != gotBounds.Dy() {
+		t.Fatalf("decoded size = %dx%d, want %dx%d", gotBounds.Dx(), gotBounds.Dy(), wantBounds.Dx(), wantBounds.Dy())
+	}
+	for y := 0; y < wantBounds.Dy(); y++ {
+		for x := 0; x < wantBounds.Dx(); x++ {
+			wantColor := color.NRGBAModel.Convert(want.At(wantBounds.Min.X+x, wantBounds.Min.Y+y))
+			gotColor := color.NRGBAModel.Convert(got.At(gotBounds.Min.X+x, gotBounds.Min.Y+y))
+			if wantColor != gotColor {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, gotColor, wantColor)
+			}
+		}
+	}
+}
+
+func TestEncodeWebPRejectsSize(t *testing.T) {
+	for _, size := range []image.Rectangle{
+		image.Rect(0, 0, 0, 0),
+		image.Rect(0, 0, vp8lMaxSize+1, 1),
+		image.Rect(0, 0, 1, vp8lMaxSize+1),
+	} {
+		if err := encodeWebP(io.Discard, image.NewNRGBA(size), webpEffort); err == nil {
+			t.Errorf("encodeWebP() of a %dx%d image error = nil, want an error", size.Dx(), size.Dy())
+		}
+	}
+}